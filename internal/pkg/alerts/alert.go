@@ -1,46 +1,232 @@
 package alert
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/parnurzeal/gorequest"
 	"github.com/sirupsen/logrus"
 )
 
-// function to send alert msg to webhook service
+// Severity of an alert, sinks map it to the levels of their service
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityError    Severity = "error"
+	SeverityCritical Severity = "critical"
+)
+
+// ParseSeverity returns the severity for the value, the value is case insensitive
+func ParseSeverity(value string) (Severity, error) {
+	severity := Severity(strings.ToLower(strings.TrimSpace(value)))
+	switch severity {
+	case SeverityInfo, SeverityWarning, SeverityError, SeverityCritical:
+		return severity, nil
+	}
+	return "", fmt.Errorf("invalid severity '%s', expected one of info, warning, error or critical", value)
+}
+
+// Alert is the message sent to the sinks
+type Alert struct {
+	Message  string
+	Severity Severity
+	// Sinks limits the configured sinks the alert is sent to by their names, it is sent to all sinks if empty
+	Sinks []string
+}
+
+// AlertSink delivers alerts to a service
+type AlertSink interface {
+	// Name returns the name the sink is registered with
+	Name() string
+	// Send delivers the alert
+	Send(alert Alert) error
+}
+
+// SinkFactory creates a sink from its configuration
+type SinkFactory func(config SinkConfig) (AlertSink, error)
+
+// SinkConfig gives a sink access to its settings
+type SinkConfig struct {
+	// Sink is the name of the sink the settings are read for
+	Sink   string
+	lookup func(string) (string, bool)
+}
+
+// SinkValue returns the sink specific setting ALERT_<SINK>_<KEY>
+func (c SinkConfig) SinkValue(key string) string {
+	value, _ := c.lookup(fmt.Sprintf("ALERT_%s_%s", strings.ToUpper(c.Sink), key))
+	return strings.TrimSpace(value)
+}
+
+// Value returns the sink specific setting ALERT_<SINK>_<KEY> and falls back to the shared setting ALERT_<KEY>
+func (c SinkConfig) Value(key string) string {
+	if value := c.SinkValue(key); value != "" {
+		return value
+	}
+	value, _ := c.lookup("ALERT_" + key)
+	return strings.TrimSpace(value)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]SinkFactory{}
+
+	sinksOnce sync.Once
+	sinks     []AlertSink
+)
+
+func init() {
+	RegisterSink("slack", newSlackSink)
+	RegisterSink("teams", newTeamsSink)
+	RegisterSink("gchat", newGoogleChatSink)
+	RegisterSink("webhook", newRawWebhookSink)
+	RegisterSink("raw", newRawWebhookSink)
+	RegisterSink("discord", newDiscordSink)
+	RegisterSink("mattermost", newMattermostSink)
+	RegisterSink("pagerduty", newPagerDutySink)
+	RegisterSink("opsgenie", newOpsgenieSink)
+	RegisterSink("smtp", newSMTPSink)
+}
+
+// RegisterSink makes a sink available under the name used in ALERT_SINK, an existing sink with the same name is replaced
+func RegisterSink(name string, factory SinkFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(name)] = factory
+}
+
+// RegisteredSinks returns the sorted names of all registered sinks
+func RegisteredSinks() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewSinks creates the sinks listed comma separated in ALERT_SINK, the raw webhook sink is used if none is set and
+// for unknown sinks. Sinks which can not be created are reported in the error, the others are returned
+func NewSinks(lookup func(string) (string, bool)) ([]AlertSink, error) {
+	names, _ := lookup("ALERT_SINK")
+	if strings.TrimSpace(names) == "" {
+		names = "webhook"
+	}
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var result []AlertSink
+	var errs []error
+	seen := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		factory, found := registry[name]
+		if !found {
+			// Unknown sinks were sent to the raw webhook before sinks were pluggable
+			logrus.Warnf("Unknown alert sink '%s', sending its alerts to the raw webhook", name)
+			name = "webhook"
+			factory = registry[name]
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		sink, err := factory(SinkConfig{Sink: name, lookup: lookup})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("alert sink '%s': %w", name, err))
+			continue
+		}
+		result = append(result, sink)
+	}
+	return result, errors.Join(errs...)
+}
+
+// getSinks creates the configured sinks from the environment once
+func getSinks() []AlertSink {
+	sinksOnce.Do(func() {
+		var err error
+		sinks, err = NewSinks(os.LookupEnv)
+		if err != nil {
+			logrus.Errorf("Failed to configure alert sinks: %v", err)
+		}
+	})
+	return sinks
+}
+
+// function to send alert msg to the configured sinks
 func SendWebhookAlert(msg string) {
-	webhook_url, ok := os.LookupEnv("ALERT_WEBHOOK_URL")
-	if !ok {
-		logrus.Error("ALERT_WEBHOOK_URL env variable not provided")
+	Send(Alert{Message: msg, Severity: SeverityInfo})
+}
+
+// SendFailureAlert sends the msg to the configured sinks if ALERT_ON_FAILURE is enabled, the severity is
+// taken from ALERT_FAILURE_SEVERITY and defaults to error
+func SendFailureAlert(msg string) {
+	alert_on_failure, ok := os.LookupEnv("ALERT_ON_FAILURE")
+	if !ok || alert_on_failure != "true" {
 		return
 	}
-	webhook_url = strings.TrimSpace(webhook_url)
-	alert_sink := os.Getenv("ALERT_SINK")
-	alert_sink = strings.ToLower(strings.TrimSpace(alert_sink))
 
-	// Provision to add Proxy to reach webhook server if required
-	webhook_proxy := os.Getenv("ALERT_WEBHOOK_PROXY")
-	webhook_proxy = strings.TrimSpace(webhook_proxy)
+	severity := SeverityError
+	if value, ok := os.LookupEnv("ALERT_FAILURE_SEVERITY"); ok && strings.TrimSpace(value) != "" {
+		var err error
+		severity, err = ParseSeverity(value)
+		if err != nil {
+			logrus.Errorf("Invalid ALERT_FAILURE_SEVERITY, sending failure alert with severity error: %v", err)
+			severity = SeverityError
+		}
+	}
+
+	Send(Alert{Message: msg, Severity: severity})
+}
 
+// Send delivers the alert to the configured sinks
+func Send(alert Alert) {
 	// Provision to add Additional information in the alert. e.g ClusterName
 	alert_additional_info, ok := os.LookupEnv("ALERT_ADDITIONAL_INFO")
 	if ok {
 		alert_additional_info = strings.TrimSpace(alert_additional_info)
-		msg = fmt.Sprintf("%s : %s", alert_additional_info, msg)
+		alert.Message = fmt.Sprintf("%s : %s", alert_additional_info, alert.Message)
+	}
+
+	send(getSinks(), alert)
+}
+
+func send(sinks []AlertSink, alert Alert) {
+	for _, sink := range sinks {
+		if len(alert.Sinks) > 0 && !slices.Contains(alert.Sinks, sink.Name()) {
+			continue
+		}
+		if err := sink.Send(alert); err != nil {
+			logrus.Errorf("Failed to send alert to sink '%s': %v", sink.Name(), err)
+		}
 	}
+}
 
-	if alert_sink == "slack" {
-		sendSlackAlert(webhook_url, webhook_proxy, msg)
-	} else if alert_sink == "teams" {
-		sendTeamsAlert(webhook_url, webhook_proxy, msg)
-	} else if alert_sink == "gchat" {
-		sendGoogleChatAlert(webhook_url, webhook_proxy, msg)
-	} else {
-		msg = strings.Replace(msg, "*", "", -1)
-		sendRawWebhookAlert(webhook_url, webhook_proxy, msg)
+// firstError returns the first of the errors returned by gorequest
+func firstError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
 	}
+	return errs[0]
+}
+
+// plainText removes the markdown emphasis for sinks which do not render it
+func plainText(msg string) string {
+	return strings.Replace(msg, "*", "", -1)
 }
 
 // function to handle server redirection
@@ -48,11 +234,96 @@ func redirectPolicy(req gorequest.Request, via []gorequest.Request) error {
 	return fmt.Errorf("incorrect token (redirection)")
 }
 
+// postJSON sends the payload as JSON to the url of a service
+func postJSON(url string, proxy string, headers map[string]string, payload interface{}) error {
+	request := gorequest.New().Proxy(proxy).
+		Post(url).
+		RedirectPolicy(redirectPolicy)
+	for name, value := range headers {
+		request.Set(name, value)
+	}
+	resp, _, errs := request.Send(payload).End()
+
+	if errs != nil {
+		return firstError(errs)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("error sending msg. status: %v", resp.Status)
+	}
+
+	return nil
+}
+
+// webhookSink posts the message to a webhook url with the function of the service
+type webhookSink struct {
+	name       string
+	webhookUrl string
+	proxy      string
+	send       func(webhookUrl string, proxy string, alert Alert) []error
+}
+
+func newWebhookSink(config SinkConfig, send func(webhookUrl string, proxy string, alert Alert) []error) (AlertSink, error) {
+	webhookUrl := config.Value("WEBHOOK_URL")
+	if webhookUrl == "" {
+		return nil, fmt.Errorf("ALERT_WEBHOOK_URL env variable not provided")
+	}
+	return &webhookSink{
+		name:       config.Sink,
+		webhookUrl: webhookUrl,
+		// Provision to add Proxy to reach webhook server if required
+		proxy: config.Value("WEBHOOK_PROXY"),
+		send:  send,
+	}, nil
+}
+
+func (s *webhookSink) Name() string {
+	return s.name
+}
+
+func (s *webhookSink) Send(alert Alert) error {
+	return firstError(s.send(s.webhookUrl, s.proxy, alert))
+}
+
+func newSlackSink(config SinkConfig) (AlertSink, error) {
+	return newWebhookSink(config, func(webhookUrl string, proxy string, alert Alert) []error {
+		return sendSlackAlert(webhookUrl, proxy, alert.Message, slackColor(alert.Severity))
+	})
+}
+
+func newTeamsSink(config SinkConfig) (AlertSink, error) {
+	return newWebhookSink(config, func(webhookUrl string, proxy string, alert Alert) []error {
+		return sendTeamsAlert(webhookUrl, proxy, alert.Message)
+	})
+}
+
+func newGoogleChatSink(config SinkConfig) (AlertSink, error) {
+	return newWebhookSink(config, func(webhookUrl string, proxy string, alert Alert) []error {
+		return sendGoogleChatAlert(webhookUrl, proxy, alert.Message)
+	})
+}
+
+func newRawWebhookSink(config SinkConfig) (AlertSink, error) {
+	return newWebhookSink(config, func(webhookUrl string, proxy string, alert Alert) []error {
+		return sendRawWebhookAlert(webhookUrl, proxy, plainText(alert.Message))
+	})
+}
+
+// slackColor returns the attachment color for the severity
+func slackColor(severity Severity) string {
+	switch severity {
+	case SeverityWarning:
+		return "warning"
+	case SeverityError, SeverityCritical:
+		return "danger"
+	}
+	return "good"
+}
+
 // function to send alert to slack
-func sendSlackAlert(webhookUrl string, proxy string, msg string) []error {
+func sendSlackAlert(webhookUrl string, proxy string, msg string, color string) []error {
 	attachment := Attachment{
 		Text:       msg,
-		Color:      "good",
+		Color:      color,
 		AuthorName: "Reloader",
 	}
 
@@ -0,0 +1,313 @@
+package alert
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   map[string]interface{}
+}
+
+func newTestServer(t *testing.T, status int) (*httptest.Server, *[]receivedRequest) {
+	var requests []receivedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(content, &body))
+		requests = append(requests, receivedRequest{header: r.Header, body: body})
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, found := env[key]
+		return value, found
+	}
+}
+
+func TestNewSinks(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		expectedSinks []string
+		expectsError  bool
+	}{
+		{
+			name:          "Raw webhook by default",
+			env:           map[string]string{"ALERT_WEBHOOK_URL": "http://localhost"},
+			expectedSinks: []string{"webhook"},
+		},
+		{
+			name: "Several sinks",
+			env: map[string]string{
+				"ALERT_SINK":                  " Slack, discord ,slack",
+				"ALERT_WEBHOOK_URL":           "http://localhost/slack",
+				"ALERT_DISCORD_WEBHOOK_URL":   "http://localhost/discord",
+				"ALERT_PAGERDUTY_ROUTING_KEY": "unused",
+			},
+			expectedSinks: []string{"slack", "discord"},
+		},
+		{
+			name:          "Unknown sink uses raw webhook",
+			env:           map[string]string{"ALERT_SINK": "slack,unknown,webhook", "ALERT_WEBHOOK_URL": "http://localhost"},
+			expectedSinks: []string{"slack", "webhook"},
+		},
+		{
+			name:          "Raw alias",
+			env:           map[string]string{"ALERT_SINK": "raw", "ALERT_WEBHOOK_URL": "http://localhost"},
+			expectedSinks: []string{"raw"},
+		},
+		{
+			name:          "Missing webhook url",
+			env:           map[string]string{"ALERT_SINK": "teams"},
+			expectedSinks: []string{},
+			expectsError:  true,
+		},
+		{
+			name:          "Invalid PagerDuty severity",
+			env:           map[string]string{"ALERT_SINK": "pagerduty", "ALERT_PAGERDUTY_ROUTING_KEY": "key", "ALERT_PAGERDUTY_SEVERITY": "low"},
+			expectedSinks: []string{},
+			expectsError:  true,
+		},
+		{
+			name:          "Missing PagerDuty routing key",
+			env:           map[string]string{"ALERT_SINK": "pagerduty"},
+			expectedSinks: []string{},
+			expectsError:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sinks, err := NewSinks(envLookup(tt.env))
+			if tt.expectsError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			names := []string{}
+			for _, sink := range sinks {
+				names = append(names, sink.Name())
+			}
+			assert.Equal(t, tt.expectedSinks, names)
+		})
+	}
+}
+
+func TestRegisterSink(t *testing.T) {
+	var received string
+	RegisterSink("test", func(config SinkConfig) (AlertSink, error) {
+		return &testSink{received: &received, prefix: config.Value("PREFIX")}, nil
+	})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test")
+		registryMu.Unlock()
+	}()
+	assert.Contains(t, RegisteredSinks(), "test")
+
+	sinks, err := NewSinks(envLookup(map[string]string{"ALERT_SINK": "test", "ALERT_PREFIX": "shared", "ALERT_TEST_PREFIX": "specific"}))
+	assert.NoError(t, err)
+	send(sinks, Alert{Message: "message", Severity: SeverityInfo})
+	assert.Equal(t, "specific: message", received)
+}
+
+func TestSinkPayloads(t *testing.T) {
+	tests := []struct {
+		name     string
+		sink     string
+		env      map[string]string
+		severity Severity
+		expected map[string]interface{}
+		header   map[string]string
+	}{
+		{
+			name:     "Discord",
+			sink:     "discord",
+			severity: SeverityInfo,
+			expected: map[string]interface{}{"username": "Reloader", "content": "*Deployment* reloaded"},
+		},
+		{
+			name:     "Mattermost",
+			sink:     "mattermost",
+			severity: SeverityInfo,
+			env:      map[string]string{"ALERT_MATTERMOST_CHANNEL": "alerts"},
+			expected: map[string]interface{}{"username": "Reloader", "channel": "alerts", "text": "*Deployment* reloaded"},
+		},
+		{
+			name:     "PagerDuty",
+			sink:     "pagerduty",
+			env:      map[string]string{"ALERT_PAGERDUTY_ROUTING_KEY": "routing-key", "ALERT_PAGERDUTY_SEVERITY": "critical"},
+			severity: SeverityInfo,
+			expected: map[string]interface{}{
+				"routing_key":  "routing-key",
+				"event_action": "trigger",
+				"payload":      map[string]interface{}{"summary": "Deployment reloaded", "source": "Reloader", "severity": "critical"},
+			},
+		},
+		{
+			name:     "PagerDuty severity from alert",
+			sink:     "pagerduty",
+			env:      map[string]string{"ALERT_PAGERDUTY_ROUTING_KEY": "routing-key"},
+			severity: SeverityWarning,
+			expected: map[string]interface{}{
+				"routing_key":  "routing-key",
+				"event_action": "trigger",
+				"payload":      map[string]interface{}{"summary": "Deployment reloaded", "source": "Reloader", "severity": "warning"},
+			},
+		},
+		{
+			name:     "Opsgenie",
+			sink:     "opsgenie",
+			env:      map[string]string{"ALERT_OPSGENIE_API_KEY": "api-key", "ALERT_OPSGENIE_PRIORITY": "P4"},
+			severity: SeverityCritical,
+			expected: map[string]interface{}{"message": "Deployment reloaded", "description": "Deployment reloaded", "source": "Reloader", "priority": "P4"},
+			header:   map[string]string{"Authorization": "GenieKey api-key"},
+		},
+		{
+			name:     "Opsgenie priority from severity",
+			sink:     "opsgenie",
+			env:      map[string]string{"ALERT_OPSGENIE_API_KEY": "api-key"},
+			severity: SeverityError,
+			expected: map[string]interface{}{"message": "Deployment reloaded", "description": "Deployment reloaded", "source": "Reloader", "priority": "P2"},
+		},
+		{
+			name:     "Slack",
+			sink:     "slack",
+			severity: SeverityError,
+			expected: map[string]interface{}{"attachments": []interface{}{
+				map[string]interface{}{"color": "danger", "author_name": "Reloader", "text": "*Deployment* reloaded"},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, http.StatusAccepted)
+			env := map[string]string{"ALERT_SINK": tt.sink, "ALERT_" + strings.ToUpper(tt.sink) + "_WEBHOOK_URL": server.URL}
+			for key, value := range tt.env {
+				env[key] = value
+			}
+
+			sinks, err := NewSinks(envLookup(env))
+			assert.NoError(t, err)
+			assert.Len(t, sinks, 1)
+
+			assert.NoError(t, sinks[0].Send(Alert{Message: "*Deployment* reloaded", Severity: tt.severity}))
+			assert.Len(t, *requests, 1)
+			assert.Equal(t, tt.expected, (*requests)[0].body)
+			for name, value := range tt.header {
+				assert.Equal(t, value, (*requests)[0].header.Get(name))
+			}
+		})
+	}
+}
+
+func TestSinkErrorStatus(t *testing.T) {
+	server, _ := newTestServer(t, http.StatusBadRequest)
+	sinks, err := NewSinks(envLookup(map[string]string{"ALERT_SINK": "discord", "ALERT_WEBHOOK_URL": server.URL}))
+	assert.NoError(t, err)
+	assert.Error(t, sinks[0].Send(Alert{Message: "message", Severity: SeverityInfo}))
+}
+
+func TestSMTPSink(t *testing.T) {
+	var address, from string
+	var to []string
+	var message []byte
+	sendMail = func(addr string, _ smtp.Auth, sender string, recipients []string, msg []byte) error {
+		address, from, to, message = addr, sender, recipients, msg
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	sinks, err := NewSinks(envLookup(map[string]string{
+		"ALERT_SINK":      "smtp",
+		"ALERT_SMTP_HOST": "mail.example.com",
+		"ALERT_SMTP_FROM": "reloader@example.com",
+		"ALERT_SMTP_TO":   "ops@example.com, dev@example.com",
+	}))
+	assert.NoError(t, err)
+
+	assert.NoError(t, sinks[0].Send(Alert{Message: "*Deployment* reloaded", Severity: SeverityInfo}))
+	assert.Equal(t, "mail.example.com:587", address)
+	assert.Equal(t, "reloader@example.com", from)
+	assert.Equal(t, []string{"ops@example.com", "dev@example.com"}, to)
+	assert.Contains(t, string(message), "To: ops@example.com, dev@example.com\r\n")
+	assert.Contains(t, string(message), "Subject: Reloader alert\r\n")
+	assert.True(t, strings.HasSuffix(string(message), "\r\n\r\nDeployment reloaded\r\n"))
+
+	assert.NoError(t, sinks[0].Send(Alert{Message: "Reload failed", Severity: SeverityError}))
+	assert.Contains(t, string(message), "Subject: [ERROR] Reloader alert\r\n")
+}
+
+func TestParseSeverity(t *testing.T) {
+	severity, err := ParseSeverity(" Critical ")
+	assert.NoError(t, err)
+	assert.Equal(t, SeverityCritical, severity)
+
+	_, err = ParseSeverity("low")
+	assert.Error(t, err)
+}
+
+func TestSendFailureAlert(t *testing.T) {
+	var received []Alert
+	sinksOnce.Do(func() {})
+	sinks = []AlertSink{&recordingSink{received: &received}}
+	defer func() { sinks = nil }()
+
+	SendFailureAlert("not enabled")
+	assert.Empty(t, received)
+
+	t.Setenv("ALERT_ON_FAILURE", "true")
+	SendFailureAlert("default severity")
+	t.Setenv("ALERT_FAILURE_SEVERITY", "critical")
+	SendFailureAlert("configured severity")
+	t.Setenv("ALERT_FAILURE_SEVERITY", "low")
+	SendFailureAlert("invalid severity")
+
+	assert.Equal(t, []Alert{
+		{Message: "default severity", Severity: SeverityError},
+		{Message: "configured severity", Severity: SeverityCritical},
+		{Message: "invalid severity", Severity: SeverityError},
+	}, received)
+}
+
+type testSink struct {
+	received *string
+	prefix   string
+}
+
+func (s *testSink) Name() string {
+	return "test"
+}
+
+func (s *testSink) Send(alert Alert) error {
+	*s.received = s.prefix + ": " + alert.Message
+	return nil
+}
+
+type recordingSink struct {
+	received *[]Alert
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Send(alert Alert) error {
+	*s.received = append(*s.received, alert)
+	return nil
+}
@@ -0,0 +1,33 @@
+package alert
+
+import "fmt"
+
+// discordSink posts the message to a Discord webhook
+type discordSink struct {
+	webhookUrl string
+	proxy      string
+}
+
+type discordMessage struct {
+	Username string `json:"username,omitempty"`
+	Content  string `json:"content"`
+}
+
+func newDiscordSink(config SinkConfig) (AlertSink, error) {
+	webhookUrl := config.Value("WEBHOOK_URL")
+	if webhookUrl == "" {
+		return nil, fmt.Errorf("ALERT_DISCORD_WEBHOOK_URL env variable not provided")
+	}
+	return &discordSink{webhookUrl: webhookUrl, proxy: config.Value("WEBHOOK_PROXY")}, nil
+}
+
+func (s *discordSink) Name() string {
+	return "discord"
+}
+
+func (s *discordSink) Send(alert Alert) error {
+	return postJSON(s.webhookUrl, s.proxy, nil, discordMessage{
+		Username: "Reloader",
+		Content:  alert.Message,
+	})
+}
@@ -0,0 +1,41 @@
+package alert
+
+import "fmt"
+
+// mattermostSink posts the message to a Mattermost incoming webhook
+type mattermostSink struct {
+	webhookUrl string
+	proxy      string
+	channel    string
+}
+
+type mattermostMessage struct {
+	Username string `json:"username,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	Text     string `json:"text"`
+}
+
+func newMattermostSink(config SinkConfig) (AlertSink, error) {
+	webhookUrl := config.Value("WEBHOOK_URL")
+	if webhookUrl == "" {
+		return nil, fmt.Errorf("ALERT_MATTERMOST_WEBHOOK_URL env variable not provided")
+	}
+	return &mattermostSink{
+		webhookUrl: webhookUrl,
+		proxy:      config.Value("WEBHOOK_PROXY"),
+		// The channel configured for the webhook is used if none is set
+		channel: config.SinkValue("CHANNEL"),
+	}, nil
+}
+
+func (s *mattermostSink) Name() string {
+	return "mattermost"
+}
+
+func (s *mattermostSink) Send(alert Alert) error {
+	return postJSON(s.webhookUrl, s.proxy, nil, mattermostMessage{
+		Username: "Reloader",
+		Channel:  s.channel,
+		Text:     alert.Message,
+	})
+}
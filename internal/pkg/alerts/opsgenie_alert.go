@@ -0,0 +1,77 @@
+package alert
+
+import "fmt"
+
+const (
+	opsgenieAlertsUrl = "https://api.opsgenie.com/v2/alerts"
+	// opsgenieMessageLimit is the maximum length of the message accepted by the Alert API
+	opsgenieMessageLimit = 130
+)
+
+// opsgeniePriorities maps the alert severities to Opsgenie priorities
+var opsgeniePriorities = map[Severity]string{
+	SeverityCritical: "P1",
+	SeverityError:    "P2",
+	SeverityWarning:  "P3",
+	SeverityInfo:     "P5",
+}
+
+// opsgenieSink creates an alert with the Opsgenie Alert API
+type opsgenieSink struct {
+	alertsUrl string
+	proxy     string
+	apiKey    string
+	priority  string
+}
+
+type opsgenieAlert struct {
+	Message     string `json:"message"`
+	Description string `json:"description,omitempty"`
+	Source      string `json:"source"`
+	Priority    string `json:"priority,omitempty"`
+}
+
+func newOpsgenieSink(config SinkConfig) (AlertSink, error) {
+	apiKey := config.SinkValue("API_KEY")
+	if apiKey == "" {
+		return nil, fmt.Errorf("ALERT_OPSGENIE_API_KEY env variable not provided")
+	}
+
+	// e.g. https://api.eu.opsgenie.com/v2/alerts for the EU instance
+	alertsUrl := config.SinkValue("WEBHOOK_URL")
+	if alertsUrl == "" {
+		alertsUrl = opsgenieAlertsUrl
+	}
+
+	return &opsgenieSink{
+		alertsUrl: alertsUrl,
+		proxy:     config.Value("WEBHOOK_PROXY"),
+		apiKey:    apiKey,
+		// The priority is derived from the severity of the alert if none is set
+		priority: config.SinkValue("PRIORITY"),
+	}, nil
+}
+
+func (s *opsgenieSink) Name() string {
+	return "opsgenie"
+}
+
+func (s *opsgenieSink) Send(alert Alert) error {
+	description := plainText(alert.Message)
+	message := description
+	if len(message) > opsgenieMessageLimit {
+		message = message[:opsgenieMessageLimit]
+	}
+
+	priority := s.priority
+	if priority == "" {
+		priority = opsgeniePriorities[alert.Severity]
+	}
+
+	return postJSON(s.alertsUrl, s.proxy, map[string]string{"Authorization": "GenieKey " + s.apiKey}, opsgenieAlert{
+		Message:     message,
+		Description: description,
+		Source:      "Reloader",
+		Priority:    priority,
+	})
+}
@@ -0,0 +1,86 @@
+package alert
+
+import "fmt"
+
+const (
+	pagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+	// pagerDutySummaryLimit is the maximum length of the summary accepted by the Events API
+	pagerDutySummaryLimit = 1024
+)
+
+// pagerDutySink triggers an event with the PagerDuty Events API v2
+type pagerDutySink struct {
+	eventsUrl  string
+	proxy      string
+	routingKey string
+	severity   Severity
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+func newPagerDutySink(config SinkConfig) (AlertSink, error) {
+	routingKey := config.SinkValue("ROUTING_KEY")
+	if routingKey == "" {
+		return nil, fmt.Errorf("ALERT_PAGERDUTY_ROUTING_KEY env variable not provided")
+	}
+
+	// The severity is taken from the alert if none is set
+	var severity Severity
+	if value := config.SinkValue("SEVERITY"); value != "" {
+		var err error
+		severity, err = ParseSeverity(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The shared webhook url belongs to another sink, only the sink specific url overrides the Events API
+	eventsUrl := config.SinkValue("WEBHOOK_URL")
+	if eventsUrl == "" {
+		eventsUrl = pagerDutyEventsUrl
+	}
+
+	return &pagerDutySink{
+		eventsUrl:  eventsUrl,
+		proxy:      config.Value("WEBHOOK_PROXY"),
+		routingKey: routingKey,
+		severity:   severity,
+	}, nil
+}
+
+func (s *pagerDutySink) Name() string {
+	return "pagerduty"
+}
+
+func (s *pagerDutySink) Send(alert Alert) error {
+	summary := plainText(alert.Message)
+	if len(summary) > pagerDutySummaryLimit {
+		summary = summary[:pagerDutySummaryLimit]
+	}
+
+	severity := s.severity
+	if severity == "" {
+		severity = alert.Severity
+	}
+
+	return postJSON(s.eventsUrl, s.proxy, nil, pagerDutyEvent{
+		RoutingKey:  s.routingKey,
+		EventAction: "trigger",
+		Payload: pagerDutyPayload{
+			Summary: summary,
+			Source:  "Reloader",
+			// The severities of the Events API match the alert severities
+			Severity: string(severity),
+		},
+	})
+}
@@ -0,0 +1,92 @@
+package alert
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// sendMail is replaced in tests
+var sendMail = smtp.SendMail
+
+// smtpSink sends the message as email, STARTTLS is used if the server supports it
+type smtpSink struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject string
+}
+
+func newSMTPSink(config SinkConfig) (AlertSink, error) {
+	host := config.SinkValue("HOST")
+	if host == "" {
+		return nil, fmt.Errorf("ALERT_SMTP_HOST env variable not provided")
+	}
+	port := config.SinkValue("PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	from := config.SinkValue("FROM")
+	if from == "" {
+		return nil, fmt.Errorf("ALERT_SMTP_FROM env variable not provided")
+	}
+
+	var to []string
+	for _, recipient := range strings.Split(config.SinkValue("TO"), ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			to = append(to, recipient)
+		}
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("ALERT_SMTP_TO env variable not provided")
+	}
+
+	subject := config.SinkValue("SUBJECT")
+	if subject == "" {
+		subject = "Reloader alert"
+	}
+
+	var auth smtp.Auth
+	if username := config.SinkValue("USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, config.SinkValue("PASSWORD"), host)
+	}
+
+	return &smtpSink{
+		address: net.JoinHostPort(host, port),
+		auth:    auth,
+		from:    from,
+		to:      to,
+		subject: subject,
+	}, nil
+}
+
+func (s *smtpSink) Name() string {
+	return "smtp"
+}
+
+func (s *smtpSink) Send(alert Alert) error {
+	return sendMail(s.address, s.auth, s.from, s.to, s.message(alert.Severity, plainText(alert.Message)))
+}
+
+// message builds the plain text email with its headers
+func (s *smtpSink) message(severity Severity, body string) []byte {
+	var message strings.Builder
+	fmt.Fprintf(&message, "From: %s\r\n", s.from)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(s.to, ", "))
+	subject := s.subject
+	if severity != SeverityInfo {
+		subject = fmt.Sprintf("[%s] %s", strings.ToUpper(string(severity)), subject)
+	}
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(body)
+	message.WriteString("\r\n")
+	return []byte(message.String())
+}
@@ -0,0 +1,210 @@
+package callbacks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	patchtypes "k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultCustomWorkloadTemplatePath is the pod template location used when a custom workload does not specify one
+const DefaultCustomWorkloadTemplatePath = "spec.template"
+
+// CustomWorkload describes a custom resource that embeds a pod template and is reloaded through the dynamic client
+type CustomWorkload struct {
+	Resource     schema.GroupVersionResource
+	TemplatePath []string
+}
+
+// CustomWorkloadItem wraps a custom resource together with its decoded pod template,
+// changes made to the template are written back to the resource on update
+type CustomWorkloadItem struct {
+	*unstructured.Unstructured
+	Template     *v1.PodTemplateSpec
+	TemplatePath []string
+}
+
+// DeepCopyObject returns a deep copy of the item including its pod template
+func (i *CustomWorkloadItem) DeepCopyObject() runtime.Object {
+	return &CustomWorkloadItem{
+		Unstructured: i.Unstructured.DeepCopy(),
+		Template:     i.Template.DeepCopy(),
+		TemplatePath: append([]string(nil), i.TemplatePath...),
+	}
+}
+
+// ParseCustomWorkload parses a custom workload in the format group/version/resource[:template.path],
+// the group can be omitted for the core group and the path defaults to spec.template
+func ParseCustomWorkload(value string) (CustomWorkload, error) {
+	gvr, path, _ := strings.Cut(strings.TrimSpace(value), ":")
+	path = strings.Trim(strings.TrimSpace(path), ".")
+	if path == "" {
+		path = DefaultCustomWorkloadTemplatePath
+	}
+
+	var resource schema.GroupVersionResource
+	parts := strings.Split(gvr, "/")
+	switch len(parts) {
+	case 2:
+		resource = schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}
+	case 3:
+		resource = schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+	default:
+		return CustomWorkload{}, fmt.Errorf("invalid custom workload '%s', expected format group/version/resource[:template.path]", value)
+	}
+
+	if resource.Version == "" || resource.Resource == "" {
+		return CustomWorkload{}, fmt.Errorf("invalid custom workload '%s', version and resource are required", value)
+	}
+
+	return CustomWorkload{Resource: resource, TemplatePath: strings.Split(path, ".")}, nil
+}
+
+// ParseCustomWorkloads parses a list of custom workloads, see ParseCustomWorkload
+func ParseCustomWorkloads(values []string) ([]CustomWorkload, error) {
+	workloads := make([]CustomWorkload, 0, len(values))
+	for _, value := range values {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		workload, err := ParseCustomWorkload(value)
+		if err != nil {
+			return nil, err
+		}
+		workloads = append(workloads, workload)
+	}
+	return workloads, nil
+}
+
+// ResourceType returns the name of the custom workload used in logs and events
+func (w CustomWorkload) ResourceType() string {
+	return w.Resource.GroupResource().String()
+}
+
+// GetItem returns the custom workload in given namespace
+func (w CustomWorkload) GetItem(clients kube.Clients, name string, namespace string) (runtime.Object, error) {
+	resource, err := clients.DynamicClient.Resource(w.Resource).Namespace(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil {
+		logrus.Errorf("Failed to get %s %v", w.ResourceType(), err)
+		return nil, err
+	}
+
+	item, err := newCustomWorkloadItem(resource, w.TemplatePath)
+	if err != nil {
+		logrus.Errorf("Failed to get %s %v", w.ResourceType(), err)
+		return nil, err
+	}
+
+	return item, nil
+}
+
+// GetItems returns the custom workloads in given namespace
+func (w CustomWorkload) GetItems(clients kube.Clients, namespace string) []runtime.Object {
+	resources, err := clients.DynamicClient.Resource(w.Resource).Namespace(namespace).List(context.TODO(), meta_v1.ListOptions{})
+	if err != nil {
+		logrus.Errorf("Failed to list %s %v", w.ResourceType(), err)
+		return []runtime.Object{}
+	}
+
+	items := make([]runtime.Object, 0, len(resources.Items))
+	for i := range resources.Items {
+		item, err := newCustomWorkloadItem(&resources.Items[i], w.TemplatePath)
+		if err != nil {
+			// Skip resources without a usable pod template, there is nothing to reload
+			logrus.Debugf("Skipping %s '%s': %v", w.ResourceType(), resources.Items[i].GetName(), err)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// Update writes the pod template back and updates the custom workload
+func (w CustomWorkload) Update(clients kube.Clients, namespace string, resource runtime.Object) error {
+	item := resource.(*CustomWorkloadItem)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(item.Template)
+	if err != nil {
+		return err
+	}
+	// creationTimestamp is serialized as null which is rejected by most schemas
+	unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+
+	err = unstructured.SetNestedMap(item.Object, content, item.TemplatePath...)
+	if err != nil {
+		return err
+	}
+
+	_, err = clients.DynamicClient.Resource(w.Resource).Namespace(namespace).Update(context.TODO(), item.Unstructured, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+	return err
+}
+
+// Patch is not supported for custom workloads as strategic merge patches are not available for custom resources
+func (w CustomWorkload) Patch(clients kube.Clients, namespace string, resource runtime.Object, patchType patchtypes.PatchType, bytes []byte) error {
+	return errors.New("not supported patching: " + w.ResourceType())
+}
+
+// GetCustomWorkloadAnnotations returns the annotations of given custom workload
+func GetCustomWorkloadAnnotations(item runtime.Object) map[string]string {
+	annotations := item.(*CustomWorkloadItem).GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	return annotations
+}
+
+// GetCustomWorkloadPodAnnotations returns the pod's annotations of given custom workload
+func GetCustomWorkloadPodAnnotations(item runtime.Object) map[string]string {
+	if item.(*CustomWorkloadItem).Template.ObjectMeta.Annotations == nil {
+		item.(*CustomWorkloadItem).Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return item.(*CustomWorkloadItem).Template.ObjectMeta.Annotations
+}
+
+// GetCustomWorkloadContainers returns the containers of given custom workload
+func GetCustomWorkloadContainers(item runtime.Object) []v1.Container {
+	return item.(*CustomWorkloadItem).Template.Spec.Containers
+}
+
+// GetCustomWorkloadInitContainers returns the init containers of given custom workload
+func GetCustomWorkloadInitContainers(item runtime.Object) []v1.Container {
+	return item.(*CustomWorkloadItem).Template.Spec.InitContainers
+}
+
+// GetCustomWorkloadVolumes returns the Volumes of given custom workload
+func GetCustomWorkloadVolumes(item runtime.Object) []v1.Volume {
+	return item.(*CustomWorkloadItem).Template.Spec.Volumes
+}
+
+func newCustomWorkloadItem(resource *unstructured.Unstructured, templatePath []string) (*CustomWorkloadItem, error) {
+	content, found, err := unstructured.NestedMap(resource.Object, templatePath...)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("pod template not found at '%s'", strings.Join(templatePath, "."))
+	}
+
+	template := &v1.PodTemplateSpec{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(content, template)
+	if err != nil {
+		return nil, err
+	}
+	if len(template.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("pod template at '%s' has no containers", strings.Join(templatePath, "."))
+	}
+	if template.ObjectMeta.Annotations == nil {
+		template.ObjectMeta.Annotations = make(map[string]string)
+	}
+
+	return &CustomWorkloadItem{Unstructured: resource, Template: template, TemplatePath: templatePath}, nil
+}
@@ -0,0 +1,187 @@
+package callbacks_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	patchtypes "k8s.io/apimachinery/pkg/types"
+
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/pkg/kube"
+)
+
+var cloneSetResource = schema.GroupVersionResource{Group: "apps.kruise.io", Version: "v1alpha1", Resource: "clonesets"}
+
+func TestParseCustomWorkload(t *testing.T) {
+	tests := []struct {
+		name         string
+		value        string
+		expected     callbacks.CustomWorkload
+		expectsError bool
+	}{
+		{
+			name:     "Default template path",
+			value:    "apps.kruise.io/v1alpha1/clonesets",
+			expected: callbacks.CustomWorkload{Resource: cloneSetResource, TemplatePath: []string{"spec", "template"}},
+		},
+		{
+			name:  "Custom template path",
+			value: "keda.sh/v1alpha1/scaledjobs:.spec.jobTargetRef.template",
+			expected: callbacks.CustomWorkload{
+				Resource:     schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledjobs"},
+				TemplatePath: []string{"spec", "jobTargetRef", "template"},
+			},
+		},
+		{
+			name:     "Core group",
+			value:    "v1/podtemplates:template",
+			expected: callbacks.CustomWorkload{Resource: schema.GroupVersionResource{Version: "v1", Resource: "podtemplates"}, TemplatePath: []string{"template"}},
+		},
+		{
+			name:         "Missing version",
+			value:        "clonesets",
+			expectsError: true,
+		},
+		{
+			name:         "Empty resource",
+			value:        "apps.kruise.io/v1alpha1/",
+			expectsError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workload, err := callbacks.ParseCustomWorkload(tt.value)
+			if tt.expectsError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, workload)
+		})
+	}
+}
+
+func TestCustomWorkloadResourceType(t *testing.T) {
+	workload := callbacks.CustomWorkload{Resource: cloneSetResource}
+	assert.Equal(t, "clonesets.apps.kruise.io", workload.ResourceType())
+}
+
+func TestCustomWorkloadItems(t *testing.T) {
+	fixtures := newTestFixtures()
+	workload, err := callbacks.ParseCustomWorkload("apps.kruise.io/v1alpha1/clonesets")
+	assert.NoError(t, err)
+
+	_, err = createTestCloneSet(clients, fixtures.namespace, "test-cloneset-1", true)
+	assert.NoError(t, err)
+	_, err = createTestCloneSet(clients, fixtures.namespace, "test-cloneset-2", true)
+	assert.NoError(t, err)
+	_, err = createTestCloneSet(clients, fixtures.namespace, "test-cloneset-no-template", false)
+	assert.NoError(t, err)
+	defer deleteTestCloneSets(clients, fixtures.namespace, "test-cloneset-1", "test-cloneset-2", "test-cloneset-no-template")
+
+	items := workload.GetItems(clients, fixtures.namespace)
+	assert.Equal(t, 2, len(items))
+
+	_, err = workload.GetItem(clients, "test-cloneset-no-template", fixtures.namespace)
+	assert.Error(t, err)
+
+	item, err := workload.GetItem(clients, "test-cloneset-1", fixtures.namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"version": "1"}, callbacks.GetCustomWorkloadAnnotations(item))
+	assert.Equal(t, map[string]string{}, callbacks.GetCustomWorkloadPodAnnotations(item))
+	assert.Equal(t, fixtures.defaultContainers, callbacks.GetCustomWorkloadContainers(item))
+	assert.Equal(t, fixtures.defaultInitContainers, callbacks.GetCustomWorkloadInitContainers(item))
+	assert.Equal(t, fixtures.defaultVolumes, callbacks.GetCustomWorkloadVolumes(item))
+}
+
+func TestUpdateCustomWorkload(t *testing.T) {
+	fixtures := newTestFixtures()
+	workload, err := callbacks.ParseCustomWorkload("apps.kruise.io/v1alpha1/clonesets")
+	assert.NoError(t, err)
+
+	_, err = createTestCloneSet(clients, fixtures.namespace, "test-cloneset-update", true)
+	assert.NoError(t, err)
+	defer deleteTestCloneSets(clients, fixtures.namespace, "test-cloneset-update")
+
+	item, err := workload.GetItem(clients, "test-cloneset-update", fixtures.namespace)
+	assert.NoError(t, err)
+
+	callbacks.GetCustomWorkloadPodAnnotations(item)["reloaded"] = "true"
+	containers := callbacks.GetCustomWorkloadContainers(item)
+	containers[0].Env = append(containers[0].Env, v1.EnvVar{Name: "STAKATER_TEST_CONFIGMAP", Value: "sha"})
+
+	err = workload.Update(clients, fixtures.namespace, item)
+	assert.NoError(t, err)
+
+	updated, err := clients.DynamicClient.Resource(cloneSetResource).Namespace(fixtures.namespace).Get(context.TODO(), "test-cloneset-update", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	annotations, _, err := unstructured.NestedStringMap(updated.Object, "spec", "template", "metadata", "annotations")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", annotations["reloaded"])
+
+	_, found, _ := unstructured.NestedFieldNoCopy(updated.Object, "spec", "template", "metadata", "creationTimestamp")
+	assert.False(t, found)
+
+	item, err = workload.GetItem(clients, "test-cloneset-update", fixtures.namespace)
+	assert.NoError(t, err)
+	assert.Equal(t, []v1.EnvVar{{Name: "STAKATER_TEST_CONFIGMAP", Value: "sha"}}, callbacks.GetCustomWorkloadContainers(item)[0].Env)
+	// Fields outside of the pod template are left untouched
+	replicas, _, _ := unstructured.NestedInt64(updated.Object, "spec", "replicas")
+	assert.Equal(t, int64(2), replicas)
+}
+
+func TestPatchCustomWorkload(t *testing.T) {
+	workload := callbacks.CustomWorkload{Resource: cloneSetResource}
+	err := workload.Patch(clients, "default", nil, patchtypes.StrategicMergePatchType, []byte(`{"spec": {}}`))
+	assert.EqualError(t, err, "not supported patching: clonesets.apps.kruise.io")
+}
+
+func createTestCloneSet(clients kube.Clients, namespace, name string, withTemplate bool) (*unstructured.Unstructured, error) {
+	fixtures := newTestFixtures()
+	spec := map[string]interface{}{"replicas": int64(2)}
+	if withTemplate {
+		spec["template"] = map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers":     containersToUnstructured(fixtures.defaultContainers),
+				"initContainers": containersToUnstructured(fixtures.defaultInitContainers),
+				"volumes": []interface{}{
+					map[string]interface{}{"name": fixtures.defaultVolumes[0].Name},
+					map[string]interface{}{"name": fixtures.defaultVolumes[1].Name},
+				},
+			},
+		}
+	}
+
+	cloneSet := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps.kruise.io/v1alpha1",
+		"kind":       "CloneSet",
+		"metadata": map[string]interface{}{
+			"name":        name,
+			"namespace":   namespace,
+			"annotations": map[string]interface{}{"version": "1"},
+		},
+		"spec": spec,
+	}}
+	return clients.DynamicClient.Resource(cloneSetResource).Namespace(namespace).Create(context.TODO(), cloneSet, metav1.CreateOptions{})
+}
+
+func deleteTestCloneSets(clients kube.Clients, namespace string, names ...string) {
+	for _, name := range names {
+		_ = clients.DynamicClient.Resource(cloneSetResource).Namespace(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	}
+}
+
+func containersToUnstructured(containers []v1.Container) []interface{} {
+	result := make([]interface{}, len(containers))
+	for i, container := range containers {
+		result[i] = map[string]interface{}{"name": container.Name}
+	}
+	return result
+}
@@ -15,10 +15,12 @@ import (
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	patchtypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 
 	"maps"
 
 	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	openshiftv1 "github.com/openshift/api/apps/v1"
 )
 
 // ItemFunc is a generic function to return a specific resource in given namespace
@@ -70,9 +72,10 @@ type RollingUpgradeFuncs struct {
 
 // PatchTemplates contains merge JSON patch templates
 type PatchTemplates struct {
-	AnnotationTemplate   string
-	EnvVarTemplate       string
-	DeleteEnvVarTemplate string
+	AnnotationTemplate      string
+	EnvVarTemplate          string
+	DeleteEnvVarTemplate    string
+	ApplyAnnotationTemplate string
 }
 
 // GetDeploymentItem returns the deployment in given namespace
@@ -111,6 +114,46 @@ func GetDeploymentItems(clients kube.Clients, namespace string) []runtime.Object
 	return items
 }
 
+// GetDeploymentConfigItem returns the deploymentConfig in given namespace
+func GetDeploymentConfigItem(clients kube.Clients, name string, namespace string) (runtime.Object, error) {
+	deploymentConfig, err := clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
+	if err != nil {
+		logrus.Errorf("Failed to get deploymentConfig %v", err)
+		return nil, err
+	}
+
+	if deploymentConfig.Spec.Template == nil {
+		deploymentConfig.Spec.Template = &v1.PodTemplateSpec{}
+	}
+	if deploymentConfig.Spec.Template.ObjectMeta.Annotations == nil {
+		deploymentConfig.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+
+	return deploymentConfig, nil
+}
+
+// GetDeploymentConfigItems returns the deploymentConfigs in given namespace
+func GetDeploymentConfigItems(clients kube.Clients, namespace string) []runtime.Object {
+	deploymentConfigs, err := clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).List(context.TODO(), meta_v1.ListOptions{})
+	if err != nil {
+		logrus.Errorf("Failed to list deploymentConfigs %v", err)
+	}
+
+	items := make([]runtime.Object, len(deploymentConfigs.Items))
+	// Ensure we always have pod annotations to add to
+	for i, v := range deploymentConfigs.Items {
+		if v.Spec.Template == nil {
+			deploymentConfigs.Items[i].Spec.Template = &v1.PodTemplateSpec{}
+		}
+		if deploymentConfigs.Items[i].Spec.Template.ObjectMeta.Annotations == nil {
+			deploymentConfigs.Items[i].Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		items[i] = &deploymentConfigs.Items[i]
+	}
+
+	return items
+}
+
 // GetCronJobItem returns the job in given namespace
 func GetCronJobItem(clients kube.Clients, name string, namespace string) (runtime.Object, error) {
 	cronjob, err := clients.KubernetesClient.BatchV1().CronJobs(namespace).Get(context.TODO(), name, meta_v1.GetOptions{})
@@ -271,6 +314,14 @@ func GetDeploymentAnnotations(item runtime.Object) map[string]string {
 	return item.(*appsv1.Deployment).ObjectMeta.Annotations
 }
 
+// GetDeploymentConfigAnnotations returns the annotations of given deploymentConfig
+func GetDeploymentConfigAnnotations(item runtime.Object) map[string]string {
+	if item.(*openshiftv1.DeploymentConfig).ObjectMeta.Annotations == nil {
+		item.(*openshiftv1.DeploymentConfig).ObjectMeta.Annotations = make(map[string]string)
+	}
+	return item.(*openshiftv1.DeploymentConfig).ObjectMeta.Annotations
+}
+
 // GetCronJobAnnotations returns the annotations of given cronjob
 func GetCronJobAnnotations(item runtime.Object) map[string]string {
 	if item.(*batchv1.CronJob).ObjectMeta.Annotations == nil {
@@ -319,6 +370,18 @@ func GetDeploymentPodAnnotations(item runtime.Object) map[string]string {
 	return item.(*appsv1.Deployment).Spec.Template.ObjectMeta.Annotations
 }
 
+// GetDeploymentConfigPodAnnotations returns the pod's annotations of given deploymentConfig
+func GetDeploymentConfigPodAnnotations(item runtime.Object) map[string]string {
+	deploymentConfig := item.(*openshiftv1.DeploymentConfig)
+	if deploymentConfig.Spec.Template == nil {
+		deploymentConfig.Spec.Template = &v1.PodTemplateSpec{}
+	}
+	if deploymentConfig.Spec.Template.ObjectMeta.Annotations == nil {
+		deploymentConfig.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+	}
+	return deploymentConfig.Spec.Template.ObjectMeta.Annotations
+}
+
 // GetCronJobPodAnnotations returns the pod's annotations of given cronjob
 func GetCronJobPodAnnotations(item runtime.Object) map[string]string {
 	if item.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template.ObjectMeta.Annotations == nil {
@@ -364,6 +427,14 @@ func GetDeploymentContainers(item runtime.Object) []v1.Container {
 	return item.(*appsv1.Deployment).Spec.Template.Spec.Containers
 }
 
+// GetDeploymentConfigContainers returns the containers of given deploymentConfig
+func GetDeploymentConfigContainers(item runtime.Object) []v1.Container {
+	if item.(*openshiftv1.DeploymentConfig).Spec.Template == nil {
+		return nil
+	}
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.Containers
+}
+
 // GetCronJobContainers returns the containers of given cronjob
 func GetCronJobContainers(item runtime.Object) []v1.Container {
 	return item.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template.Spec.Containers
@@ -394,6 +465,14 @@ func GetDeploymentInitContainers(item runtime.Object) []v1.Container {
 	return item.(*appsv1.Deployment).Spec.Template.Spec.InitContainers
 }
 
+// GetDeploymentConfigInitContainers returns the containers of given deploymentConfig
+func GetDeploymentConfigInitContainers(item runtime.Object) []v1.Container {
+	if item.(*openshiftv1.DeploymentConfig).Spec.Template == nil {
+		return nil
+	}
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.InitContainers
+}
+
 // GetCronJobInitContainers returns the containers of given cronjob
 func GetCronJobInitContainers(item runtime.Object) []v1.Container {
 	return item.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template.Spec.InitContainers
@@ -425,7 +504,19 @@ func GetPatchTemplates() PatchTemplates {
 		AnnotationTemplate:   `{"spec":{"template":{"metadata":{"annotations":{"%s":"%s"}}}}}`,                                   // strategic merge patch
 		EnvVarTemplate:       `{"spec":{"template":{"spec":{"containers":[{"name":"%s","env":[{"name":"%s","value":"%s"}]}]}}}}`, // strategic merge patch
 		DeleteEnvVarTemplate: `[{"op":"remove","path":"/spec/template/spec/containers/%d/env/%d"}]`,                              // JSON patch
+		ApplyAnnotationTemplate: `{"apiVersion":"%s","kind":"%s","metadata":{"name":"%s"},` +
+			`"spec":{"template":{"metadata":{"annotations":{"%s":"%s","%s":"%s"}}}}}`, // server-side apply
+	}
+}
+
+// getPatchOptions returns the options of a patch, server-side applies take over the fields from other field managers
+// so Reloader owns the fields it sets
+func getPatchOptions(patchType patchtypes.PatchType) meta_v1.PatchOptions {
+	patchOptions := meta_v1.PatchOptions{FieldManager: "Reloader"}
+	if patchType == patchtypes.ApplyPatchType {
+		patchOptions.Force = ptr.To(true)
 	}
+	return patchOptions
 }
 
 // UpdateDeployment performs rolling upgrade on deployment
@@ -438,7 +529,21 @@ func UpdateDeployment(clients kube.Clients, namespace string, resource runtime.O
 // PatchDeployment performs rolling upgrade on deployment
 func PatchDeployment(clients kube.Clients, namespace string, resource runtime.Object, patchType patchtypes.PatchType, bytes []byte) error {
 	deployment := resource.(*appsv1.Deployment)
-	_, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Patch(context.TODO(), deployment.Name, patchType, bytes, meta_v1.PatchOptions{FieldManager: "Reloader"})
+	_, err := clients.KubernetesClient.AppsV1().Deployments(namespace).Patch(context.TODO(), deployment.Name, patchType, bytes, getPatchOptions(patchType))
+	return err
+}
+
+// UpdateDeploymentConfig performs rolling upgrade on deploymentConfig
+func UpdateDeploymentConfig(clients kube.Clients, namespace string, resource runtime.Object) error {
+	deploymentConfig := resource.(*openshiftv1.DeploymentConfig)
+	_, err := clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Update(context.TODO(), deploymentConfig, meta_v1.UpdateOptions{FieldManager: "Reloader"})
+	return err
+}
+
+// PatchDeploymentConfig performs rolling upgrade on deploymentConfig
+func PatchDeploymentConfig(clients kube.Clients, namespace string, resource runtime.Object, patchType patchtypes.PatchType, bytes []byte) error {
+	deploymentConfig := resource.(*openshiftv1.DeploymentConfig)
+	_, err := clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Patch(context.TODO(), deploymentConfig.Name, patchType, bytes, getPatchOptions(patchType))
 	return err
 }
 
@@ -513,7 +618,7 @@ func UpdateDaemonSet(clients kube.Clients, namespace string, resource runtime.Ob
 
 func PatchDaemonSet(clients kube.Clients, namespace string, resource runtime.Object, patchType patchtypes.PatchType, bytes []byte) error {
 	daemonSet := resource.(*appsv1.DaemonSet)
-	_, err := clients.KubernetesClient.AppsV1().DaemonSets(namespace).Patch(context.TODO(), daemonSet.Name, patchType, bytes, meta_v1.PatchOptions{FieldManager: "Reloader"})
+	_, err := clients.KubernetesClient.AppsV1().DaemonSets(namespace).Patch(context.TODO(), daemonSet.Name, patchType, bytes, getPatchOptions(patchType))
 	return err
 }
 
@@ -526,7 +631,7 @@ func UpdateStatefulSet(clients kube.Clients, namespace string, resource runtime.
 
 func PatchStatefulSet(clients kube.Clients, namespace string, resource runtime.Object, patchType patchtypes.PatchType, bytes []byte) error {
 	statefulSet := resource.(*appsv1.StatefulSet)
-	_, err := clients.KubernetesClient.AppsV1().StatefulSets(namespace).Patch(context.TODO(), statefulSet.Name, patchType, bytes, meta_v1.PatchOptions{FieldManager: "Reloader"})
+	_, err := clients.KubernetesClient.AppsV1().StatefulSets(namespace).Patch(context.TODO(), statefulSet.Name, patchType, bytes, getPatchOptions(patchType))
 	return err
 }
 
@@ -553,6 +658,14 @@ func GetDeploymentVolumes(item runtime.Object) []v1.Volume {
 	return item.(*appsv1.Deployment).Spec.Template.Spec.Volumes
 }
 
+// GetDeploymentConfigVolumes returns the Volumes of given deploymentConfig
+func GetDeploymentConfigVolumes(item runtime.Object) []v1.Volume {
+	if item.(*openshiftv1.DeploymentConfig).Spec.Template == nil {
+		return nil
+	}
+	return item.(*openshiftv1.DeploymentConfig).Spec.Template.Spec.Volumes
+}
+
 // GetCronJobVolumes returns the Volumes of given cronjob
 func GetCronJobVolumes(item runtime.Object) []v1.Volume {
 	return item.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template.Spec.Volumes
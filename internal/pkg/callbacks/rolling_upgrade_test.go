@@ -14,11 +14,15 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	watch "k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 
 	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
 	fakeargoclientset "github.com/argoproj/argo-rollouts/pkg/client/clientset/versioned/fake"
+	openshiftv1 "github.com/openshift/api/apps/v1"
+	fakeopenshiftclientset "github.com/openshift/client-go/apps/clientset/versioned/fake"
 	patchtypes "k8s.io/apimachinery/pkg/types"
 
 	"github.com/stakater/Reloader/internal/pkg/callbacks"
@@ -49,8 +53,12 @@ func newTestFixtures() testFixtures {
 
 func setupTestClients() kube.Clients {
 	return kube.Clients{
-		KubernetesClient:  fake.NewSimpleClientset(),
-		ArgoRolloutClient: fakeargoclientset.NewSimpleClientset(),
+		KubernetesClient:    fake.NewSimpleClientset(),
+		OpenshiftAppsClient: fakeopenshiftclientset.NewSimpleClientset(),
+		ArgoRolloutClient:   fakeargoclientset.NewSimpleClientset(),
+		DynamicClient: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			cloneSetResource: "CloneSetList",
+		}),
 	}
 }
 
@@ -135,6 +143,12 @@ func TestResourceItem(t *testing.T) {
 			getItemFunc: callbacks.GetDeploymentItem,
 			deleteFunc:  deleteTestDeployment,
 		},
+		{
+			name:        "DeploymentConfig",
+			createFunc:  createTestDeploymentConfigWithAnnotations,
+			getItemFunc: callbacks.GetDeploymentConfigItem,
+			deleteFunc:  deleteTestDeploymentConfig,
+		},
 		{
 			name:        "CronJob",
 			createFunc:  createTestCronJobWithAnnotations,
@@ -195,6 +209,13 @@ func TestResourceItems(t *testing.T) {
 			deleteFunc:    deleteTestDeployments,
 			expectedCount: 2,
 		},
+		{
+			name:          "DeploymentConfigs",
+			createFunc:    createTestDeploymentConfigs,
+			getItemsFunc:  callbacks.GetDeploymentConfigItems,
+			deleteFunc:    deleteTestDeploymentConfigs,
+			expectedCount: 2,
+		},
 		{
 			name:          "CronJobs",
 			createFunc:    createTestCronJobs,
@@ -245,6 +266,7 @@ func TestGetAnnotations(t *testing.T) {
 		getFunc  func(runtime.Object) map[string]string
 	}{
 		{"Deployment", &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Annotations: testAnnotations}}, callbacks.GetDeploymentAnnotations},
+		{"DeploymentConfig", &openshiftv1.DeploymentConfig{ObjectMeta: metav1.ObjectMeta{Annotations: testAnnotations}}, callbacks.GetDeploymentConfigAnnotations},
 		{"CronJob", &batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Annotations: testAnnotations}}, callbacks.GetCronJobAnnotations},
 		{"Job", &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Annotations: testAnnotations}}, callbacks.GetJobAnnotations},
 		{"DaemonSet", &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Annotations: testAnnotations}}, callbacks.GetDaemonSetAnnotations},
@@ -268,6 +290,7 @@ func TestGetPodAnnotations(t *testing.T) {
 		getFunc  func(runtime.Object) map[string]string
 	}{
 		{"Deployment", createResourceWithPodAnnotations(&appsv1.Deployment{}, testAnnotations), callbacks.GetDeploymentPodAnnotations},
+		{"DeploymentConfig", createResourceWithPodAnnotations(&openshiftv1.DeploymentConfig{}, testAnnotations), callbacks.GetDeploymentConfigPodAnnotations},
 		{"CronJob", createResourceWithPodAnnotations(&batchv1.CronJob{}, testAnnotations), callbacks.GetCronJobPodAnnotations},
 		{"Job", createResourceWithPodAnnotations(&batchv1.Job{}, testAnnotations), callbacks.GetJobPodAnnotations},
 		{"DaemonSet", createResourceWithPodAnnotations(&appsv1.DaemonSet{}, testAnnotations), callbacks.GetDaemonSetPodAnnotations},
@@ -291,6 +314,7 @@ func TestGetContainers(t *testing.T) {
 		getFunc  func(runtime.Object) []v1.Container
 	}{
 		{"Deployment", createResourceWithContainers(&appsv1.Deployment{}, fixtures.defaultContainers), callbacks.GetDeploymentContainers},
+		{"DeploymentConfig", createResourceWithContainers(&openshiftv1.DeploymentConfig{}, fixtures.defaultContainers), callbacks.GetDeploymentConfigContainers},
 		{"DaemonSet", createResourceWithContainers(&appsv1.DaemonSet{}, fixtures.defaultContainers), callbacks.GetDaemonSetContainers},
 		{"StatefulSet", createResourceWithContainers(&appsv1.StatefulSet{}, fixtures.defaultContainers), callbacks.GetStatefulSetContainers},
 		{"CronJob", createResourceWithContainers(&batchv1.CronJob{}, fixtures.defaultContainers), callbacks.GetCronJobContainers},
@@ -314,6 +338,7 @@ func TestGetInitContainers(t *testing.T) {
 		getFunc  func(runtime.Object) []v1.Container
 	}{
 		{"Deployment", createResourceWithInitContainers(&appsv1.Deployment{}, fixtures.defaultInitContainers), callbacks.GetDeploymentInitContainers},
+		{"DeploymentConfig", createResourceWithInitContainers(&openshiftv1.DeploymentConfig{}, fixtures.defaultInitContainers), callbacks.GetDeploymentConfigInitContainers},
 		{"DaemonSet", createResourceWithInitContainers(&appsv1.DaemonSet{}, fixtures.defaultInitContainers), callbacks.GetDaemonSetInitContainers},
 		{"StatefulSet", createResourceWithInitContainers(&appsv1.StatefulSet{}, fixtures.defaultInitContainers), callbacks.GetStatefulSetInitContainers},
 		{"CronJob", createResourceWithInitContainers(&batchv1.CronJob{}, fixtures.defaultInitContainers), callbacks.GetCronJobInitContainers},
@@ -338,6 +363,7 @@ func TestUpdateResources(t *testing.T) {
 		deleteFunc func(kube.Clients, string, string) error
 	}{
 		{"Deployment", createTestDeploymentWithAnnotations, callbacks.UpdateDeployment, deleteTestDeployment},
+		{"DeploymentConfig", createTestDeploymentConfigWithAnnotations, callbacks.UpdateDeploymentConfig, deleteTestDeploymentConfig},
 		{"DaemonSet", createTestDaemonSetWithAnnotations, callbacks.UpdateDaemonSet, deleteTestDaemonSet},
 		{"StatefulSet", createTestStatefulSetWithAnnotations, callbacks.UpdateStatefulSet, deleteTestStatefulSet},
 	}
@@ -375,6 +401,12 @@ func TestPatchResources(t *testing.T) {
 			assert.NoError(t, err)
 			assert.Equal(t, "test", patchedResource.(*appsv1.Deployment).ObjectMeta.Annotations["test"])
 		}},
+		{"DeploymentConfig", createTestDeploymentConfigWithAnnotations, callbacks.PatchDeploymentConfig, deleteTestDeploymentConfig, func(err error) {
+			assert.NoError(t, err)
+			patchedResource, err := callbacks.GetDeploymentConfigItem(clients, "test-deploymentconfig", fixtures.namespace)
+			assert.NoError(t, err)
+			assert.Equal(t, "test", patchedResource.(*openshiftv1.DeploymentConfig).ObjectMeta.Annotations["test"])
+		}},
 		{"DaemonSet", createTestDaemonSetWithAnnotations, callbacks.PatchDaemonSet, deleteTestDaemonSet, func(err error) {
 			assert.NoError(t, err)
 			patchedResource, err := callbacks.GetDaemonSetItem(clients, "test-daemonset", fixtures.namespace)
@@ -460,6 +492,7 @@ func TestGetVolumes(t *testing.T) {
 		getFunc  func(runtime.Object) []v1.Volume
 	}{
 		{"Deployment", createResourceWithVolumes(&appsv1.Deployment{}, fixtures.defaultVolumes), callbacks.GetDeploymentVolumes},
+		{"DeploymentConfig", createResourceWithVolumes(&openshiftv1.DeploymentConfig{}, fixtures.defaultVolumes), callbacks.GetDeploymentConfigVolumes},
 		{"CronJob", createResourceWithVolumes(&batchv1.CronJob{}, fixtures.defaultVolumes), callbacks.GetCronJobVolumes},
 		{"Job", createResourceWithVolumes(&batchv1.Job{}, fixtures.defaultVolumes), callbacks.GetJobVolumes},
 		{"DaemonSet", createResourceWithVolumes(&appsv1.DaemonSet{}, fixtures.defaultVolumes), callbacks.GetDaemonSetVolumes},
@@ -538,6 +571,26 @@ func deleteTestDeployments(clients kube.Clients, namespace string) error {
 	return nil
 }
 
+func createTestDeploymentConfigs(clients kube.Clients, namespace string) error {
+	for i := 1; i <= 2; i++ {
+		_, err := testutil.CreateDeploymentConfig(clients.OpenshiftAppsClient, fmt.Sprintf("test-deploymentconfig-%d", i), namespace, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteTestDeploymentConfigs(clients kube.Clients, namespace string) error {
+	for i := 1; i <= 2; i++ {
+		err := testutil.DeleteDeploymentConfig(clients.OpenshiftAppsClient, namespace, fmt.Sprintf("test-deploymentconfig-%d", i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createTestCronJobs(clients kube.Clients, namespace string) error {
 	for i := 1; i <= 2; i++ {
 		_, err := testutil.CreateCronJob(clients.KubernetesClient, fmt.Sprintf("test-cron-%d", i), namespace, false)
@@ -622,6 +675,9 @@ func createResourceWithPodAnnotations(obj runtime.Object, annotations map[string
 	switch v := obj.(type) {
 	case *appsv1.Deployment:
 		v.Spec.Template.ObjectMeta.Annotations = annotations
+	case *openshiftv1.DeploymentConfig:
+		v.Spec.Template = &v1.PodTemplateSpec{}
+		v.Spec.Template.ObjectMeta.Annotations = annotations
 	case *appsv1.DaemonSet:
 		v.Spec.Template.ObjectMeta.Annotations = annotations
 	case *appsv1.StatefulSet:
@@ -640,6 +696,9 @@ func createResourceWithContainers(obj runtime.Object, containers []v1.Container)
 	switch v := obj.(type) {
 	case *appsv1.Deployment:
 		v.Spec.Template.Spec.Containers = containers
+	case *openshiftv1.DeploymentConfig:
+		v.Spec.Template = &v1.PodTemplateSpec{}
+		v.Spec.Template.Spec.Containers = containers
 	case *appsv1.DaemonSet:
 		v.Spec.Template.Spec.Containers = containers
 	case *appsv1.StatefulSet:
@@ -658,6 +717,9 @@ func createResourceWithInitContainers(obj runtime.Object, initContainers []v1.Co
 	switch v := obj.(type) {
 	case *appsv1.Deployment:
 		v.Spec.Template.Spec.InitContainers = initContainers
+	case *openshiftv1.DeploymentConfig:
+		v.Spec.Template = &v1.PodTemplateSpec{}
+		v.Spec.Template.Spec.InitContainers = initContainers
 	case *appsv1.DaemonSet:
 		v.Spec.Template.Spec.InitContainers = initContainers
 	case *appsv1.StatefulSet:
@@ -680,6 +742,9 @@ func createResourceWithVolumes(obj runtime.Object, volumes []v1.Volume) runtime.
 		v.Spec.JobTemplate.Spec.Template.Spec.Volumes = volumes
 	case *batchv1.Job:
 		v.Spec.Template.Spec.Volumes = volumes
+	case *openshiftv1.DeploymentConfig:
+		v.Spec.Template = &v1.PodTemplateSpec{}
+		v.Spec.Template.Spec.Volumes = volumes
 	case *appsv1.DaemonSet:
 		v.Spec.Template.Spec.Volumes = volumes
 	case *appsv1.StatefulSet:
@@ -703,6 +768,21 @@ func deleteTestDeployment(clients kube.Clients, namespace, name string) error {
 	return clients.KubernetesClient.AppsV1().Deployments(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
 }
 
+func createTestDeploymentConfigWithAnnotations(clients kube.Clients, namespace, version string) (runtime.Object, error) {
+	deploymentConfig := &openshiftv1.DeploymentConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-deploymentconfig",
+			Namespace:   namespace,
+			Annotations: map[string]string{"version": version},
+		},
+	}
+	return clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Create(context.TODO(), deploymentConfig, metav1.CreateOptions{})
+}
+
+func deleteTestDeploymentConfig(clients kube.Clients, namespace, name string) error {
+	return clients.OpenshiftAppsClient.AppsV1().DeploymentConfigs(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
+
 func createTestDaemonSetWithAnnotations(clients kube.Clients, namespace, version string) (runtime.Object, error) {
 	daemonSet := &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/stakater/Reloader/internal/pkg/constants"
 	"github.com/stakater/Reloader/internal/pkg/leadership"
@@ -15,10 +16,16 @@ import (
 	"github.com/spf13/cobra"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
 	"github.com/stakater/Reloader/internal/pkg/controller"
+	"github.com/stakater/Reloader/internal/pkg/deadletter"
+	"github.com/stakater/Reloader/internal/pkg/handler"
+	"github.com/stakater/Reloader/internal/pkg/index"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/policy"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
 )
@@ -44,6 +51,8 @@ func NewReloaderCommand() *cobra.Command {
 	cmd.PersistentFlags().StringVar(&options.LogFormat, "log-format", "", "Log format to use (empty string for text, or JSON)")
 	cmd.PersistentFlags().StringVar(&options.LogLevel, "log-level", "info", "Log level to use (trace, debug, info, warning, error, fatal and panic)")
 	cmd.PersistentFlags().StringVar(&options.WebhookUrl, "webhook-url", "", "webhook to trigger instead of performing a reload")
+	cmd.PersistentFlags().StringVar(&options.WebhookPayloadTemplate, "webhook-payload-template", "", "path to a Go template used to render the webhook payload")
+	cmd.PersistentFlags().StringArrayVar(&options.WebhookHeaders, "webhook-header", []string{}, "custom header in the format Name=Value sent with the webhook, can be repeated")
 	cmd.PersistentFlags().StringSlice("resources-to-ignore", []string{}, "list of resources to ignore (valid options 'configMaps' or 'secrets')")
 	cmd.PersistentFlags().StringSlice("namespaces-to-ignore", []string{}, "list of namespaces to ignore")
 	cmd.PersistentFlags().StringSlice("namespace-selector", []string{}, "list of key:value labels to filter on for namespaces")
@@ -53,7 +62,35 @@ func NewReloaderCommand() *cobra.Command {
 	cmd.PersistentFlags().StringVar(&options.ReloadOnCreate, "reload-on-create", "false", "Add support to watch create events")
 	cmd.PersistentFlags().StringVar(&options.ReloadOnDelete, "reload-on-delete", "false", "Add support to watch delete events")
 	cmd.PersistentFlags().BoolVar(&options.EnableHA, "enable-ha", false, "Adds support for running multiple replicas via leadership election")
-	cmd.PersistentFlags().BoolVar(&options.SyncAfterRestart, "sync-after-restart", false, "Sync add events after reloader restarts")
+	cmd.PersistentFlags().BoolVar(&options.SyncAfterRestart, "sync-after-restart", false, "Reload the workloads which were reloaded for a previous content of a configmap or secret on start, to catch up on changes missed while Reloader was not running")
+	cmd.PersistentFlags().DurationVar(&options.DriftScanInterval, "drift-scan-interval", 0, "Interval at which the workloads are checked for running with an outdated content of a configmap or secret, 0 disables the scans")
+	cmd.PersistentFlags().BoolVar(&options.DriftFix, "drift-fix", false, "Reload the workloads found by the drift scans instead of only reporting them")
+	cmd.PersistentFlags().IntVar(&options.Workers, "workers", 1, "Number of changes of configmaps and of secrets processed in parallel, changes of different namespaces are processed in turns and changes of the same resource in order")
+	cmd.PersistentFlags().IntVar(&options.MaxRetries, "max-retries", 5, "Number of times a change which failed to be processed is retried before it is dropped")
+	cmd.PersistentFlags().DurationVar(&options.RetryBaseDelay, "retry-base-delay", 5*time.Millisecond, "Delay before the first retry of a change, it doubles with every retry")
+	cmd.PersistentFlags().DurationVar(&options.RetryMaxDelay, "retry-max-delay", 1000*time.Second, "Maximum delay between two retries of a change")
+	cmd.PersistentFlags().StringVar(&options.DeadLetterConfigMap, "dead-letter-configmap", "", "Name of the configmap in the namespace of Reloader persisting the dropped changes, they are only kept in memory if it is empty")
+	cmd.PersistentFlags().StringVar(&options.DeadLetterAdminAddress, "dead-letter-admin-address", "", "Address of a separate listener re-driving and discarding the dropped changes, e.g. 127.0.0.1:9091. They can only be listed on the metrics port if it is empty")
+	cmd.PersistentFlags().BoolVar(&options.DryRun, "dry-run", false, "Log and report the reloads that would be performed without updating any workload")
+	cmd.PersistentFlags().DurationVar(&options.DebounceWindow, "debounce-window", 0, "Aggregate changes to a workload for this duration and reload it once, 0 disables debouncing")
+	cmd.PersistentFlags().BoolVar(&options.WaitForRollout, "wait-for-rollout", false, "Follow the rollout of reloaded workloads and report whether it succeeded through events, metrics and alerts")
+	cmd.PersistentFlags().DurationVar(&options.RolloutTimeout, "rollout-timeout", 15*time.Minute, "Maximum time the rollout of a reloaded workload is followed before it is reported as failed")
+	cmd.PersistentFlags().IntVar(&options.MaxConcurrentRollouts, "max-concurrent-rollouts", 0, "Maximum number of rollouts started by reloads in flight at once across all namespaces, further reloads are queued until a rollout finished, 0 is unlimited")
+	cmd.PersistentFlags().IntVar(&options.PauseAfterFailedRollouts, "pause-after-failed-rollouts", 1, "Number of failed rollouts after which no further reloads are started until the failed workloads recover, 0 does not pause. Only applies with max-concurrent-rollouts")
+	cmd.PersistentFlags().DurationVar(&options.MaxPausedDuration, "max-paused-duration", time.Hour, "Maximum time a change waits while reloads are paused after failed rollouts, it is dropped and reported afterwards. 0 waits until the reloads resume")
+	cmd.PersistentFlags().DurationVar(&options.ReloadStagger, "reload-stagger", 0, "Minimum time between the start of two reloads")
+	cmd.PersistentFlags().DurationVar(&options.ReloadJitter, "reload-jitter", 0, "Maximum random time added to the stagger between two reloads")
+	cmd.PersistentFlags().BoolVar(&options.EnableReloadPolicies, "enable-reload-policies", false, "Watch ReloadPolicy resources selecting workloads and sources, the custom resource definition has to be installed")
+	cmd.PersistentFlags().IntVar(&options.HistoryLimit, "history-limit", 5, "Number of previous revisions recorded for configmaps with the history annotation")
+	cmd.PersistentFlags().StringVar(&options.ReloadWindow, "reload-window", "", "Cron expression at which the reload window opens, changes detected outside of it are deferred until it opens")
+	cmd.PersistentFlags().DurationVar(&options.ReloadWindowDuration, "reload-window-duration", time.Hour, "Duration of the reload windows")
+	cmd.PersistentFlags().StringVar(&options.ReloadWindowTimezone, "reload-window-timezone", "UTC", "Timezone of the reload windows")
+	cmd.PersistentFlags().StringVar(&options.DeferredReloadsConfigMap, "deferred-reloads-configmap", "", "Name of the configmap in the namespace of Reloader persisting the deferred reloads, they are only kept in memory if it is empty")
+	cmd.PersistentFlags().DurationVar(&options.SignalDelay, "signal-delay", 90*time.Second, "Time waited for changes to be propagated to the mounted files of the pods before they are reloaded in place with the signal strategy")
+	cmd.PersistentFlags().StringVar(&options.SignalImage, "signal-image", "busybox:1.36", "Image of the ephemeral containers sending signals and verifying mounted files for in-place reloads, it has to provide sh, kill and sha256sum")
+	cmd.PersistentFlags().IntVar(&options.MaxEphemeralContainers, "max-ephemeral-containers", 20, "Maximum number of ephemeral containers added to a pod by in-place reloads, they can not be removed and the workload is restarted instead once it is reached")
+	cmd.PersistentFlags().DurationVar(&options.ReloadHookTimeout, "reload-hook-timeout", 3*time.Minute, "Maximum time waited for changes to be propagated to the mounted files of a pod before its reload hook is called")
+	cmd.PersistentFlags().StringSliceVar(&options.CustomWorkloads, "custom-workloads", []string{}, "list of custom resources with a pod template to reload, in the format group/version/resource[:template.path]")
 
 	return cmd
 }
@@ -61,7 +98,7 @@ func NewReloaderCommand() *cobra.Command {
 func validateFlags(*cobra.Command, []string) error {
 	// Ensure the reload strategy is one of the following...
 	var validReloadStrategy bool
-	valid := []string{constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy}
+	valid := []string{constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, constants.SignalReloadStrategy, constants.RestartReloadStrategy}
 	for _, s := range valid {
 		if s == options.ReloadStrategy {
 			validReloadStrategy = true
@@ -73,6 +110,81 @@ func validateFlags(*cobra.Command, []string) error {
 		return errors.New(err)
 	}
 
+	if options.Workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+
+	if options.MaxRetries < 0 {
+		return errors.New("max-retries must not be negative")
+	}
+
+	if options.RetryBaseDelay <= 0 || options.RetryMaxDelay < options.RetryBaseDelay {
+		return errors.New("retry-base-delay must be positive and retry-max-delay must not be lower")
+	}
+
+	if options.DriftScanInterval < 0 {
+		return errors.New("drift-scan-interval must not be negative")
+	}
+
+	if options.DebounceWindow < 0 {
+		return errors.New("debounce-window must not be negative")
+	}
+
+	if options.RolloutTimeout <= 0 {
+		return errors.New("rollout-timeout must be positive")
+	}
+
+	if options.MaxConcurrentRollouts < 0 {
+		return errors.New("max-concurrent-rollouts must not be negative")
+	}
+
+	if options.PauseAfterFailedRollouts < 0 {
+		return errors.New("pause-after-failed-rollouts must not be negative")
+	}
+
+	if options.MaxPausedDuration < 0 {
+		return errors.New("max-paused-duration must not be negative")
+	}
+
+	if options.ReloadStagger < 0 || options.ReloadJitter < 0 {
+		return errors.New("reload-stagger and reload-jitter must not be negative")
+	}
+
+	if options.MaxEphemeralContainers <= 0 {
+		return errors.New("max-ephemeral-containers must be positive")
+	}
+
+	if options.SignalDelay < 0 {
+		return errors.New("signal-delay must not be negative")
+	}
+
+	if options.ReloadHookTimeout <= 0 {
+		return errors.New("reload-hook-timeout must be positive")
+	}
+
+	if options.HistoryLimit <= 0 {
+		return errors.New("history-limit must be positive")
+	}
+
+	// Validate that the webhook options are correct
+	if err := handler.ValidateWebhookOptions(); err != nil {
+		return err
+	}
+
+	if options.ReloadWindowDuration <= 0 {
+		return errors.New("reload-window-duration must be positive")
+	}
+
+	// Validate that the global reload window can be parsed
+	if err := handler.ValidateReloadWindowOptions(); err != nil {
+		return err
+	}
+
+	// Validate that custom workloads can be parsed
+	if _, err := callbacks.ParseCustomWorkloads(options.CustomWorkloads); err != nil {
+		return err
+	}
+
 	// Validate that HA options are correct
 	if options.EnableHA {
 		if err := validateHAEnvs(); err != nil {
@@ -174,15 +286,70 @@ func startReloader(cmd *cobra.Command, args []string) {
 		logrus.Warnf("webhook-url is set, will only send webhook, no resources will be reloaded")
 	}
 
+	if options.DeferredReloadsConfigMap != "" && os.Getenv(constants.PodNamespaceEnv) == "" {
+		logrus.Warnf("%s is unset, deferred reloads are only kept in memory", constants.PodNamespaceEnv)
+	}
+
+	if options.DeadLetterConfigMap != "" && os.Getenv(constants.PodNamespaceEnv) == "" {
+		logrus.Warnf("%s is unset, dropped changes are only kept in memory", constants.PodNamespaceEnv)
+	}
+
+	if options.DryRun {
+		logrus.Warnf("dry-run is set, will only report reloads, no resources will be reloaded")
+	}
+
 	collectors := metrics.SetupPrometheusEndpoint()
 
+	// Keep the workloads in an informer backed index, changes are looked up in it instead of listing all workloads
+	workloadIndex := index.NewWorkloadIndex(kube.GetClients(), currentNamespace)
+	index.SetWorkloadIndex(workloadIndex)
+	indexStop := make(chan struct{})
+	defer close(indexStop)
+	go workloadIndex.Run(indexStop)
+
+	if options.EnableReloadPolicies {
+		policyStore := policy.NewStore(kube.GetClients().DynamicClient, currentNamespace)
+		policy.SetStore(policyStore)
+		go policyStore.Run(indexStop)
+	}
+
+	// Namespaces are selected in an informer backed cache, so label changes of namespaces take effect right away
+	var namespaceCache *controller.NamespaceCache
+	if len(namespaceLabelSelector) > 0 {
+		namespaceCache, err = controller.NewNamespaceCache(clientset, namespaceLabelSelector)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		go namespaceCache.Run(indexStop)
+	}
+
+	// Namespaces checked for freezes and reload windows are read from an informer backed cache as well, the namespaces
+	// can not be listed when watching a single namespace
+	if namespaceCache != nil {
+		handler.SetNamespaceLister(namespaceCache.Lister())
+	} else if currentNamespace == "" {
+		informerFactory := informers.NewSharedInformerFactory(clientset, 0)
+		handler.SetNamespaceLister(informerFactory.Core().V1().Namespaces().Lister())
+		informerFactory.Start(indexStop)
+	}
+
+	// Changes dropped after all retries are recorded and can be listed and re-driven through the endpoint
+	deadLetters := deadletter.NewStore(clientset, os.Getenv(constants.PodNamespaceEnv), options.DeadLetterConfigMap, options.DryRun)
+	deadLetters.Load()
+	deadLetters.SetupEndpoint()
+	if options.DeadLetterAdminAddress != "" {
+		go func() {
+			logrus.Fatal(deadLetters.ServeAdmin(options.DeadLetterAdminAddress))
+		}()
+	}
+
 	var controllers []*controller.Controller
 	for k := range kube.ResourceMap {
-		if ignoredResourcesList.Contains(k) || (len(namespaceLabelSelector) == 0 && k == "namespaces") {
+		if ignoredResourcesList.Contains(k) {
 			continue
 		}
 
-		c, err := controller.NewController(clientset, k, currentNamespace, ignoredNamespacesList, namespaceLabelSelector, resourceLabelSelector, collectors)
+		c, err := controller.NewController(clientset, k, currentNamespace, ignoredNamespacesList, namespaceCache, resourceLabelSelector, deadLetters, collectors)
 		if err != nil {
 			logrus.Fatalf("%s", err)
 		}
@@ -197,7 +364,7 @@ func startReloader(cmd *cobra.Command, args []string) {
 		stop := make(chan struct{})
 		defer close(stop)
 		logrus.Infof("Starting Controller to watch resource type: %s", k)
-		go c.Run(1, stop)
+		go c.Run(options.Workers, stop)
 	}
 
 	// Run leadership election
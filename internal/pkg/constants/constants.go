@@ -22,6 +22,17 @@ const (
 	EnvVarsReloadStrategy = "env-vars"
 	// AnnotationsReloadStrategy instructs Reloader to add pod template annotations to facilitate a restart
 	AnnotationsReloadStrategy = "annotations"
+	// SignalReloadStrategy instructs Reloader to signal the running containers or call their reload endpoint instead of restarting them
+	SignalReloadStrategy = "signal"
+	// RestartReloadStrategy instructs Reloader to set the restartedAt pod template annotation like kubectl rollout restart
+	RestartReloadStrategy = "restart"
+	// RestartedAtAnnotation is the pod template annotation set by kubectl rollout restart
+	RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+	// WebhookHMACSecretEnv is the environment variable holding the secret used to sign webhook payloads
+	WebhookHMACSecretEnv = "WEBHOOK_HMAC_SECRET"
+	// WebhookSignatureHeader is the header carrying the HMAC-SHA256 signature of the webhook payload
+	WebhookSignatureHeader = "X-Reloader-Signature-256"
 )
 
 // Leadership election related consts
@@ -1,16 +1,23 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	alert "github.com/stakater/Reloader/internal/pkg/alerts"
+	"github.com/stakater/Reloader/internal/pkg/deadletter"
 	"github.com/stakater/Reloader/internal/pkg/handler"
+	"github.com/stakater/Reloader/internal/pkg/history"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -21,13 +28,13 @@ import (
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/kubectl/pkg/scheme"
-	"k8s.io/utils/strings/slices"
 )
 
 // Controller for checking events
 type Controller struct {
 	client            kubernetes.Interface
 	indexer           cache.Indexer
+	store             cache.Store
 	queue             workqueue.TypedRateLimitingInterface[any]
 	informer          cache.Controller
 	namespace         string
@@ -35,31 +42,31 @@ type Controller struct {
 	ignoredNamespaces util.List
 	collectors        metrics.Collectors
 	recorder          record.EventRecorder
-	namespaceSelector string
+	namespaces        *NamespaceCache
 	resourceSelector  string
+	deadLetters       *deadletter.Store
+	// pausedSince is the time the change of each source started waiting while the reloads are paused
+	pausedMu    sync.Mutex
+	pausedSince map[string]time.Time
 }
 
 // controllerInitialized flag determines whether controlled is being initialized
 var secretControllerInitialized bool = false
 var configmapControllerInitialized bool = false
-var selectedNamespacesCache []string
 
-// NewController for initializing a Controller
+// NewController for initializing a Controller, changes are only handled in the namespaces of the namespace cache
+// unless it is nil. Changes dropped after all retries are recorded in the dead-letter store unless it is nil
 func NewController(
-	client kubernetes.Interface, resource string, namespace string, ignoredNamespaces []string, namespaceLabelSelector string, resourceLabelSelector string, collectors metrics.Collectors) (*Controller, error) {
-
-	if options.SyncAfterRestart {
-		secretControllerInitialized = true
-		configmapControllerInitialized = true
-	}
+	client kubernetes.Interface, resource string, namespace string, ignoredNamespaces []string, namespaces *NamespaceCache, resourceLabelSelector string, deadLetters *deadletter.Store, collectors metrics.Collectors) (*Controller, error) {
 
 	c := Controller{
 		client:            client,
 		namespace:         namespace,
 		ignoredNamespaces: ignoredNamespaces,
-		namespaceSelector: namespaceLabelSelector,
+		namespaces:        namespaces,
 		resourceSelector:  resourceLabelSelector,
 		resource:          resource,
+		deadLetters:       deadLetters,
 	}
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
@@ -67,12 +74,15 @@ func NewController(
 	})
 	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: fmt.Sprintf("reloader-%s", resource)})
 
-	queue := workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	// Retries of a change are delayed exponentially, retries of all changes together are limited like by the default
+	// controller rate limiter
+	queue := newFairQueue(workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[any](options.RetryBaseDelay, options.RetryMaxDelay),
+		&workqueue.TypedBucketRateLimiter[any]{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	))
 
 	optionsModifier := func(options *metav1.ListOptions) {
-		if resource == "namespaces" {
-			options.LabelSelector = c.namespaceSelector
-		} else if len(c.resourceSelector) > 0 {
+		if len(c.resourceSelector) > 0 {
 			options.LabelSelector = c.resourceSelector
 		} else {
 			options.FieldSelector = fields.Everything().String()
@@ -81,7 +91,7 @@ func NewController(
 
 	listWatcher := cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), resource, namespace, optionsModifier)
 
-	_, informer := cache.NewInformerWithOptions(cache.InformerOptions{
+	store, informer := cache.NewInformerWithOptions(cache.InformerOptions{
 		ListerWatcher: listWatcher,
 		ObjectType:    kube.ResourceMap[resource],
 		ResyncPeriod:  0,
@@ -92,26 +102,24 @@ func NewController(
 		},
 		Indexers: cache.Indexers{},
 	})
+	c.store = store
 	c.informer = informer
 	c.queue = queue
 	c.collectors = collectors
 	c.recorder = recorder
 
+	if deadLetters != nil {
+		deadLetters.RegisterRedriver(resource, c.redrive)
+	}
+
 	logrus.Infof("created controller for: %s", resource)
 	return &c, nil
 }
 
 // Add function to add a new object to the queue in case of creating a resource
 func (c *Controller) Add(obj interface{}) {
-
-	switch object := obj.(type) {
-	case *v1.Namespace:
-		c.addSelectedNamespaceToCache(*object)
-		return
-	}
-
 	if options.ReloadOnCreate == "true" {
-		if !c.resourceInIgnoredNamespace(obj) && c.resourceInSelectedNamespaces(obj) && secretControllerInitialized && configmapControllerInitialized {
+		if !c.resourceInIgnoredNamespace(obj) && c.resourceInSelectedNamespaces(obj) && !resourceIsHistory(obj) && secretControllerInitialized && configmapControllerInitialized {
 			c.queue.Add(handler.ResourceCreatedHandler{
 				Resource:   obj,
 				Collectors: c.collectors,
@@ -132,46 +140,31 @@ func (c *Controller) resourceInIgnoredNamespace(raw interface{}) bool {
 }
 
 func (c *Controller) resourceInSelectedNamespaces(raw interface{}) bool {
-	if len(c.namespaceSelector) == 0 {
+	if c.namespaces == nil {
 		return true
 	}
 
 	switch object := raw.(type) {
 	case *v1.ConfigMap:
-		if slices.Contains(selectedNamespacesCache, object.GetNamespace()) {
-			return true
-		}
+		return c.namespaces.Contains(object.GetNamespace())
 	case *v1.Secret:
-		if slices.Contains(selectedNamespacesCache, object.GetNamespace()) {
-			return true
-		}
+		return c.namespaces.Contains(object.GetNamespace())
 	}
 	return false
 }
 
-func (c *Controller) addSelectedNamespaceToCache(namespace v1.Namespace) {
-	selectedNamespacesCache = append(selectedNamespacesCache, namespace.GetName())
-	logrus.Infof("added namespace to be watched: %s", namespace.GetName())
-}
-
-func (c *Controller) removeSelectedNamespaceFromCache(namespace v1.Namespace) {
-	for i, v := range selectedNamespacesCache {
-		if v == namespace.GetName() {
-			selectedNamespacesCache = append(selectedNamespacesCache[:i], selectedNamespacesCache[i+1:]...)
-			logrus.Infof("removed namespace from watch: %s", namespace.GetName())
-			return
-		}
+// resourceIsHistory returns whether the resource is a configmap holding the history of another configmap
+func resourceIsHistory(raw interface{}) bool {
+	if configmap, ok := raw.(*v1.ConfigMap); ok {
+		_, found := configmap.Labels[history.HistoryOfLabel]
+		return found
 	}
+	return false
 }
 
 // Update function to add an old object and a new object to the queue in case of updating a resource
 func (c *Controller) Update(old interface{}, new interface{}) {
-	switch new.(type) {
-	case *v1.Namespace:
-		return
-	}
-
-	if !c.resourceInIgnoredNamespace(new) && c.resourceInSelectedNamespaces(new) {
+	if !c.resourceInIgnoredNamespace(new) && c.resourceInSelectedNamespaces(new) && !resourceIsHistory(new) {
 		c.queue.Add(handler.ResourceUpdatedHandler{
 			Resource:    new,
 			OldResource: old,
@@ -185,7 +178,7 @@ func (c *Controller) Update(old interface{}, new interface{}) {
 func (c *Controller) Delete(old interface{}) {
 
 	if options.ReloadOnDelete == "true" {
-		if !c.resourceInIgnoredNamespace(old) && c.resourceInSelectedNamespaces(old) && secretControllerInitialized && configmapControllerInitialized {
+		if !c.resourceInIgnoredNamespace(old) && c.resourceInSelectedNamespaces(old) && !resourceIsHistory(old) && secretControllerInitialized && configmapControllerInitialized {
 			c.queue.Add(handler.ResourceDeleteHandler{
 				Resource:   old,
 				Collectors: c.collectors,
@@ -193,12 +186,6 @@ func (c *Controller) Delete(old interface{}) {
 			})
 		}
 	}
-
-	switch object := old.(type) {
-	case *v1.Namespace:
-		c.removeSelectedNamespaceFromCache(*object)
-		return
-	}
 }
 
 // Run function for controller which handles the queue
@@ -211,19 +198,65 @@ func (c *Controller) Run(threadiness int, stopCh chan struct{}) {
 	go c.informer.Run(stopCh)
 
 	// Wait for all involved caches to be synced, before processing items from the queue is started
-	if !cache.WaitForCacheSync(stopCh, c.informer.HasSynced) {
+	synced := []cache.InformerSynced{c.informer.HasSynced}
+	if c.namespaces != nil {
+		synced = append(synced, c.namespaces.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stopCh, synced...) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
 
+	// Changes missed while Reloader was not running are caught up before the changes detected since
+	if options.SyncAfterRestart {
+		c.queueReconciliation(false)
+	}
+	if options.DriftScanInterval > 0 {
+		go c.runDriftScans(options.DriftScanInterval, stopCh)
+	}
+
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
+	// Deferred reloads are applied by the controllers of the leader only
+	handler.RunDeferredReloads(c.collectors, c.recorder, stopCh)
+
 	<-stopCh
 	logrus.Infof("Stopping Controller")
 }
 
+// queueReconciliation queues the check of the workloads consuming each watched resource, the workloads which were
+// reloaded for a previous content of the resource are reloaded. With drift they are reported and only reloaded if
+// fixing drift is enabled
+func (c *Controller) queueReconciliation(drift bool) {
+	for _, obj := range c.store.List() {
+		if !c.resourceInIgnoredNamespace(obj) && c.resourceInSelectedNamespaces(obj) && !resourceIsHistory(obj) {
+			c.queue.Add(handler.ResourceReconcileHandler{
+				Resource:   obj,
+				Collectors: c.collectors,
+				Recorder:   c.recorder,
+				Drift:      drift,
+			})
+		}
+	}
+}
+
+// runDriftScans checks the workloads for drift at the interval until stopCh is closed
+func (c *Controller) runDriftScans(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			logrus.Debugf("Scanning the workloads consuming %s for drift", c.resource)
+			c.queueReconciliation(true)
+		}
+	}
+}
+
 func (c *Controller) runWorker() {
 	// At this point the controller is fully initialized and we can start processing the resources
 	if c.resource == "secrets" {
@@ -256,16 +289,36 @@ func (c *Controller) processNextItem() bool {
 
 // handleErr checks if an error happened and makes sure we will retry later.
 func (c *Controller) handleErr(err error, key interface{}) {
+	paused := c.trackPaused(err, key)
 	if err == nil {
 		// Forget about the #AddRateLimited history of the key on every successful synchronization.
 		// This ensures that future processing of updates for this key is not delayed because of
 		// an outdated error history.
 		c.queue.Forget(key)
+		// A dropped change of the source is superseded by the change processed now, drift scans do not reload
+		// the workloads unless fixing drift is enabled
+		if scan, ok := key.(handler.ResourceReconcileHandler); c.deadLetters != nil && (!ok || !scan.Drift || options.DriftFix) {
+			c.deadLetters.Remove(c.getDeadLetterKey(key))
+		}
 		return
 	}
 
-	// This controller retries 5 times if something goes wrong. After that, it stops trying.
-	if c.queue.NumRequeues(key) < 5 {
+	// A change waiting for a rollout slot is queued again without counting as a retry
+	var requeue *handler.RequeueError
+	if errors.As(err, &requeue) {
+		// A change waiting for failed workloads to recover is bounded, they may never recover without a manual fix
+		if options.MaxPausedDuration > 0 && paused > options.MaxPausedDuration {
+			c.dropChange(err, key, c.queue.NumRequeues(key), fmt.Sprintf("waiting %s for the reloads to resume", paused.Round(time.Second)))
+			return
+		}
+		logrus.Debugf("Queueing the change again: %v", err)
+		c.queue.AddAfter(key, requeue.After)
+		return
+	}
+
+	// This controller retries MaxRetries times if something goes wrong. After that, it stops trying.
+	retries := c.queue.NumRequeues(key)
+	if retries < options.MaxRetries {
 		logrus.Errorf("Error syncing events: %v", err)
 
 		// Re-enqueue the key rate limited. Based on the rate limiter on the
@@ -274,9 +327,140 @@ func (c *Controller) handleErr(err error, key interface{}) {
 		return
 	}
 
+	c.dropChange(err, key, retries, fmt.Sprintf("%d retries", retries))
+}
+
+// dropChange stops processing the change, it is reported and recorded in the dead-letter store. after describes what
+// the change was given up after
+func (c *Controller) dropChange(err error, key interface{}, retries int, after string) {
 	c.queue.Forget(key)
 	// Report to an external entity that, even after several retries, we could not successfully process this key
 	runtime.HandleError(err)
 	logrus.Errorf("Dropping key out of the queue: %v", err)
 	logrus.Debugf("Dropping the key %q out of the queue: %v", key, err)
+	if resourceHandler, ok := key.(handler.ResourceHandler); ok {
+		config, _ := resourceHandler.GetConfig()
+		alert.SendFailureAlert(fmt.Sprintf(
+			"Reloader gave up processing the change of *%s* of type *%s* in namespace *%s* after %s: %v",
+			config.ResourceName, config.Type, config.Namespace, after, err))
+	}
+	c.recordDroppedChange(err, key, retries)
+}
+
+// trackPaused returns how long the change of the source waits while the reloads are paused, the time is reset once
+// the change is processed otherwise
+func (c *Controller) trackPaused(err error, key interface{}) time.Duration {
+	_, source := getQueueKeys(key)
+	c.pausedMu.Lock()
+	defer c.pausedMu.Unlock()
+
+	var requeue *handler.RequeueError
+	if !errors.As(err, &requeue) || !requeue.Paused {
+		delete(c.pausedSince, source)
+		return 0
+	}
+	if c.pausedSince == nil {
+		c.pausedSince = make(map[string]time.Time)
+	}
+	since, found := c.pausedSince[source]
+	if !found {
+		since = time.Now()
+		c.pausedSince[source] = since
+	}
+	return time.Since(since)
+}
+
+// recordDroppedChange counts the dropped change and records it in the dead-letter store
+func (c *Controller) recordDroppedChange(err error, key interface{}, retries int) {
+	namespace, source := getQueueKeys(key)
+	c.collectors.DroppedChanges.With(map[string]string{"source_type": c.resource, "namespace": namespace}).Inc()
+	if c.deadLetters == nil {
+		return
+	}
+
+	_, name, _ := cache.SplitMetaNamespaceKey(source)
+	entry := deadletter.Entry{
+		SourceType: c.resource,
+		Namespace:  namespace,
+		Name:       name,
+		Event:      getChangeEvent(key),
+		LastError:  err.Error(),
+		Retries:    retries,
+		DroppedAt:  time.Now().UTC(),
+	}
+	var reloadErr *handler.ReloadError
+	if errors.As(err, &reloadErr) {
+		entry.Targets = reloadErr.Targets
+	}
+	c.deadLetters.Add(entry)
+}
+
+// getDeadLetterKey returns the key of the dead-letter entry of the source of the change
+func (c *Controller) getDeadLetterKey(key interface{}) string {
+	namespace, source := getQueueKeys(key)
+	_, name, _ := cache.SplitMetaNamespaceKey(source)
+	return deadletter.Key(c.resource, namespace, name)
+}
+
+// getChangeEvent returns the kind of the change
+func getChangeEvent(key interface{}) string {
+	switch key.(type) {
+	case handler.ResourceCreatedHandler:
+		return "create"
+	case handler.ResourceDeleteHandler:
+		return "delete"
+	case handler.ResourceReconcileHandler:
+		return "reconcile"
+	default:
+		return "update"
+	}
+}
+
+// redrive queues the dropped change again with the current content of its source, a dropped catch-up is queued as a
+// catch-up again. A dropped deletion is queued again if the source still does not exist and reloads on delete are
+// enabled
+func (c *Controller) redrive(entry deadletter.Entry) error {
+	key := entry.Name
+	if entry.Namespace != "" {
+		key = entry.Namespace + "/" + entry.Name
+	}
+	obj, exists, err := c.store.GetByKey(key)
+	if err != nil {
+		return err
+	}
+
+	if exists && entry.Event == "reconcile" {
+		c.queue.Add(handler.ResourceReconcileHandler{
+			Resource:   obj,
+			Collectors: c.collectors,
+			Recorder:   c.recorder,
+		})
+		return nil
+	}
+	if exists {
+		c.queue.Add(handler.ResourceCreatedHandler{
+			Resource:   obj,
+			Collectors: c.collectors,
+			Recorder:   c.recorder,
+		})
+		return nil
+	}
+	if entry.Event != "delete" || options.ReloadOnDelete != "true" {
+		return fmt.Errorf("%s '%s' in namespace '%s' no longer exists", c.resource, entry.Name, entry.Namespace)
+	}
+
+	// The delete strategy only needs the name and namespace of the deleted source
+	deleted := kube.ResourceMap[c.resource].DeepCopyObject()
+	accessor, err := meta.Accessor(deleted)
+	if err != nil {
+		return err
+	}
+	accessor.SetName(entry.Name)
+	accessor.SetNamespace(entry.Namespace)
+	c.queue.Add(handler.ResourceDeleteHandler{
+		Resource:   deleted,
+		Collectors: c.collectors,
+		Recorder:   c.recorder,
+	})
+	return nil
 }
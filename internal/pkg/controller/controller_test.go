@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"os"
 	"testing"
 	"time"
@@ -10,7 +11,9 @@ import (
 
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/deadletter"
 	"github.com/stakater/Reloader/internal/pkg/handler"
 	"github.com/stakater/Reloader/internal/pkg/options"
 	"github.com/stakater/Reloader/internal/pkg/testutil"
@@ -45,10 +48,7 @@ func TestMain(m *testing.M) {
 
 	logrus.Infof("Creating controller")
 	for k := range kube.ResourceMap {
-		if k == "namespaces" {
-			continue
-		}
-		c, err := NewController(clients.KubernetesClient, k, namespace, []string{}, "", "", collectors)
+		c, err := NewController(clients.KubernetesClient, k, namespace, []string{}, nil, "", nil, collectors)
 		if err != nil {
 			logrus.Fatalf("%s", err)
 		}
@@ -2335,30 +2335,173 @@ func TestController_resourceInNamespaceSelector(t *testing.T) {
 			namespace, _ := fakeClient.CoreV1().Namespaces().Create(context.Background(), &tt.fields.namespace, metav1.CreateOptions{})
 			logrus.Infof("created fakeClient namespace for testing = %s", namespace.Name)
 
-			c := &Controller{
-				client:            fakeClient,
-				indexer:           tt.fields.indexer,
-				queue:             tt.fields.queue,
-				informer:          tt.fields.informer,
-				namespace:         tt.fields.namespace.ObjectMeta.Name,
-				namespaceSelector: tt.fields.namespaceSelector,
+			namespaces, err := NewNamespaceCache(fakeClient, tt.fields.namespaceSelector)
+			if err != nil {
+				t.Fatalf("Error while creating the namespace cache %v", err)
 			}
+			stop := make(chan struct{})
+			defer close(stop)
+			namespaces.Run(stop)
 
-			listOptions := metav1.ListOptions{}
-			listOptions.LabelSelector = tt.fields.namespaceSelector
-			namespaces, _ := fakeClient.CoreV1().Namespaces().List(context.Background(), listOptions)
-
-			for _, ns := range namespaces.Items {
-				c.addSelectedNamespaceToCache(ns)
+			c := &Controller{
+				client:     fakeClient,
+				indexer:    tt.fields.indexer,
+				queue:      tt.fields.queue,
+				informer:   tt.fields.informer,
+				namespace:  tt.fields.namespace.ObjectMeta.Name,
+				namespaces: namespaces,
 			}
 
 			if got := c.resourceInSelectedNamespaces(tt.args.raw); got != tt.want {
 				t.Errorf("Controller.resourceInNamespaceSelector() = %v, want %v", got, tt.want)
 			}
-
-			for _, ns := range namespaces.Items {
-				c.removeSelectedNamespaceFromCache(ns)
-			}
 		})
 	}
 }
+
+func TestHandleErrRecordsDroppedChanges(t *testing.T) {
+	maxRetries := options.MaxRetries
+	options.MaxRetries = 1
+	defer func() { options.MaxRetries = maxRetries }()
+
+	configmap := testutil.GetConfigmap("dead-letter", "app-config", "v2")
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	deadLetters := deadletter.NewStore(fake.NewSimpleClientset(), "", "", false)
+	queue := newFairQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[any](time.Millisecond, time.Millisecond))
+	c := &Controller{
+		resource:    "configMaps",
+		store:       store,
+		queue:       queue,
+		collectors:  metrics.NewCollectors(),
+		deadLetters: deadLetters,
+	}
+	deadLetters.RegisterRedriver("configMaps", c.redrive)
+
+	change := handler.ResourceUpdatedHandler{Resource: configmap, OldResource: testutil.GetConfigmap("dead-letter", "app-config", "v1")}
+	reloadErr := &handler.ReloadError{Targets: []string{"Deployment/app", "StatefulSet/db"}, Err: errors.New("conflict")}
+
+	// The change is retried first, then dropped
+	c.handleErr(reloadErr, change)
+	if len(deadLetters.List()) != 0 {
+		t.Errorf("Change was recorded before its retries")
+	}
+	retried := getWithin(queue, time.Second)
+	if retried == nil {
+		t.Fatalf("Change was not retried")
+	}
+	c.handleErr(reloadErr, retried)
+	queue.Done(retried)
+
+	entries := deadLetters.List()
+	if len(entries) != 1 {
+		t.Fatalf("Dead-letter entries = %d, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.Key() != "configMaps/dead-letter/app-config" || entry.Event != "update" || entry.LastError != "conflict" || entry.Retries != 1 {
+		t.Errorf("Dead-letter entry = %+v", entry)
+	}
+	if len(entry.Targets) != 2 || entry.Targets[0] != "Deployment/app" || entry.Targets[1] != "StatefulSet/db" {
+		t.Errorf("Dead-letter entry targets = %v", entry.Targets)
+	}
+	if dropped := promtestutil.ToFloat64(c.collectors.DroppedChanges.WithLabelValues("configMaps", "dead-letter")); dropped != 1 {
+		t.Errorf("Dropped changes = %v, want 1", dropped)
+	}
+
+	// A change is only re-driven while its source exists
+	if _, err := deadLetters.Redrive(""); err == nil {
+		t.Errorf("Change of a missing source was re-driven")
+	}
+	if err := store.Add(configmap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deadLetters.Redrive(entry.Key()); err != nil {
+		t.Errorf("Re-drive failed: %v", err)
+	}
+	redriven := getWithin(queue, time.Second)
+	if created, ok := redriven.(handler.ResourceCreatedHandler); !ok || created.Resource != configmap {
+		t.Fatalf("Re-driven change = %v, want the current configmap", redriven)
+	}
+	if len(deadLetters.List()) != 0 {
+		t.Errorf("Re-driven change is still recorded")
+	}
+
+	// A processed change of the source removes its entry
+	deadLetters.Add(entry)
+	c.handleErr(nil, redriven)
+	queue.Done(redriven)
+	if len(deadLetters.List()) != 0 {
+		t.Errorf("Entry of a processed source is still recorded")
+	}
+}
+
+func TestHandleErrDropsPausedChange(t *testing.T) {
+	defer func(maxPaused time.Duration) { options.MaxPausedDuration = maxPaused }(options.MaxPausedDuration)
+	options.MaxPausedDuration = 50 * time.Millisecond
+
+	deadLetters := deadletter.NewStore(fake.NewSimpleClientset(), "", "", false)
+	queue := newFairQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	c := &Controller{
+		resource:    "configMaps",
+		queue:       queue,
+		collectors:  metrics.NewCollectors(),
+		deadLetters: deadLetters,
+	}
+	change := handler.ResourceUpdatedHandler{
+		Resource:    testutil.GetConfigmap("paused", "app-config", "v2"),
+		OldResource: testutil.GetConfigmap("paused", "app-config", "v1"),
+	}
+	paused := &handler.RequeueError{After: time.Millisecond, Reason: "reloads are paused", Paused: true}
+	waiting := &handler.RequeueError{After: time.Millisecond, Reason: "waiting for a rollout slot"}
+
+	// Waiting for a rollout slot restarts the time the change is paused
+	for _, err := range []error{paused, waiting, paused} {
+		c.handleErr(err, change)
+		if requeued := getWithin(queue, time.Second); requeued != change {
+			t.Fatalf("Change was not requeued after %v", err)
+		}
+		queue.Done(change)
+		time.Sleep(30 * time.Millisecond)
+	}
+	if len(deadLetters.List()) != 0 {
+		t.Fatalf("Change was dropped before it was paused for %s", options.MaxPausedDuration)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	c.handleErr(paused, change)
+	if queue.Len() != 0 {
+		t.Errorf("Dropped change was requeued")
+	}
+	if entries := deadLetters.List(); len(entries) != 1 || entries[0].LastError != paused.Error() {
+		t.Errorf("Dead-letter entries = %+v, want the paused change", entries)
+	}
+}
+
+func TestQueueReconciliation(t *testing.T) {
+	store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+	watched := testutil.GetConfigmap("catch-up", "app-config", "v1")
+	ignored := testutil.GetConfigmap("ignored", "app-config", "v1")
+	for _, configmap := range []*v1.ConfigMap{watched, ignored} {
+		if err := store.Add(configmap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	queue := newFairQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	c := &Controller{
+		resource:          "configMaps",
+		store:             store,
+		queue:             queue,
+		ignoredNamespaces: []string{"ignored"},
+	}
+
+	for _, drift := range []bool{false, true} {
+		c.queueReconciliation(drift)
+		if queue.Len() != 1 {
+			t.Fatalf("Queue length = %d, want 1", queue.Len())
+		}
+		item := getWithin(queue, time.Second)
+		if reconcile, ok := item.(handler.ResourceReconcileHandler); !ok || reconcile.Resource != watched || reconcile.Drift != drift {
+			t.Errorf("Queued change = %v, want the check of the watched configmap with drift %t", item, drift)
+		}
+		queue.Done(item)
+	}
+}
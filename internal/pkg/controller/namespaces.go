@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// NamespaceCache tracks the namespaces matching the namespace selector through an informer on all namespaces, so
+// namespaces whose labels start or stop matching the selector are selected or left out right away
+type NamespaceCache struct {
+	selector labels.Selector
+	informer cache.SharedIndexInformer
+	lister   corev1listers.NamespaceLister
+}
+
+// NewNamespaceCache creates the cache of the namespaces matching the label selector
+func NewNamespaceCache(client kubernetes.Interface, namespaceLabelSelector string) (*NamespaceCache, error) {
+	selector, err := labels.Parse(namespaceLabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	informer := cache.NewSharedIndexInformer(&cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.CoreV1().Namespaces().List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.CoreV1().Namespaces().Watch(context.TODO(), options)
+		},
+	}, &v1.Namespace{}, 0, cache.Indexers{})
+
+	n := &NamespaceCache{
+		selector: selector,
+		informer: informer,
+		lister:   corev1listers.NewNamespaceLister(informer.GetIndexer()),
+	}
+	_, err = informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { n.logSelection(nil, obj) },
+		UpdateFunc: n.logSelection,
+		DeleteFunc: func(obj interface{}) { n.logSelection(obj, nil) },
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Run starts the informer and blocks until its cache is synced or stopCh is closed
+func (n *NamespaceCache) Run(stopCh <-chan struct{}) bool {
+	logrus.Infof("Starting namespace informer for namespace selector: %s", n.selector)
+	go n.informer.Run(stopCh)
+	return cache.WaitForCacheSync(stopCh, n.informer.HasSynced)
+}
+
+// HasSynced returns whether the cache is filled
+func (n *NamespaceCache) HasSynced() bool {
+	return n.informer.HasSynced()
+}
+
+// Lister returns the lister of all namespaces in the cache, whether they match the namespace selector or not
+func (n *NamespaceCache) Lister() corev1listers.NamespaceLister {
+	return n.lister
+}
+
+// Contains returns whether the namespace exists and matches the namespace selector
+func (n *NamespaceCache) Contains(name string) bool {
+	namespace, err := n.lister.Get(name)
+	if err != nil {
+		return false
+	}
+	return n.selector.Matches(labels.Set(namespace.Labels))
+}
+
+// logSelection logs namespaces entering or leaving the namespace selector
+func (n *NamespaceCache) logSelection(old interface{}, new interface{}) {
+	wasSelected, name := n.matches(old)
+	isSelected, newName := n.matches(new)
+	if newName != "" {
+		name = newName
+	}
+
+	if isSelected && !wasSelected {
+		logrus.Infof("added namespace to be watched: %s", name)
+	} else if wasSelected && !isSelected {
+		logrus.Infof("removed namespace from watch: %s", name)
+	}
+}
+
+func (n *NamespaceCache) matches(obj interface{}) (bool, string) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	namespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		return false, ""
+	}
+	return n.selector.Matches(labels.Set(namespace.Labels)), namespace.Name
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNamespaceCacheFollowsLabelChanges(t *testing.T) {
+	fakeClient := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "labeled-namespace"}})
+	namespaces, err := NewNamespaceCache(fakeClient, "reloader=enabled")
+	if err != nil {
+		t.Fatalf("Error while creating the namespace cache %v", err)
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if !namespaces.Run(stop) {
+		t.Fatalf("Namespace cache did not sync")
+	}
+
+	if namespaces.Contains("labeled-namespace") {
+		t.Errorf("Namespace without the label was selected")
+	}
+	if namespaces.Contains("missing-namespace") {
+		t.Errorf("Missing namespace was selected")
+	}
+
+	setLabels := func(labels map[string]string, selected bool) {
+		namespace, err := fakeClient.CoreV1().Namespaces().Get(context.TODO(), "labeled-namespace", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Error while getting the namespace %v", err)
+		}
+		namespace.Labels = labels
+		if _, err := fakeClient.CoreV1().Namespaces().Update(context.TODO(), namespace, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("Error while updating the namespace %v", err)
+		}
+
+		err = wait.PollUntilContextTimeout(context.TODO(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+			return namespaces.Contains("labeled-namespace") == selected, nil
+		})
+		if err != nil {
+			t.Errorf("Namespace with labels %v was not selected = %v", labels, selected)
+		}
+	}
+
+	// Namespaces entering and leaving the selector are picked up right away
+	setLabels(map[string]string{"reloader": "enabled"}, true)
+	setLabels(map[string]string{"reloader": "disabled"}, false)
+	setLabels(map[string]string{"reloader": "enabled", "team": "a"}, true)
+	setLabels(nil, false)
+}
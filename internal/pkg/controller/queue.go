@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/handler"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fairQueue hands out the changes of different namespaces in turns, so a namespace with many changes does not hold
+// up the changes of the others when several workers process the queue. The changes of a source are handed out one at
+// a time in the order they were added, a retried change is handed out again before the later changes of its source
+type fairQueue struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	rateLimiter workqueue.TypedRateLimiter[any]
+	// pending are the changes waiting to be processed by namespace
+	pending map[string][]any
+	// namespaces are the namespaces with pending changes in the order they are served
+	namespaces []string
+	// processing and retrying are the sources with a change handed out to a worker or waiting to be retried
+	processing   map[string]bool
+	retrying     map[string]bool
+	shuttingDown bool
+}
+
+var _ workqueue.TypedRateLimitingInterface[any] = &fairQueue{}
+
+func newFairQueue(rateLimiter workqueue.TypedRateLimiter[any]) *fairQueue {
+	q := &fairQueue{
+		rateLimiter: rateLimiter,
+		pending:     make(map[string][]any),
+		processing:  make(map[string]bool),
+		retrying:    make(map[string]bool),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// getQueueKeys returns the namespace and the source of a change
+func getQueueKeys(item any) (string, string) {
+	var resource interface{}
+	switch change := item.(type) {
+	case handler.ResourceCreatedHandler:
+		resource = change.Resource
+	case handler.ResourceUpdatedHandler:
+		resource = change.Resource
+	case handler.ResourceDeleteHandler:
+		resource = change.Resource
+	case handler.ResourceReconcileHandler:
+		resource = change.Resource
+	default:
+		resource = item
+	}
+
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(resource)
+	if err != nil {
+		return "", ""
+	}
+	namespace, _, _ := cache.SplitMetaNamespaceKey(key)
+	return namespace, key
+}
+
+// Add queues the change behind the pending changes of its namespace. A check of the workloads of a source replaces
+// its pending check, so drift scans do not pile up while the queue is busy
+func (q *fairQueue) Add(item any) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.shuttingDown {
+		return
+	}
+
+	namespace, source := getQueueKeys(item)
+	if reconcile, ok := item.(handler.ResourceReconcileHandler); ok {
+		for i, pending := range q.pending[namespace] {
+			queued, ok := pending.(handler.ResourceReconcileHandler)
+			if _, queuedSource := getQueueKeys(pending); !ok || queuedSource != source {
+				continue
+			}
+			// Catching up reloads the stale workloads, it is not turned into a scan which only reports them
+			reconcile.Drift = reconcile.Drift && queued.Drift
+			q.pending[namespace][i] = reconcile
+			return
+		}
+	}
+	q.enqueue(namespace, append(q.pending[namespace], item))
+}
+
+func (q *fairQueue) enqueue(namespace string, pending []any) {
+	q.pending[namespace] = pending
+	if !slices.Contains(q.namespaces, namespace) {
+		q.namespaces = append(q.namespaces, namespace)
+	}
+	q.cond.Broadcast()
+}
+
+// Len returns the number of pending changes
+func (q *fairQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	length := 0
+	for _, pending := range q.pending {
+		length += len(pending)
+	}
+	return length
+}
+
+// Get blocks until a change can be processed. It returns the first change of the next namespace in turn whose
+// source is not processed or retried already
+func (q *fairQueue) Get() (any, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.shuttingDown {
+			return nil, true
+		}
+		if item, found := q.next(); found {
+			return item, false
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) next() (any, bool) {
+	for i, namespace := range q.namespaces {
+		pending := q.pending[namespace]
+		for j, item := range pending {
+			_, source := getQueueKeys(item)
+			if q.processing[source] || q.retrying[source] {
+				continue
+			}
+
+			q.processing[source] = true
+			pending = slices.Delete(pending, j, j+1)
+			// The namespace takes its next turn after all others
+			q.namespaces = slices.Delete(q.namespaces, i, i+1)
+			if len(pending) == 0 {
+				delete(q.pending, namespace)
+			} else {
+				q.pending[namespace] = pending
+				q.namespaces = append(q.namespaces, namespace)
+			}
+			return item, true
+		}
+	}
+	return nil, false
+}
+
+// Done marks the change as processed, the next change of its source can be handed out unless it is retried
+func (q *fairQueue) Done(item any) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, source := getQueueKeys(item)
+	delete(q.processing, source)
+	q.cond.Broadcast()
+}
+
+// ShutDown stops handing out changes
+func (q *fairQueue) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// ShutDownWithDrain stops handing out changes and waits until the changes handed out are processed
+func (q *fairQueue) ShutDownWithDrain() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+	for len(q.processing) > 0 {
+		q.cond.Wait()
+	}
+}
+
+// ShuttingDown returns whether the queue stopped handing out changes
+func (q *fairQueue) ShuttingDown() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.shuttingDown
+}
+
+// AddAfter queues the change again once the duration passed, the later changes of its source wait for it
+func (q *fairQueue) AddAfter(item any, duration time.Duration) {
+	q.retryAfter(item, duration)
+}
+
+// AddRateLimited retries the change once the rate limiter allows it, the later changes of its source wait for it
+func (q *fairQueue) AddRateLimited(item any) {
+	q.retryAfter(item, q.rateLimiter.When(item))
+}
+
+func (q *fairQueue) retryAfter(item any, duration time.Duration) {
+	namespace, source := getQueueKeys(item)
+	q.mu.Lock()
+	q.retrying[source] = true
+	q.mu.Unlock()
+
+	time.AfterFunc(duration, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.retrying, source)
+		if q.shuttingDown {
+			return
+		}
+		q.enqueue(namespace, append([]any{item}, q.pending[namespace]...))
+	})
+}
+
+// Forget stops tracking the retries of the change
+func (q *fairQueue) Forget(item any) {
+	q.rateLimiter.Forget(item)
+}
+
+// NumRequeues returns how often the change was retried
+func (q *fairQueue) NumRequeues(item any) int {
+	return q.rateLimiter.NumRequeues(item)
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/handler"
+	"github.com/stakater/Reloader/internal/pkg/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func getChange(namespace string, name string, data string) handler.ResourceUpdatedHandler {
+	return handler.ResourceUpdatedHandler{
+		Resource:    testutil.GetConfigmap(namespace, name, data),
+		OldResource: testutil.GetConfigmap(namespace, name, ""),
+	}
+}
+
+// getWithin returns the next change of the queue or nil if none is handed out within the timeout
+func getWithin(q *fairQueue, timeout time.Duration) any {
+	items := make(chan any, 1)
+	go func() {
+		item, _ := q.Get()
+		items <- item
+	}()
+	select {
+	case item := <-items:
+		return item
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestFairQueueServesNamespacesInTurns(t *testing.T) {
+	q := newFairQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	busyFirst := getChange("busy", "first", "v1")
+	busyFirstAgain := getChange("busy", "first", "v2")
+	busySecond := getChange("busy", "second", "v1")
+	quiet := getChange("quiet", "config", "v1")
+	q.Add(busyFirst)
+	q.Add(busyFirstAgain)
+	q.Add(busySecond)
+	q.Add(quiet)
+
+	if q.Len() != 4 {
+		t.Errorf("Queue length = %d, want 4", q.Len())
+	}
+
+	// The namespaces take turns, a source is only handed out once at a time
+	for i, want := range []any{busyFirst, quiet, busySecond} {
+		if got := getWithin(q, time.Second); got != want {
+			t.Errorf("Change %d = %v, want %v", i, got, want)
+		}
+	}
+
+	// The later change of a source waits until the earlier one is done
+	got := make(chan any, 1)
+	go func() {
+		item, _ := q.Get()
+		got <- item
+	}()
+	select {
+	case item := <-got:
+		t.Fatalf("Change %v was handed out while the change of its source was processed", item)
+	case <-time.After(100 * time.Millisecond):
+	}
+	q.Done(busyFirst)
+	select {
+	case item := <-got:
+		if item != busyFirstAgain {
+			t.Errorf("Change = %v, want %v", item, busyFirstAgain)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Change was not handed out after the change of its source was done")
+	}
+
+	q.ShutDown()
+	if _, shutdown := q.Get(); !shutdown {
+		t.Errorf("Queue did not shut down")
+	}
+}
+
+func TestFairQueueRetriesBeforeLaterChanges(t *testing.T) {
+	q := newFairQueue(workqueue.NewTypedItemExponentialFailureRateLimiter[any](50*time.Millisecond, 50*time.Millisecond))
+	first := getChange("retry", "config", "v1")
+	second := getChange("retry", "config", "v2")
+	q.Add(first)
+	q.Add(second)
+
+	if got := getWithin(q, time.Second); got != first {
+		t.Fatalf("Change = %v, want %v", got, first)
+	}
+	q.AddRateLimited(first)
+	q.Done(first)
+
+	// The later change of the source waits for the retry
+	if got := getWithin(q, time.Second); got != first {
+		t.Errorf("Change = %v, want the retried change %v", got, first)
+	}
+	if q.NumRequeues(first) != 1 {
+		t.Errorf("Requeues = %d, want 1", q.NumRequeues(first))
+	}
+	q.Forget(first)
+	q.Done(first)
+
+	if got := getWithin(q, time.Second); got != second {
+		t.Errorf("Change = %v, want %v", got, second)
+	}
+}
+
+func TestFairQueueAddAfterBeforeLaterChanges(t *testing.T) {
+	q := newFairQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	first := getChange("requeue", "config", "v1")
+	second := getChange("requeue", "config", "v2")
+	q.Add(first)
+	q.Add(second)
+
+	if got := getWithin(q, time.Second); got != first {
+		t.Fatalf("Change = %v, want %v", got, first)
+	}
+	q.AddAfter(first, 50*time.Millisecond)
+	q.Done(first)
+
+	// The later change of the source waits for the requeued change, which does not count as a retry
+	if got := getWithin(q, time.Second); got != first {
+		t.Errorf("Change = %v, want the requeued change %v", got, first)
+	}
+	if q.NumRequeues(first) != 0 {
+		t.Errorf("Requeues = %d, want 0", q.NumRequeues(first))
+	}
+	q.Done(first)
+
+	if got := getWithin(q, time.Second); got != second {
+		t.Errorf("Change = %v, want %v", got, second)
+	}
+}
+
+func TestFairQueueReplacesPendingReconcile(t *testing.T) {
+	q := newFairQueue(workqueue.DefaultTypedControllerRateLimiter[any]())
+	catchUp := handler.ResourceReconcileHandler{Resource: testutil.GetConfigmap("reconcile", "config", "v1")}
+	change := getChange("reconcile", "config", "v2")
+	scan := handler.ResourceReconcileHandler{Resource: testutil.GetConfigmap("reconcile", "config", "v2"), Drift: true}
+	other := handler.ResourceReconcileHandler{Resource: testutil.GetConfigmap("reconcile", "other", "v1"), Drift: true}
+	q.Add(catchUp)
+	q.Add(change)
+	q.Add(scan)
+	q.Add(other)
+	q.Add(other)
+
+	if q.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", q.Len())
+	}
+	// The pending check keeps its turn with the latest content and still catches up
+	want := handler.ResourceReconcileHandler{Resource: scan.Resource}
+	if got := getWithin(q, time.Second); got != want {
+		t.Errorf("Change = %v, want %v", got, want)
+	}
+	if got := getWithin(q, time.Second); got != other {
+		t.Errorf("Change = %v, want %v", got, other)
+	}
+	q.Done(want)
+	if got := getWithin(q, time.Second); got != change {
+		t.Errorf("Change = %v, want %v", got, change)
+	}
+}
@@ -0,0 +1,315 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// EndpointPath is the path of the endpoint listing, re-driving and discarding the dropped changes
+	EndpointPath = "/dead-letters"
+	// entriesKey is the key of the configmap holding the dropped changes
+	entriesKey = "entries"
+)
+
+var errNotFound = errors.New("no dropped change with this key")
+
+// Entry is a change of a configmap or secret Reloader gave up processing after its retries
+type Entry struct {
+	// SourceType is the resource type of the changed source, configMaps or secrets
+	SourceType string `json:"sourceType"`
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	// Event is the kind of change, create, update or delete
+	Event string `json:"event"`
+	// Targets are the workloads which were not reloaded in the format <kind>/<name>
+	Targets   []string  `json:"targets,omitempty"`
+	LastError string    `json:"lastError"`
+	Retries   int       `json:"retries"`
+	DroppedAt time.Time `json:"droppedAt"`
+}
+
+// Key returns the key of the entry, a source has at most one entry
+func (e Entry) Key() string {
+	return Key(e.SourceType, e.Namespace, e.Name)
+}
+
+// Key returns the key of the entry of a source
+func Key(sourceType string, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", sourceType, namespace, name)
+}
+
+// Redriver queues the change of an entry again
+type Redriver func(entry Entry) error
+
+// Store keeps the dropped changes, they are persisted in a configmap if its name is set
+type Store struct {
+	mu        sync.Mutex
+	client    kubernetes.Interface
+	namespace string
+	configmap string
+	dryRun    bool
+	entries   map[string]Entry
+	redrivers map[string]Redriver
+}
+
+// NewStore creates the store of the dropped changes persisted in the configmap in the namespace, they are only kept
+// in memory if the configmap or the namespace is empty or in dry run mode
+func NewStore(client kubernetes.Interface, namespace string, configmap string, dryRun bool) *Store {
+	return &Store{
+		client:    client,
+		namespace: namespace,
+		configmap: configmap,
+		dryRun:    dryRun,
+		entries:   map[string]Entry{},
+		redrivers: map[string]Redriver{},
+	}
+}
+
+// RegisterRedriver sets the redriver of the entries of the source type
+func (s *Store) RegisterRedriver(sourceType string, redriver Redriver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redrivers[sourceType] = redriver
+}
+
+// Add records the dropped change, it replaces an earlier entry of the same source
+func (s *Store) Add(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[entry.Key()] = entry
+	s.persist()
+}
+
+// Remove deletes the entry of a source, e.g. once a later change of the source was processed
+func (s *Store) Remove(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, found := s.entries[key]; !found {
+		return
+	}
+	delete(s.entries, key)
+	s.persist()
+}
+
+// List returns the entries from the oldest to the latest dropped change
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DroppedAt.Equal(entries[j].DroppedAt) {
+			return entries[i].Key() < entries[j].Key()
+		}
+		return entries[i].DroppedAt.Before(entries[j].DroppedAt)
+	})
+	return entries
+}
+
+// Redrive queues the change of the entry with the key again, or of all entries if the key is empty. Re-driven entries
+// are removed and recorded again if the change is dropped again
+func (s *Store) Redrive(key string) ([]Entry, error) {
+	entries, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var redriven []Entry
+	var errs []error
+	for _, entry := range entries {
+		s.mu.Lock()
+		redriver, found := s.redrivers[entry.SourceType]
+		s.mu.Unlock()
+		if !found {
+			errs = append(errs, fmt.Errorf("changes of %s are not processed", entry.SourceType))
+			continue
+		}
+		// The entry is removed first as the change can be dropped again before the redriver returns
+		s.Remove(entry.Key())
+		if err := redriver(entry); err != nil {
+			s.Add(entry)
+			errs = append(errs, fmt.Errorf("failed to re-drive %s: %w", entry.Key(), err))
+			continue
+		}
+		logrus.Infof("Re-driving the dropped %s of %s '%s' in namespace '%s'", entry.Event, entry.SourceType, entry.Name, entry.Namespace)
+		redriven = append(redriven, entry)
+	}
+	return redriven, errors.Join(errs...)
+}
+
+// Discard removes the entry with the key, or all entries if the key is empty
+func (s *Store) Discard(key string) ([]Entry, error) {
+	entries, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		s.Remove(entry.Key())
+	}
+	return entries, nil
+}
+
+// get returns the entry with the key, or all entries if the key is empty
+func (s *Store) get(key string) ([]Entry, error) {
+	if key == "" {
+		return s.List(), nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, found := s.entries[key]
+	if !found {
+		return nil, errNotFound
+	}
+	return []Entry{entry}, nil
+}
+
+// SetupEndpoint serves the entries read-only at EndpointPath of the default mux, which is served on the public
+// metrics port
+func (s *Store) SetupEndpoint() {
+	http.Handle(EndpointPath, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed, dropped changes are re-driven and discarded on the admin address", http.StatusMethodNotAllowed)
+			return
+		}
+		s.ServeHTTP(w, r)
+	}))
+}
+
+// ServeAdmin serves the store at EndpointPath on a separate listener at the address, which allows re-driving and
+// discarding the entries. It blocks until the listener fails
+func (s *Store) ServeAdmin(address string) error {
+	mux := http.NewServeMux()
+	mux.Handle(EndpointPath, s)
+	logrus.Infof("Serving the re-drive and discard of dropped changes on %s", address)
+	return http.ListenAndServe(address, mux)
+}
+
+// ServeHTTP lists the entries on GET, re-drives them on POST and discards them on DELETE. The key query parameter
+// selects a single entry
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	var entries []Entry
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		entries, err = s.get(key)
+	case http.MethodPost:
+		entries, err = s.Redrive(key)
+	case http.MethodDelete:
+		entries, err = s.Discard(key)
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if errors.Is(err, errNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if entries == nil {
+		entries = []Entry{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		logrus.Errorf("Failed to write dropped changes: %v", err)
+	}
+}
+
+// Load restores the persisted entries, entries recorded since are kept
+func (s *Store) Load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configmap == "" || s.namespace == "" {
+		return
+	}
+
+	configmap, err := s.client.CoreV1().ConfigMaps(s.namespace).Get(context.TODO(), s.configmap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to load dropped changes from configmap '%s' in namespace '%s': %v", s.configmap, s.namespace, err)
+		return
+	}
+
+	content := configmap.Data[entriesKey]
+	if content == "" {
+		return
+	}
+	var entries []Entry
+	err = json.Unmarshal([]byte(content), &entries)
+	if err != nil {
+		logrus.Errorf("Failed to parse dropped changes from configmap '%s' in namespace '%s': %v", s.configmap, s.namespace, err)
+		return
+	}
+	for _, entry := range entries {
+		if _, found := s.entries[entry.Key()]; !found {
+			s.entries[entry.Key()] = entry
+		}
+	}
+	logrus.Infof("Loaded %d dropped changes", len(entries))
+}
+
+// persist stores the entries in the configmap, the caller holds the lock
+func (s *Store) persist() {
+	if s.configmap == "" || s.namespace == "" || s.dryRun {
+		return
+	}
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		logrus.Errorf("Failed to persist dropped changes: %v", err)
+		return
+	}
+
+	configmaps := s.client.CoreV1().ConfigMaps(s.namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := configmaps.Get(context.TODO(), s.configmap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configmap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: s.configmap, Namespace: s.namespace},
+				Data:       map[string]string{entriesKey: string(content)},
+			}
+			_, err = configmaps.Create(context.TODO(), configmap, metav1.CreateOptions{FieldManager: "Reloader"})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		configmap.Data = map[string]string{entriesKey: string(content)}
+		_, err = configmaps.Update(context.TODO(), configmap, metav1.UpdateOptions{FieldManager: "Reloader"})
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to persist dropped changes in configmap '%s' in namespace '%s': %v", s.configmap, s.namespace, err)
+	}
+}
@@ -0,0 +1,135 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testEntry(name string, droppedAt time.Time) Entry {
+	return Entry{
+		SourceType: "configMaps",
+		Namespace:  "dead-letter",
+		Name:       name,
+		Event:      "update",
+		Targets:    []string{"Deployment/app"},
+		LastError:  "conflict",
+		Retries:    5,
+		DroppedAt:  droppedAt,
+	}
+}
+
+func TestStorePersistsEntries(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	now := time.Now().UTC().Truncate(time.Second)
+	store := NewStore(client, "reloader", "reloader-dead-letters", false)
+	store.Add(testEntry("second", now))
+	store.Add(testEntry("first", now.Add(-time.Minute)))
+	store.Add(testEntry("removed", now))
+	store.Remove("configMaps/dead-letter/removed")
+
+	loaded := NewStore(client, "reloader", "reloader-dead-letters", false)
+	loaded.Load()
+	assert.Equal(t, []Entry{testEntry("first", now.Add(-time.Minute)), testEntry("second", now)}, loaded.List())
+
+	// Nothing is persisted in dry run mode
+	dryRun := NewStore(client, "reloader", "reloader-dry-run", true)
+	dryRun.Add(testEntry("first", now))
+	loaded = NewStore(client, "reloader", "reloader-dry-run", false)
+	loaded.Load()
+	assert.Empty(t, loaded.List())
+}
+
+func TestStoreRedrive(t *testing.T) {
+	store := NewStore(fake.NewSimpleClientset(), "", "", false)
+	store.Add(testEntry("ok", time.Now()))
+	store.Add(testEntry("failing", time.Now()))
+
+	var redriven []string
+	store.RegisterRedriver("configMaps", func(entry Entry) error {
+		if entry.Name == "failing" {
+			return errors.New("no longer exists")
+		}
+		redriven = append(redriven, entry.Name)
+		return nil
+	})
+
+	_, err := store.Redrive("configMaps/dead-letter/unknown")
+	assert.ErrorIs(t, err, errNotFound)
+
+	entries, err := store.Redrive("")
+	assert.Error(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, []string{"ok"}, redriven)
+	// The entry which could not be re-driven is kept
+	assert.Len(t, store.List(), 1)
+	assert.Equal(t, "failing", store.List()[0].Name)
+}
+
+func TestStoreEndpoint(t *testing.T) {
+	store := NewStore(fake.NewSimpleClientset(), "", "", false)
+	store.Add(testEntry("app-config", time.Now()))
+	store.Add(testEntry("other-config", time.Now()))
+	store.RegisterRedriver("configMaps", func(entry Entry) error { return nil })
+
+	request := func(method string, target string) (*httptest.ResponseRecorder, []Entry) {
+		recorder := httptest.NewRecorder()
+		store.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+		var entries []Entry
+		if recorder.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &entries))
+		}
+		return recorder, entries
+	}
+
+	recorder, entries := request(http.MethodGet, EndpointPath)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, entries, 2)
+
+	recorder, entries = request(http.MethodPost, EndpointPath+"?key=configMaps/dead-letter/app-config")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "app-config", entries[0].Name)
+
+	recorder, _ = request(http.MethodPost, EndpointPath+"?key=configMaps/dead-letter/app-config")
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder, entries = request(http.MethodDelete, EndpointPath)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Len(t, entries, 1)
+	assert.Empty(t, store.List())
+
+	recorder, _ = request(http.MethodPut, EndpointPath)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestStoreReadOnlyEndpoint(t *testing.T) {
+	store := NewStore(fake.NewSimpleClientset(), "", "", false)
+	store.Add(testEntry("app-config", time.Now()))
+	redriven := 0
+	store.RegisterRedriver("configMaps", func(entry Entry) error {
+		redriven++
+		return nil
+	})
+	store.SetupEndpoint()
+
+	recorder := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, EndpointPath, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// Dropped changes are only re-driven and discarded on the admin address
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		recorder = httptest.NewRecorder()
+		http.DefaultServeMux.ServeHTTP(recorder, httptest.NewRequest(method, EndpointPath, nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		assert.Equal(t, "GET", recorder.Header().Get("Allow"))
+	}
+	assert.Zero(t, redriven)
+	assert.Len(t, store.List(), 1)
+}
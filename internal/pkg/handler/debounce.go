@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	alert "github.com/stakater/Reloader/internal/pkg/alerts"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/policy"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// reloadDebouncer aggregates the changes for a workload during its debounce window
+var reloadDebouncer = newDebouncer()
+
+// pendingChange is a configmap or secret change waiting to be applied to a workload
+type pendingChange struct {
+	config   util.Config
+	strategy invokeStrategy
+}
+
+// pendingReload holds the changes aggregated for a workload until its debounce window expires
+type pendingReload struct {
+	clients      kube.Clients
+	upgradeFuncs callbacks.RollingUpgradeFuncs
+	collectors   metrics.Collectors
+	recorder     record.EventRecorder
+	namespace    string
+	name         string
+	changes      []pendingChange
+	// retries is the number of times the reload failed
+	retries int
+}
+
+type debouncer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingReload
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{pending: make(map[string]*pendingReload)}
+}
+
+// getDebounceWindow returns the debounce window of the workload, the annotation takes precedence over a ReloadPolicy
+// selecting the workload for the change and the global flag
+func getDebounceWindow(upgradeFuncs callbacks.RollingUpgradeFuncs, config util.Config, item runtime.Object) time.Duration {
+	defaultWindow := options.DebounceWindow
+	if accessor, err := meta.Accessor(item); err == nil {
+		if reloadPolicy, _ := policy.Match(upgradeFuncs.ResourceType, accessor.GetLabels(), config); reloadPolicy != nil && reloadPolicy.Spec.DebounceWindow != nil {
+			defaultWindow = reloadPolicy.Spec.DebounceWindow.Duration
+		}
+	}
+
+	value, found := upgradeFuncs.AnnotationsFunc(item)[options.DebounceWindowAnnotation]
+	if !found || value == "" {
+		return defaultWindow
+	}
+
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		logrus.Warnf("Invalid value '%s' for annotation '%s', using the default debounce window", value, options.DebounceWindowAnnotation)
+		return defaultWindow
+	}
+	return window
+}
+
+// debounceReload queues the change for the workload if the strategy would reload it, the reload is performed
+// once the debounce window started by the first change expires
+func debounceReload(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy, item runtime.Object, window time.Duration) error {
+	// Evaluate on a copy, the changes are applied to a freshly fetched workload once the window expires
+	if invokeStrategyForResource(upgradeFuncs, config, strategy, item.DeepCopyObject()).Result != constants.Updated {
+		return nil
+	}
+
+	accessor, err := meta.Accessor(item)
+	if err != nil {
+		return err
+	}
+
+	reloadDebouncer.add(window, &pendingReload{
+		clients:      clients,
+		upgradeFuncs: upgradeFuncs,
+		collectors:   collectors,
+		recorder:     recorder,
+		namespace:    config.Namespace,
+		name:         accessor.GetName(),
+	}, pendingChange{config: config, strategy: strategy})
+	return nil
+}
+
+func (d *debouncer) add(window time.Duration, reload *pendingReload, change pendingChange) {
+	key := reload.key()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, found := d.pending[key]
+	if !found {
+		logrus.Infof("Debouncing reload of '%s' of type '%s' in namespace '%s' for %s", reload.name, reload.upgradeFuncs.ResourceType, reload.namespace, window)
+		pending = reload
+		d.pending[key] = pending
+		time.AfterFunc(window, func() { d.flush(key) })
+	}
+
+	// Only the latest change of a configmap or secret has to be applied
+	for i := range pending.changes {
+		if pending.changes[i].config.Type == change.config.Type && pending.changes[i].config.ResourceName == change.config.ResourceName {
+			change.config.ChangedKeys = mergeChangedKeys(pending.changes[i].config.ChangedKeys, change.config.ChangedKeys)
+			pending.changes[i] = change
+			return
+		}
+	}
+	pending.changes = append(pending.changes, change)
+}
+
+// hasPending returns whether a change of the configmap or secret waits to be applied to the workload with the key
+func (d *debouncer) hasPending(key string, config util.Config) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, found := d.pending[key]
+	return found && slices.ContainsFunc(pending.changes, func(change pendingChange) bool {
+		return change.config.Type == config.Type && change.config.ResourceName == config.ResourceName
+	})
+}
+
+// mergeChangedKeys combines the keys changed by successive changes, the keys are unknown if they are unknown for either
+func mergeChangedKeys(previous []string, current []string) []string {
+	if previous == nil || current == nil {
+		return nil
+	}
+	merged := slices.Concat(previous, current)
+	slices.Sort(merged)
+	return slices.Compact(merged)
+}
+
+func (d *debouncer) flush(key string) {
+	d.mu.Lock()
+	pending, found := d.pending[key]
+	delete(d.pending, key)
+	d.mu.Unlock()
+
+	if !found {
+		return
+	}
+
+	err := pending.reload()
+	var requeue *RequeueError
+	if errors.As(err, &requeue) {
+		// Waiting for a rollout slot is not a failed attempt
+		d.schedule(pending, requeue.After)
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Debounced reload of '%s' of type '%s' in namespace '%s' failed with error = %v", pending.name, pending.upgradeFuncs.ResourceType, pending.namespace, err)
+		d.retry(key, pending, err)
+	}
+}
+
+// retry schedules the failed reload again with the retry policy of the controller, changes added since are applied
+// with it. The reload is dropped and a failure alert sent after all retries
+func (d *debouncer) retry(key string, failed *pendingReload, err error) {
+	if failed.retries >= options.MaxRetries {
+		configs := make([]util.Config, len(failed.changes))
+		for i, change := range failed.changes {
+			configs[i] = change.config
+		}
+		logrus.Errorf("Dropping debounced reload of '%s' of type '%s' in namespace '%s' after %d retries", failed.name, failed.upgradeFuncs.ResourceType, failed.namespace, failed.retries)
+		alert.SendFailureAlert(fmt.Sprintf(
+			"Reloader gave up reloading *%s* of type *%s* in namespace *%s* for the changes in %s after %d retries: %v",
+			failed.name, failed.upgradeFuncs.ResourceType, failed.namespace, describeAlertSources(configs), failed.retries, err))
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if pending, found := d.pending[key]; found {
+		// The reload of the changes added since is scheduled already, the failed changes are applied with it
+		pending.addPrevious(failed.changes)
+		return
+	}
+
+	failed.retries++
+	d.pending[key] = failed
+	time.AfterFunc(getRetryDelay(failed.retries), func() { d.flush(key) })
+}
+
+// schedule performs the reload after the delay, it is applied together with a reload of the workload which is
+// scheduled already
+func (d *debouncer) schedule(reload *pendingReload, after time.Duration) {
+	key := reload.key()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if pending, found := d.pending[key]; found {
+		pending.addPrevious(reload.changes)
+		return
+	}
+	d.pending[key] = reload
+	time.AfterFunc(after, func() { d.flush(key) })
+}
+
+// addPrevious adds changes detected before the pending ones, the keys changed by a configmap or secret are merged if
+// a later change of it is pending already
+func (p *pendingReload) addPrevious(changes []pendingChange) {
+	for _, change := range changes {
+		i := slices.IndexFunc(p.changes, func(other pendingChange) bool {
+			return other.config.Type == change.config.Type && other.config.ResourceName == change.config.ResourceName
+		})
+		if i == -1 {
+			p.changes = append(p.changes, change)
+			continue
+		}
+		p.changes[i].config.ChangedKeys = mergeChangedKeys(change.config.ChangedKeys, p.changes[i].config.ChangedKeys)
+	}
+}
+
+// getRetryDelay returns the delay before the retry, it doubles with every retry like the retries of the controller
+func getRetryDelay(retries int) time.Duration {
+	delay := options.RetryBaseDelay
+	for i := 1; i < retries && delay < options.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, options.RetryMaxDelay)
+}
+
+func (p *pendingReload) key() string {
+	return fmt.Sprintf("%s/%s/%s", p.upgradeFuncs.ResourceType, p.namespace, p.name)
+}
+
+// reload applies all pending changes to the workload with a single update
+func (p *pendingReload) reload() error {
+	return retryOnConflict(retry.DefaultRetry, func(_ bool) error {
+		resource, err := p.upgradeFuncs.ItemFunc(p.clients, p.name, p.namespace)
+		if err != nil {
+			return err
+		}
+
+		var configs []util.Config
+		for _, change := range p.changes {
+			if invokeStrategyForResource(p.upgradeFuncs, change.config, change.strategy, resource).Result == constants.Updated {
+				configs = append(configs, change.config)
+			}
+		}
+		if len(configs) == 0 {
+			return nil
+		}
+
+		return applyReload(p.clients, configs, p.upgradeFuncs, p.collectors, p.recorder, resource, p.name, nil)
+	})
+}
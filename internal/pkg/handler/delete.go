@@ -56,8 +56,11 @@ func (r ResourceDeleteHandler) GetConfig() (util.Config, string) {
 }
 
 func invokeDeleteStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
-	if options.ReloadStrategy == constants.AnnotationsReloadStrategy {
+	switch getWorkloadReloadStrategy(upgradeFuncs, item, config) {
+	case constants.AnnotationsReloadStrategy:
 		return removePodAnnotations(upgradeFuncs, item, config, autoReload)
+	case constants.RestartReloadStrategy:
+		return restartForDeletion(upgradeFuncs, item, config, autoReload)
 	}
 
 	return removeContainerEnvVars(upgradeFuncs, item, config, autoReload)
@@ -68,6 +71,13 @@ func removePodAnnotations(upgradeFuncs callbacks.RollingUpgradeFuncs, item runti
 	return updatePodAnnotations(upgradeFuncs, item, config, autoReload)
 }
 
+// restartForDeletion restarts the workload for the deleted configmap or secret, it is recorded with the hash of empty
+// data like the removed pod annotations
+func restartForDeletion(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
+	config.SHAValue = testutil.GetSHAfromEmptyData()
+	return updateRestartedAtAnnotation(upgradeFuncs, item, config, autoReload)
+}
+
 func removeContainerEnvVars(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
 	envVar := getEnvVarName(config.ResourceName, config.Type)
 	container := getContainerUsingResource(upgradeFuncs, item, config, autoReload)
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// verifyScript waits until the mounted files match the expected hashes, the mismatching files are written to the
+// termination message if they do not match in time
+const verifyScript = `end=$(($(date +%s)+TIMEOUT)); until echo "$EXPECTED" | sha256sum -c -s; do ` +
+	`if [ $(date +%s) -ge $end ]; then echo "$EXPECTED" | sha256sum -c >/dev/termination-log 2>&1; exit 1; fi; sleep 2; done`
+
+// hookHTTPClient calls the reload hooks of the pods
+var hookHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// mountedFiles are the expected sha256 hashes of the files of the volumes by volume name and path
+type mountedFiles map[string]map[string]string
+
+// getMountedFiles returns the expected hashes of the files the current content of the configmaps and secrets is
+// projected to in the volumes
+func getMountedFiles(clients kube.Clients, configs []util.Config, volumes []v1.Volume) (mountedFiles, error) {
+	files := make(mountedFiles)
+	for _, config := range configs {
+		content, err := getSourceContent(clients, config)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, volume := range volumes {
+			for _, items := range getVolumeProjections(volume, config) {
+				if files[volume.Name] == nil {
+					files[volume.Name] = make(map[string]string)
+				}
+				if len(items) == 0 {
+					for key, value := range content {
+						files[volume.Name][key] = hashFile(value)
+					}
+					continue
+				}
+				for _, item := range items {
+					if value, found := content[item.Key]; found {
+						files[volume.Name][strings.Trim(item.Path, "/")] = hashFile(value)
+					}
+				}
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("the changed configmaps and secrets are not mounted, they can not be reloaded through the reload hook")
+	}
+	return files, nil
+}
+
+// getSourceContent returns the content of the keys of the configmap or secret
+func getSourceContent(clients kube.Clients, config util.Config) (map[string][]byte, error) {
+	content := make(map[string][]byte)
+	if config.Type == constants.SecretEnvVarPostfix {
+		secret, err := clients.KubernetesClient.CoreV1().Secrets(config.Namespace).Get(context.TODO(), config.ResourceName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(content, secret.Data)
+		return content, nil
+	}
+
+	configmap, err := clients.KubernetesClient.CoreV1().ConfigMaps(config.Namespace).Get(context.TODO(), config.ResourceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range configmap.Data {
+		content[key] = []byte(value)
+	}
+	maps.Copy(content, configmap.BinaryData)
+	return content, nil
+}
+
+func hashFile(content []byte) string {
+	hash := sha256.Sum256(content)
+	return hex.EncodeToString(hash[:])
+}
+
+// verifyMountedFiles waits until the mounted files of the pod have the expected hashes, they are checked from an
+// ephemeral container mounting the volumes
+func verifyMountedFiles(clients kube.Clients, pod *v1.Pod, files mountedFiles) error {
+	var mounts []v1.VolumeMount
+	var expected strings.Builder
+	for _, volume := range slices.Sorted(maps.Keys(files)) {
+		mountPath := "/reloader/" + volume
+		mounts = append(mounts, v1.VolumeMount{Name: volume, MountPath: mountPath, ReadOnly: true})
+		for _, path := range slices.Sorted(maps.Keys(files[volume])) {
+			fmt.Fprintf(&expected, "%s  %s/%s\n", files[volume][path], mountPath, path)
+		}
+	}
+
+	container := v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:    ephemeralContainerPrefix + "verify-" + utilrand.String(5),
+			Image:   options.SignalImage,
+			Command: []string{"sh", "-c", verifyScript},
+			Env: []v1.EnvVar{
+				{Name: "EXPECTED", Value: expected.String()},
+				{Name: "TIMEOUT", Value: strconv.Itoa(int(options.ReloadHookTimeout.Seconds()))},
+			},
+			VolumeMounts: mounts,
+		},
+	}
+	err := runEphemeralContainers(clients, pod, []v1.EphemeralContainer{container}, options.ReloadHookTimeout+signalTimeout)
+	if err != nil {
+		return fmt.Errorf("mounted files were not updated: %w", err)
+	}
+	return nil
+}
+
+// callReloadHook sends a POST request to the reload hook of the pod
+func callReloadHook(pod *v1.Pod, hook string) error {
+	endpoint, err := getReloadHookURL(hook, pod.Status.PodIP)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), signalTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	response, err := hookHTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("reload hook returned status %d", response.StatusCode)
+	}
+	return nil
+}
+
+// getReloadHookURL returns the URL of the hook in the format [scheme://]:port/path for the pod IP
+func getReloadHookURL(hook string, podIP string) (string, error) {
+	value := hook
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	endpoint, err := url.Parse(value)
+	if err != nil || endpoint.Port() == "" {
+		return "", fmt.Errorf("invalid value '%s' for annotation '%s', expected [scheme://]:port/path", hook, options.ReloadHookAnnotation)
+	}
+	if podIP == "" {
+		return "", errors.New("pod has no IP")
+	}
+
+	endpoint.Host = net.JoinHostPort(podIP, endpoint.Port())
+	return endpoint.String(), nil
+}
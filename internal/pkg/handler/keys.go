@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/crypto"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	patchtypes "k8s.io/apimachinery/pkg/types"
+)
+
+// changesConsumedKeys returns whether the change affects a key of the configmap or secret the workload consumes.
+// Keys listed in the keys annotation take precedence over the consumed keys, a workload consuming the whole
+// configmap or secret, a change of metadata opted into the hash or a change with unknown keys always qualifies
+func changesConsumedKeys(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) bool {
+	if config.ChangedKeys == nil || slices.ContainsFunc(config.ChangedKeys, util.IsMetadataKey) {
+		return true
+	}
+
+	keys, all := getAnnotatedKeys(upgradeFuncs, item, config), false
+	if len(keys) == 0 {
+		keys, all = getConsumedKeys(upgradeFuncs, item, config)
+	}
+	if all {
+		return true
+	}
+
+	for _, key := range config.ChangedKeys {
+		if slices.Contains(keys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// getConsumedHash returns the hash of the keys of the configmap or secret the workload consumes and of the metadata
+// opted into the hash. found is false if the workload consumes the whole configmap or secret
+func getConsumedHash(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) (hash string, found bool) {
+	keys := getAnnotatedKeys(upgradeFuncs, item, config)
+	if len(keys) == 0 {
+		var all bool
+		if keys, all = getConsumedKeys(upgradeFuncs, item, config); all {
+			return "", false
+		}
+	}
+	for key := range config.KeySHAs {
+		if util.IsMetadataKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range slices.Compact(keys) {
+		entries = append(entries, key+"="+config.KeySHAs[key])
+	}
+	return crypto.GenerateSHA(strings.Join(entries, "\n")), true
+}
+
+// getConsumedHashes returns the hashes of the consumed keys the workload was last reloaded for, keyed like the
+// signaled hashes
+func getConsumedHashes(annotations map[string]string) map[string]string {
+	hashes := make(map[string]string)
+	if value := annotations[options.ConsumedHashesAnnotation]; value != "" {
+		if err := json.Unmarshal([]byte(value), &hashes); err != nil {
+			logrus.Warnf("Ignoring invalid value of annotation '%s': %v", options.ConsumedHashesAnnotation, err)
+			return make(map[string]string)
+		}
+	}
+	return hashes
+}
+
+// getConsumedHashesUpdate returns the consumed hashes annotation of the workload reloaded for the configs and whether
+// it changed. Configs without known keys, e.g. of a deleted configmap or secret, keep their recorded hash
+func getConsumedHashesUpdate(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, configs []util.Config) (value string, changed bool) {
+	hashes := getConsumedHashes(upgradeFuncs.AnnotationsFunc(item))
+	for _, config := range configs {
+		if config.KeySHAs == nil {
+			continue
+		}
+		key := getSignaledKey(config)
+		hash, found := getConsumedHash(upgradeFuncs, item, config)
+		if recorded, ok := hashes[key]; !found && ok {
+			delete(hashes, key)
+			changed = true
+		} else if found && recorded != hash {
+			hashes[key] = hash
+			changed = true
+		}
+	}
+	if !changed || len(hashes) == 0 {
+		return "", changed
+	}
+
+	bytes, err := json.Marshal(hashes)
+	if err != nil {
+		logrus.Errorf("Failed to create consumed hashes annotation! error = %v", err)
+		return "", false
+	}
+	return string(bytes), true
+}
+
+// setConsumedHashes sets the consumed hashes annotation on the workload before it is updated, an empty value removes it
+func setConsumedHashes(item runtime.Object, value string) {
+	accessor, err := meta.Accessor(item)
+	if err != nil {
+		return
+	}
+	annotations := accessor.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	if value == "" {
+		delete(annotations, options.ConsumedHashesAnnotation)
+	} else {
+		annotations[options.ConsumedHashesAnnotation] = value
+	}
+	accessor.SetAnnotations(annotations)
+}
+
+// recordConsumedHashes patches the consumed hashes annotation of the patched workload, an empty value removes it. A
+// workload whose hashes could not be recorded is caught up on start even if its consumed keys did not change
+func recordConsumedHashes(clients kube.Clients, upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, namespace string, resourceName string, value string) {
+	var annotation any
+	if value != "" {
+		annotation = value
+	}
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]any{options.ConsumedHashesAnnotation: annotation}}})
+	if err == nil {
+		err = upgradeFuncs.PatchFunc(clients, namespace, item, patchtypes.MergePatchType, patch)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to record the consumed keys of '%s' of type '%s' in namespace '%s': %v", resourceName, upgradeFuncs.ResourceType, namespace, err)
+	}
+}
+
+// getAnnotatedKeys returns the keys of the configmap or secret listed as name/key pairs in the keys annotation of the
+// workload or its pod template
+func getAnnotatedKeys(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) []string {
+	value := upgradeFuncs.AnnotationsFunc(item)[config.KeysAnnotation]
+	if value == "" {
+		value = upgradeFuncs.PodAnnotationsFunc(item)[config.KeysAnnotation]
+	}
+
+	var keys []string
+	for _, entry := range strings.Split(value, ",") {
+		name, key, found := strings.Cut(strings.TrimSpace(entry), "/")
+		if found && name == config.ResourceName && key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// getConsumedKeys returns the keys of the configmap or secret used by the containers through key references, volume
+// items and sub paths. all is true if a container uses the whole configmap or secret or does not reference it at all,
+// e.g. if it is reloaded through the named annotation
+func getConsumedKeys(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) (keys []string, all bool) {
+	containers := slices.Concat(upgradeFuncs.ContainersFunc(item), upgradeFuncs.InitContainersFunc(item))
+	referenced := false
+
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if key, found := getEnvKey(env.ValueFrom, config); found {
+				keys = append(keys, key)
+				referenced = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if config.Type == constants.ConfigmapEnvVarPostfix && envFrom.ConfigMapRef != nil && envFrom.ConfigMapRef.Name == config.ResourceName ||
+				config.Type == constants.SecretEnvVarPostfix && envFrom.SecretRef != nil && envFrom.SecretRef.Name == config.ResourceName {
+				return nil, true
+			}
+		}
+	}
+
+	for _, volume := range upgradeFuncs.VolumesFunc(item) {
+		projections := getVolumeProjections(volume, config)
+		if len(projections) == 0 {
+			continue
+		}
+		for _, container := range containers {
+			for _, mount := range container.VolumeMounts {
+				if mount.Name != volume.Name {
+					continue
+				}
+				referenced = true
+				for _, items := range projections {
+					mountedKeys, mountedAll := getMountedKeys(items, mount.SubPath)
+					if mountedAll {
+						return nil, true
+					}
+					keys = append(keys, mountedKeys...)
+				}
+			}
+		}
+	}
+
+	return keys, !referenced
+}
+
+func getEnvKey(source *v1.EnvVarSource, config util.Config) (string, bool) {
+	if source == nil {
+		return "", false
+	}
+	if config.Type == constants.ConfigmapEnvVarPostfix && source.ConfigMapKeyRef != nil && source.ConfigMapKeyRef.Name == config.ResourceName {
+		return source.ConfigMapKeyRef.Key, true
+	}
+	if config.Type == constants.SecretEnvVarPostfix && source.SecretKeyRef != nil && source.SecretKeyRef.Name == config.ResourceName {
+		return source.SecretKeyRef.Key, true
+	}
+	return "", false
+}
+
+// getVolumeProjections returns the items of every projection of the configmap or secret in the volume
+func getVolumeProjections(volume v1.Volume, config util.Config) [][]v1.KeyToPath {
+	var projections [][]v1.KeyToPath
+	if config.Type == constants.ConfigmapEnvVarPostfix {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == config.ResourceName {
+			projections = append(projections, volume.ConfigMap.Items)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil && source.ConfigMap.Name == config.ResourceName {
+					projections = append(projections, source.ConfigMap.Items)
+				}
+			}
+		}
+	} else if config.Type == constants.SecretEnvVarPostfix {
+		if volume.Secret != nil && volume.Secret.SecretName == config.ResourceName {
+			projections = append(projections, volume.Secret.Items)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == config.ResourceName {
+					projections = append(projections, source.Secret.Items)
+				}
+			}
+		}
+	}
+	return projections
+}
+
+// getMountedKeys returns the keys of the projection visible in a mount with the sub path. Without items every key
+// is a file named after it, with items only the listed keys are projected to their paths
+func getMountedKeys(items []v1.KeyToPath, subPath string) (keys []string, all bool) {
+	subPath = strings.Trim(subPath, "/")
+	if len(items) == 0 {
+		if subPath == "" {
+			return nil, true
+		}
+		key, _, _ := strings.Cut(subPath, "/")
+		return []string{key}, false
+	}
+
+	for _, item := range items {
+		path := strings.Trim(item.Path, "/")
+		if subPath == "" || path == subPath || strings.HasPrefix(path, subPath+"/") || strings.HasPrefix(subPath, path+"/") {
+			keys = append(keys, item.Key)
+		}
+	}
+	return keys, false
+}
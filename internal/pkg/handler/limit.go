@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	alert "github.com/stakater/Reloader/internal/pkg/alerts"
+	"github.com/stakater/Reloader/internal/pkg/options"
+)
+
+// reloadLimiter limits the rollouts started by reloads across all namespaces
+var reloadLimiter = newRolloutLimiter()
+
+// slotRetryInterval is the delay after which a reload without a free rollout slot is attempted again
+var slotRetryInterval = 5 * time.Second
+
+// RequeueError is returned when a change can not be processed yet, it is queued again after the delay without
+// counting as a failed attempt
+type RequeueError struct {
+	After  time.Duration
+	Reason string
+	// Paused is set if the reloads are paused after failed rollouts, the change may wait until the workloads recover
+	Paused bool
+}
+
+func (e *RequeueError) Error() string {
+	return fmt.Sprintf("%s, retrying in %s", e.Reason, e.After)
+}
+
+// isRequeue returns whether the change has to be queued again because of a RequeueError
+func isRequeue(err error) bool {
+	var requeue *RequeueError
+	return errors.As(err, &requeue)
+}
+
+// rolloutLimiter bounds the number of rollouts in flight and staggers the start of consecutive reloads. Once
+// options.PauseAfterFailedRollouts rollouts failed, no further reloads are started until the failed workloads recover
+type rolloutLimiter struct {
+	mu       sync.Mutex
+	inFlight int
+	// nextStart is the earliest time the next reload may start
+	nextStart time.Time
+	// failed are the workloads whose rollout failed and did not recover yet
+	failed map[string]bool
+}
+
+func newRolloutLimiter() *rolloutLimiter {
+	return &rolloutLimiter{failed: make(map[string]bool)}
+}
+
+// tryAcquire takes a rollout slot for the reload of the workload if one is free and the stagger since the previous
+// reload passed. The returned func releases the slot, it has to be called once the rollout completed, failed or the
+// reload was not performed. Otherwise a RequeueError with the time to wait is returned, workers are not blocked
+// while the reload waits
+func (l *rolloutLimiter) tryAcquire(workload string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The failed workloads themselves are still reloaded, so a fix of their configuration can roll out
+	if l.paused() && !l.failed[workload] {
+		return nil, &RequeueError{After: slotRetryInterval, Reason: fmt.Sprintf("reloads are paused after the failed rollouts of %s", l.describeFailed()), Paused: true}
+	}
+	if limit := options.MaxConcurrentRollouts; limit > 0 && l.inFlight >= limit {
+		return nil, &RequeueError{After: slotRetryInterval, Reason: fmt.Sprintf("waiting for one of %d rollouts in flight to finish", l.inFlight)}
+	}
+	if wait := time.Until(l.nextStart); wait > 0 {
+		return nil, &RequeueError{After: wait, Reason: "waiting for the reload stagger"}
+	}
+
+	l.inFlight++
+	l.nextStart = time.Now().Add(options.ReloadStagger)
+	if options.ReloadJitter > 0 {
+		l.nextStart = l.nextStart.Add(rand.N(options.ReloadJitter))
+	}
+
+	var once sync.Once
+	return func() { once.Do(l.release) }, nil
+}
+
+func (l *rolloutLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+}
+
+// paused returns whether enough rollouts failed to stop starting reloads, the caller has to hold the lock
+func (l *rolloutLimiter) paused() bool {
+	return limitsRollouts() && options.PauseAfterFailedRollouts > 0 && len(l.failed) >= options.PauseAfterFailedRollouts
+}
+
+// fail records the failed rollout of the workload and reports when it pauses the reloads
+func (l *rolloutLimiter) fail(workload string) {
+	l.mu.Lock()
+	wasPaused := l.paused()
+	l.failed[workload] = true
+	pausing := !wasPaused && l.paused()
+	failed := l.describeFailed()
+	l.mu.Unlock()
+
+	if pausing {
+		logrus.Warnf("Pausing reloads after the failed rollouts of %s, they resume once the workloads recover", failed)
+		alert.SendFailureAlert(fmt.Sprintf("Reloader paused reloads after the failed rollouts of %s, they resume once the workloads recover", failed))
+	}
+}
+
+// recovered removes the workload from the failed rollouts and reports when it resumes the reloads
+func (l *rolloutLimiter) recovered(workload string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.failed[workload] {
+		return
+	}
+	wasPaused := l.paused()
+	delete(l.failed, workload)
+	if wasPaused && !l.paused() {
+		logrus.Infof("Resuming reloads, the rollout of %s recovered", workload)
+	}
+}
+
+// describeFailed lists the workloads with a failed rollout, the caller has to hold the lock
+func (l *rolloutLimiter) describeFailed() string {
+	workloads := make([]string, 0, len(l.failed))
+	for workload := range l.failed {
+		workloads = append(workloads, fmt.Sprintf("'%s'", workload))
+	}
+	slices.Sort(workloads)
+	return strings.Join(workloads, ", ")
+}
+
+// limitsRollouts returns whether the slot of a reload is held until its rollout finished
+func limitsRollouts() bool {
+	return options.MaxConcurrentRollouts > 0
+}
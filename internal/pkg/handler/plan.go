@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// ReloadError is returned when a target of a change failed to reload, Targets are the failed target and the targets
+// which were not reloaded after it in the format <kind>/<name>
+type ReloadError struct {
+	Targets []string
+	Err     error
+}
+
+func (e *ReloadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReloadError) Unwrap() error {
+	return e.Err
+}
+
+// reloadTarget is a workload evaluated for a change
+type reloadTarget struct {
+	upgradeFuncs callbacks.RollingUpgradeFuncs
+	item         runtime.Object
+}
+
+// String returns the target in the format <kind>/<name>
+func (t reloadTarget) String() string {
+	return fmt.Sprintf("%s/%s", t.upgradeFuncs.ResourceType, t.name())
+}
+
+// key returns the key the debounced and deferred reloads of the target are kept by
+func (t reloadTarget) key(namespace string) string {
+	return fmt.Sprintf("%s/%s/%s", t.upgradeFuncs.ResourceType, namespace, t.name())
+}
+
+func (t reloadTarget) name() string {
+	accessor, err := meta.Accessor(t.item)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetName()
+}
+
+// reloadsAfter returns whether the workload is listed in the reload after annotation of the target, entries without
+// a kind match workloads of every kind
+func (t reloadTarget) reloadsAfter(other reloadTarget) bool {
+	value := t.upgradeFuncs.AnnotationsFunc(t.item)[options.ReloadAfterAnnotation]
+	if value == "" {
+		return false
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		kind, name, found := strings.Cut(strings.TrimSpace(entry), "/")
+		if !found {
+			kind, name = "", kind
+		}
+		if name == other.name() && (kind == "" || strings.EqualFold(kind, other.upgradeFuncs.ResourceType)) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadPlanProgress keeps the targets of the requeued changes which were handled before they were requeued
+var reloadPlanProgress = &planProgress{done: make(map[string]map[string]bool)}
+
+// planProgress keeps the targets handled by the previous attempts of the changes, so a change requeued while waiting
+// for a rollout slot does not reload them again, which would take the stagger of the next target again
+type planProgress struct {
+	mu   sync.Mutex
+	done map[string]map[string]bool
+}
+
+// take returns and forgets the targets handled by the previous attempts of the change
+func (p *planProgress) take(key string) map[string]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	done, found := p.done[key]
+	if !found {
+		return make(map[string]bool)
+	}
+	delete(p.done, key)
+	return done
+}
+
+// keep stores the targets handled before the change was requeued
+func (p *planProgress) keep(key string, done map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] = done
+}
+
+func getPlanKey(config util.Config) string {
+	return fmt.Sprintf("%s/%s/%s@%s", config.Type, config.Namespace, config.ResourceName, config.SHAValue)
+}
+
+// performPlan reloads the targets of the change ordered by their dependencies. A target is only reloaded once the
+// rollouts of the targets it depends on and which were reloaded before completed. Targets handled before the change
+// was requeued are not evaluated again
+func performPlan(clients kube.Clients, config util.Config, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy, targets []reloadTarget) error {
+	var namespace *v1.Namespace
+	if len(targets) > 0 {
+		namespace = getNamespace(clients, config.Namespace)
+	}
+
+	dependencies := getDependencies(targets)
+	reloaded := make([]bool, len(targets))
+	rollouts := make(map[int]error)
+	planKey := getPlanKey(config)
+	done := reloadPlanProgress.take(planKey)
+
+	var skipped []string
+	var skipErrs []error
+	order := orderTargets(targets, dependencies)
+	for n, i := range order {
+		target := targets[i]
+		if done[target.String()] {
+			// Its rollout is awaited by its dependents like the one of a target reloaded by a previous attempt
+			continue
+		}
+		if err := waitForDependencies(clients, config, targets, dependencies[i], reloaded, rollouts); err != nil {
+			if isRequeue(err) {
+				reloadPlanProgress.keep(planKey, done)
+				return err
+			}
+			message := fmt.Sprintf("Skipped reload of '%s' of type '%s' in namespace '%s' after changes in %s: %v",
+				target.name(), target.upgradeFuncs.ResourceType, config.Namespace, describeReloadSources([]util.Config{config}), err)
+			logrus.Warn(message)
+			if recorder != nil {
+				recorder.Event(target.item, v1.EventTypeWarning, "ReloadSkipped", message)
+			}
+			skipped = append(skipped, target.String())
+			skipErrs = append(skipErrs, fmt.Errorf("skipped reload of '%s' of type '%s': %w", target.name(), target.upgradeFuncs.ResourceType, err))
+			continue
+		}
+
+		var err error
+		reloaded[i], err = reloadItem(clients, config, target.upgradeFuncs, collectors, recorder, strategy, namespace, target.item)
+		if isRequeue(err) {
+			reloadPlanProgress.keep(planKey, done)
+			return err
+		}
+		if err != nil {
+			remaining := slices.Clone(skipped)
+			for _, j := range order[n:] {
+				remaining = append(remaining, targets[j].String())
+			}
+			return &ReloadError{Targets: remaining, Err: errors.Join(append([]error{err}, skipErrs...)...)}
+		}
+		done[target.String()] = true
+	}
+
+	// The skipped targets fail the change, so it is retried once the dependencies recovered and dropped otherwise
+	if len(skipped) > 0 {
+		return &ReloadError{Targets: skipped, Err: errors.Join(skipErrs...)}
+	}
+	return nil
+}
+
+// getDependencies returns the indexes of the targets each target has to be reloaded after
+func getDependencies(targets []reloadTarget) [][]int {
+	dependencies := make([][]int, len(targets))
+	for i, target := range targets {
+		for j, other := range targets {
+			if i != j && target.reloadsAfter(other) {
+				dependencies[i] = append(dependencies[i], j)
+			}
+		}
+	}
+	return dependencies
+}
+
+// orderTargets returns the indexes of the targets so each follows its dependencies, the order of the targets is kept
+// otherwise. Dependency cycles are broken at the first target of the cycle
+func orderTargets(targets []reloadTarget, dependencies [][]int) []int {
+	order := make([]int, 0, len(targets))
+	placed := make([]bool, len(targets))
+
+	for len(order) < len(targets) {
+		next := -1
+		for i := range targets {
+			if !placed[i] && allPlaced(dependencies[i], placed) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			for i := range targets {
+				if !placed[i] {
+					next = i
+					break
+				}
+			}
+			logrus.Warnf("Ignoring cyclic dependencies of '%s' of type '%s' in annotation '%s'",
+				targets[next].name(), targets[next].upgradeFuncs.ResourceType, options.ReloadAfterAnnotation)
+		}
+		placed[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+func allPlaced(indexes []int, placed []bool) bool {
+	for _, i := range indexes {
+		if !placed[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitForPendingReload returns a RequeueError if the reload of the dependency for the change is debounced or deferred,
+// its dependents are held back until it is applied. Requeueing does not block a worker for the whole window
+func waitForPendingReload(dependency reloadTarget, config util.Config) error {
+	key := dependency.key(config.Namespace)
+	if reloadDebouncer.hasPending(key, config) {
+		return &RequeueError{After: slotRetryInterval, Reason: fmt.Sprintf("waiting for the debounced reload of '%s' of type '%s'", dependency.name(), dependency.upgradeFuncs.ResourceType)}
+	}
+	if reloadDeferrer.hasPending(key, config) {
+		return &RequeueError{After: deferredReloadInterval, Reason: fmt.Sprintf("waiting for the deferred reload of '%s' of type '%s'", dependency.name(), dependency.upgradeFuncs.ResourceType)}
+	}
+	return nil
+}
+
+// waitForDependencies waits for the rollouts of the reloaded dependencies, the outcome of each rollout is kept in
+// rollouts so it is only awaited once. A RequeueError is returned while the reload of a dependency is debounced or
+// deferred. Dependencies which were not reloaded right away otherwise, e.g. because they were not affected, are only
+// waited for if their rollout did not complete
+func waitForDependencies(clients kube.Clients, config util.Config, targets []reloadTarget, dependencies []int, reloaded []bool, rollouts map[int]error) error {
+	if options.DryRun {
+		return nil
+	}
+
+	for _, j := range dependencies {
+		if err := waitForPendingReload(targets[j], config); err != nil {
+			return err
+		}
+		if !reloaded[j] && !reloadRolloutWatcher.following(targets[j].upgradeFuncs.ResourceType, config.Namespace, targets[j].name()) {
+			// A dependency reloaded by a previous attempt of the change is only waited for if it is not healthy, so
+			// its dependents are still held back when the change is retried after its rollout failed
+			complete, err := rolloutComplete(targets[j].item)
+			if complete || errors.Is(err, errRolloutNotSupported) {
+				continue
+			}
+		}
+
+		err, found := rollouts[j]
+		if !found {
+			dependency := targets[j]
+			logrus.Infof("Waiting for the rollout of '%s' of type '%s' in namespace '%s' before reloading its dependents",
+				dependency.name(), dependency.upgradeFuncs.ResourceType, config.Namespace)
+			ctx, cancel := context.WithTimeout(context.Background(), options.RolloutTimeout)
+			err = waitForRollout(ctx, clients, dependency.upgradeFuncs, dependency.name(), config.Namespace)
+			cancel()
+			if errors.Is(err, errRolloutNotSupported) {
+				err = nil
+			}
+			rollouts[j] = err
+		}
+		if err != nil {
+			return fmt.Errorf("rollout of '%s' of type '%s' failed: %w", targets[j].name(), targets[j].upgradeFuncs.ResourceType, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// ResourceReconcileHandler contains existing objects whose consuming workloads are checked for missed changes
+type ResourceReconcileHandler struct {
+	Resource   interface{}
+	Collectors metrics.Collectors
+	Recorder   record.EventRecorder
+	// Drift reports the stale workloads as drifted, they are only reloaded if fixing drift is enabled
+	Drift bool
+}
+
+// Handle reloads the workloads which were reloaded for a previous content of the resource, changes which happened
+// while Reloader was not running are caught up without reloading workloads which are up to date
+func (r ResourceReconcileHandler) Handle() error {
+	if r.Resource == nil {
+		logrus.Errorf("Resource reconcile handler received nil resource")
+		return nil
+	}
+	// Workloads are not updated in webhook mode, so there is no hash to compare
+	if options.WebhookUrl != "" {
+		return nil
+	}
+
+	config, _ := r.GetConfig()
+	clients := kube.GetClients()
+	upgradeFuncs, err := getRollingUpgradeFuncs()
+	if err != nil {
+		return err
+	}
+
+	targets := slices.DeleteFunc(getTargets(clients, config, upgradeFuncs), func(target reloadTarget) bool {
+		return !isStale(target.upgradeFuncs, target.item, config) || hasPendingReload(target, config)
+	})
+	if len(targets) == 0 {
+		return nil
+	}
+
+	if r.Drift {
+		for _, target := range targets {
+			r.reportDrift(config, target)
+		}
+		if !options.DriftFix {
+			return nil
+		}
+	}
+
+	logrus.Infof("Catching up on the change of %s '%s' in namespace '%s' for %d workloads", config.Type, config.ResourceName, config.Namespace, len(targets))
+	err = performPlan(clients, config, r.Collectors, r.Recorder, invokeReloadStrategy, targets)
+	if err != nil && !isRequeue(err) {
+		logrus.Errorf("Catch-up reload for '%s' failed with error = %v", config.ResourceName, err)
+	}
+	return err
+}
+
+// reportDrift reports that the workload runs with an outdated content of the configmap or secret
+func (r ResourceReconcileHandler) reportDrift(config util.Config, target reloadTarget) {
+	message := fmt.Sprintf("'%s' of type '%s' in namespace '%s' was last reloaded for a previous content of %s",
+		target.name(), target.upgradeFuncs.ResourceType, config.Namespace, describeReloadSources([]util.Config{config}))
+	logrus.Warn(message)
+	if r.Recorder != nil {
+		r.Recorder.Event(target.item, v1.EventTypeWarning, "ReloadDrift", message)
+	}
+	r.Collectors.DriftDetections.With(prometheus.Labels{"workload_type": target.upgradeFuncs.ResourceType, "namespace": config.Namespace}).Inc()
+}
+
+// hasPendingReload returns whether a change of the configmap or secret is debounced or deferred for the workload, it
+// is up to date once the change is applied
+func hasPendingReload(target reloadTarget, config util.Config) bool {
+	key := target.key(config.Namespace)
+	return reloadDebouncer.hasPending(key, config) || reloadDeferrer.hasPending(key, config)
+}
+
+// GetConfig gets configurations containing SHA, annotations, namespace and resource name
+func (r ResourceReconcileHandler) GetConfig() (util.Config, string) {
+	var oldSHAData string
+	var config util.Config
+	if _, ok := r.Resource.(*v1.ConfigMap); ok {
+		config = util.GetConfigmapConfig(r.Resource.(*v1.ConfigMap))
+	} else if _, ok := r.Resource.(*v1.Secret); ok {
+		config = util.GetSecretConfig(r.Resource.(*v1.Secret))
+	} else {
+		logrus.Warnf("Invalid resource: Resource should be 'Secret' or 'Configmap' but found, %v", r.Resource)
+	}
+	return config, oldSHAData
+}
+
+// isStale returns whether the workload was reloaded for a previous content of the configmap or secret. Workloads
+// without a recorded hash were never reloaded for it, it is unknown which content they run with. Workloads consuming
+// some keys are only stale if the hash of these keys changed since their last reload
+func isStale(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) bool {
+	hashes := getRecordedHashes(upgradeFuncs, item, config)
+	if len(hashes) == 0 || slices.Contains(hashes, config.SHAValue) {
+		return false
+	}
+
+	recorded, found := getConsumedHashes(upgradeFuncs.AnnotationsFunc(item))[getSignaledKey(config)]
+	if !found {
+		return true
+	}
+	hash, consumesKeys := getConsumedHash(upgradeFuncs, item, config)
+	return !consumesKeys || hash != recorded
+}
+
+// getRecordedHashes returns the hashes of the configmap or secret recorded on the workload by the reload strategies,
+// the env var of the env-vars strategy, the last reloaded from annotation of the annotations strategy, the restarted
+// for annotation of the restart strategy and the signaled annotation of in-place reloads
+func getRecordedHashes(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) []string {
+	var hashes []string
+	envVar := getEnvVarName(config.ResourceName, config.Type)
+	for _, container := range slices.Concat(upgradeFuncs.ContainersFunc(item), upgradeFuncs.InitContainersFunc(item)) {
+		for _, env := range container.Env {
+			if env.Name == envVar {
+				hashes = append(hashes, env.Value)
+			}
+		}
+	}
+
+	if value := upgradeFuncs.PodAnnotationsFunc(item)[getReloaderAnnotationKey()]; value != "" {
+		var source util.ReloadSource
+		if err := json.Unmarshal([]byte(value), &source); err == nil && source.Type == config.Type && source.Name == config.ResourceName && source.Hash != "" {
+			hashes = append(hashes, source.Hash)
+		}
+	}
+
+	if hash, found := getSignaledHashes(upgradeFuncs.AnnotationsFunc(item))[getSignaledKey(config)]; found {
+		hashes = append(hashes, hash)
+	}
+	if hash, found := getRestartedForHashes(upgradeFuncs.PodAnnotationsFunc(item))[getSignaledKey(config)]; found {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	openshiftscheme "github.com/openshift/client-go/apps/clientset/versioned/scheme"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	patchtypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// updateRestartedAtAnnotation sets the restartedAt annotation of the pod template like kubectl rollout restart and
+// records the hash of the change in the restarted for annotation, a workload is restarted once per change. The
+// annotations are set through a server-side apply so they are owned by Reloader and not reported as drift by GitOps
+// tools comparing the fields they manage
+func updateRestartedAtAnnotation(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
+	container := getContainerUsingResource(upgradeFuncs, item, config, autoReload)
+	if container == nil {
+		return InvokeStrategyResult{constants.NoContainerFound, nil}
+	}
+
+	pa := upgradeFuncs.PodAnnotationsFunc(item)
+	if pa == nil {
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	hashes := getRestartedForHashes(pa)
+	key := getSignaledKey(config)
+	if hashes[key] == config.SHAValue {
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	hashes[key] = config.SHAValue
+	restartedFor := formatRestartedForHashes(hashes)
+	restartedAt := time.Now().Format(time.RFC3339)
+	pa[constants.RestartedAtAnnotation] = restartedAt
+	pa[options.RestartedForAnnotation] = restartedFor
+
+	if !upgradeFuncs.SupportsPatch {
+		return InvokeStrategyResult{constants.Updated, nil}
+	}
+
+	patch, err := createRestartedAtPatch(upgradeFuncs, item, restartedAt, restartedFor)
+	if err != nil {
+		logrus.Errorf("Failed to create restartedAt patch for %s! error = %v", config.ResourceName, err)
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	return InvokeStrategyResult{constants.Updated, &Patch{Type: patchtypes.ApplyPatchType, Bytes: patch}}
+}
+
+// getRestartedForHashes returns the hashes of the configmaps and secrets the workload was last restarted for, the
+// annotation lists them as <type>/<name>=<hash> entries
+func getRestartedForHashes(annotations map[string]string) map[string]string {
+	hashes := make(map[string]string)
+	for _, entry := range strings.Split(annotations[options.RestartedForAnnotation], ",") {
+		if key, hash, found := strings.Cut(entry, "="); found && key != "" {
+			hashes[key] = hash
+		}
+	}
+	return hashes
+}
+
+func formatRestartedForHashes(hashes map[string]string) string {
+	entries := make([]string, 0, len(hashes))
+	for key, hash := range hashes {
+		entries = append(entries, key+"="+hash)
+	}
+	slices.Sort(entries)
+	return strings.Join(entries, ",")
+}
+
+// createRestartedAtPatch returns the apply configuration of the restartedAt and restarted for annotations of the
+// workload
+func createRestartedAtPatch(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, restartedAt string, restartedFor string) ([]byte, error) {
+	gvk, err := getGroupVersionKind(item)
+	if err != nil {
+		return nil, err
+	}
+	accessor, err := meta.Accessor(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return fmt.Appendf(nil, upgradeFuncs.PatchTemplatesFunc().ApplyAnnotationTemplate,
+		gvk.GroupVersion().String(), gvk.Kind, accessor.GetName(), constants.RestartedAtAnnotation, restartedAt,
+		options.RestartedForAnnotation, restartedFor), nil
+}
+
+// getGroupVersionKind returns the kind of the workload, the objects returned by typed clients have no type meta
+func getGroupVersionKind(item runtime.Object) (schema.GroupVersionKind, error) {
+	for _, s := range []*runtime.Scheme{scheme.Scheme, openshiftscheme.Scheme} {
+		if kinds, _, err := s.ObjectKinds(item); err == nil && len(kinds) > 0 {
+			return kinds[0], nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("unknown kind of %T", item)
+}
@@ -0,0 +1,325 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	alert "github.com/stakater/Reloader/internal/pkg/alerts"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/history"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// rolloutPollInterval is the interval the status of a reloaded workload is checked with
+var rolloutPollInterval = 2 * time.Second
+
+// reloadRolloutWatcher follows the rollouts started by reloads
+var reloadRolloutWatcher = newRolloutWatcher()
+
+// errRolloutNotSupported is returned for workloads whose rollout can not be followed
+var errRolloutNotSupported = errors.New("rollout status not supported")
+
+type rolloutWatcher struct {
+	mu      sync.Mutex
+	watches map[string]*rolloutWatch
+}
+
+// rolloutWatch is the rollout of a workload being followed, it is cancelled when the workload is reloaded again
+type rolloutWatch struct {
+	cancel     context.CancelFunc
+	superseded bool
+}
+
+func newRolloutWatcher() *rolloutWatcher {
+	return &rolloutWatcher{watches: make(map[string]*rolloutWatch)}
+}
+
+// watch follows the rollout of the reloaded workload in the background until it completes, fails or times out,
+// calls release and reports the outcome through an event, a metric and an alert if options.WaitForRollout is set
+func (w *rolloutWatcher) watch(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string, release func()) {
+	namespace := configs[0].Namespace
+	if _, err := rolloutComplete(resource); errors.Is(err, errRolloutNotSupported) {
+		logrus.Debugf("Not waiting for the rollout of '%s' of type '%s' in namespace '%s': %v", resourceName, upgradeFuncs.ResourceType, namespace, err)
+		release()
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s/%s", upgradeFuncs.ResourceType, namespace, resourceName)
+	ctx, cancel := context.WithTimeout(context.Background(), options.RolloutTimeout)
+	current := &rolloutWatch{cancel: cancel}
+
+	w.mu.Lock()
+	if previous, found := w.watches[key]; found {
+		// The outcome of the previous rollout is no longer relevant, the new one replaces it
+		previous.superseded = true
+		previous.cancel()
+	}
+	w.watches[key] = current
+	w.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		start := time.Now()
+		err := waitForRollout(ctx, clients, upgradeFuncs, resourceName, namespace)
+		duration := time.Since(start).Round(time.Second)
+		// The slot is released on failures as well, the failed rollout pauses further reloads instead
+		release()
+
+		if w.finish(key, current) {
+			logrus.Infof("Rollout of '%s' of type '%s' in namespace '%s' was superseded by another reload", resourceName, upgradeFuncs.ResourceType, namespace)
+			return
+		}
+		if err == nil || apierrors.IsNotFound(err) {
+			reloadLimiter.recovered(key)
+		} else if limitsRollouts() {
+			reloadLimiter.fail(key)
+			w.watchRecovery(clients, upgradeFuncs, resourceName, namespace, key)
+		}
+		if options.WaitForRollout {
+			reportRolloutOutcome(clients, configs, upgradeFuncs, collectors, recorder, resource, resourceName, duration, err)
+		}
+	}()
+}
+
+// following returns whether the rollout of the workload is followed, e.g. because a previous attempt to process
+// the change reloaded it before the change was queued again
+func (w *rolloutWatcher) following(resourceType string, namespace string, resourceName string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, found := w.watches[fmt.Sprintf("%s/%s/%s", resourceType, namespace, resourceName)]
+	return found
+}
+
+// finish stops following the rollout and returns whether it was superseded by another reload of the workload
+func (w *rolloutWatcher) finish(key string, current *rolloutWatch) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watches[key] == current {
+		delete(w.watches, key)
+	}
+	return current.superseded
+}
+
+// watchRecovery keeps following the workload after its rollout failed until it becomes healthy or is deleted, e.g.
+// after it was fixed manually, so reloads paused by the failure resume. A reload of the workload supersedes it
+func (w *rolloutWatcher) watchRecovery(clients kube.Clients, upgradeFuncs callbacks.RollingUpgradeFuncs, resourceName string, namespace string, key string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	current := &rolloutWatch{cancel: cancel}
+
+	w.mu.Lock()
+	if _, found := w.watches[key]; found {
+		// The workload was reloaded again in the meantime, the new rollout decides whether it recovered
+		w.mu.Unlock()
+		cancel()
+		return
+	}
+	w.watches[key] = current
+	w.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		err := wait.PollUntilContextCancel(ctx, rolloutPollInterval, false, func(context.Context) (bool, error) {
+			item, err := upgradeFuncs.ItemFunc(clients, resourceName, namespace)
+			if apierrors.IsNotFound(err) {
+				return true, nil
+			}
+			if err != nil {
+				return false, nil
+			}
+			// The workload stays failed until a later rollout completes
+			complete, _ := rolloutComplete(item)
+			return complete, nil
+		})
+		if w.finish(key, current) || err != nil {
+			return
+		}
+		logrus.Infof("'%s' of type '%s' in namespace '%s' recovered from its failed rollout", resourceName, upgradeFuncs.ResourceType, namespace)
+		reloadLimiter.recovered(key)
+	}()
+}
+
+// waitForRollout polls the workload until its rollout completes, the returned error describes why it failed
+func waitForRollout(ctx context.Context, clients kube.Clients, upgradeFuncs callbacks.RollingUpgradeFuncs, resourceName string, namespace string) error {
+	var lastErr error
+	err := wait.PollUntilContextCancel(ctx, rolloutPollInterval, true, func(context.Context) (bool, error) {
+		item, err := upgradeFuncs.ItemFunc(clients, resourceName, namespace)
+		if apierrors.IsNotFound(err) {
+			return false, err
+		}
+		if err != nil {
+			// Keep polling on transient errors, the rollout itself is not affected by them
+			lastErr = err
+			return false, nil
+		}
+		return rolloutComplete(item)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		if lastErr != nil {
+			return fmt.Errorf("timed out after %s waiting for the rollout, last error: %w", options.RolloutTimeout, lastErr)
+		}
+		return fmt.Errorf("timed out after %s waiting for the rollout", options.RolloutTimeout)
+	}
+	return err
+}
+
+// reportRolloutOutcome records the event, metric and alert for the outcome of the rollout
+func reportRolloutOutcome(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string, duration time.Duration, err error) {
+	namespace := configs[0].Namespace
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	collectors.RolloutDuration.With(prometheus.Labels{"workload_type": upgradeFuncs.ResourceType, "outcome": outcome}).Observe(duration.Seconds())
+
+	if err != nil {
+		message := fmt.Sprintf("Rollout of '%s' of type '%s' in namespace '%s' after changes in %s failed after %s: %v",
+			resourceName, upgradeFuncs.ResourceType, namespace, describeReloadSources(configs), duration, err)
+		logrus.Error(message)
+		if recorder != nil {
+			recorder.Event(resource, v1.EventTypeWarning, "ReloadFailed", message)
+		}
+		alert.SendFailureAlert(fmt.Sprintf(
+			"Rollout of *%s* of type *%s* in namespace *%s* after changes in %s failed after *%s*: %v",
+			resourceName, upgradeFuncs.ResourceType, namespace, describeAlertSources(configs), duration, err))
+		revertConfigmaps(clients, configs, upgradeFuncs, recorder, resource, resourceName)
+		return
+	}
+
+	message := fmt.Sprintf("Rollout of '%s' of type '%s' in namespace '%s' after changes in %s completed in %s",
+		resourceName, upgradeFuncs.ResourceType, namespace, describeReloadSources(configs), duration)
+	logrus.Info(message)
+	if recorder != nil {
+		recorder.Event(resource, v1.EventTypeNormal, "ReloadSucceeded", message)
+	}
+	if alert_on_reload, ok := os.LookupEnv("ALERT_ON_RELOAD"); ok && alert_on_reload == "true" {
+		alert.SendWebhookAlert(fmt.Sprintf(
+			"Rollout of *%s* of type *%s* in namespace *%s* after changes in %s completed in *%s*",
+			resourceName, upgradeFuncs.ResourceType, namespace, describeAlertSources(configs), duration))
+	}
+}
+
+// revertConfigmaps restores the previous revision of the changed configmaps with the revert annotation, updating them
+// triggers the reload of the workloads with the restored content
+func revertConfigmaps(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, recorder record.EventRecorder, resource runtime.Object, resourceName string) {
+	for _, config := range configs {
+		if config.Type != constants.ConfigmapEnvVarPostfix || config.ResourceAnnotations[options.ConfigmapRevertOnFailureAnnotation] != "true" {
+			continue
+		}
+
+		restored, err := history.Revert(clients.KubernetesClient, config.Namespace, config.ResourceName, config.SHAValue)
+		if err != nil {
+			logrus.Errorf("Failed to revert configmap '%s' in namespace '%s' after the failed rollout of '%s' of type '%s': %v",
+				config.ResourceName, config.Namespace, resourceName, upgradeFuncs.ResourceType, err)
+			continue
+		}
+
+		message := fmt.Sprintf("Reverted configmap '%s' in namespace '%s' to revision '%s' after the failed rollout of '%s' of type '%s'",
+			config.ResourceName, config.Namespace, restored, resourceName, upgradeFuncs.ResourceType)
+		logrus.Info(message)
+		if recorder != nil {
+			recorder.Event(resource, v1.EventTypeWarning, "ConfigReverted", message)
+		}
+		alert.SendFailureAlert(fmt.Sprintf("Reverted configmap *%s* in namespace *%s* to revision *%s* after the failed rollout of *%s* of type *%s*",
+			config.ResourceName, config.Namespace, restored, resourceName, upgradeFuncs.ResourceType))
+	}
+}
+
+// rolloutComplete returns whether the rollout of the workload completed, an error is returned if it failed
+func rolloutComplete(item runtime.Object) (bool, error) {
+	switch workload := item.(type) {
+	case *appsv1.Deployment:
+		return deploymentRolloutComplete(workload)
+	case *appsv1.StatefulSet:
+		return statefulSetRolloutComplete(workload)
+	case *appsv1.DaemonSet:
+		return daemonSetRolloutComplete(workload)
+	case *argorolloutv1alpha1.Rollout:
+		return argoRolloutComplete(workload)
+	}
+	return false, errRolloutNotSupported
+}
+
+func deploymentRolloutComplete(deployment *appsv1.Deployment) (bool, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return false, nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, fmt.Errorf("deployment exceeded its progress deadline: %s", condition.Message)
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.UpdatedReplicas >= replicas && status.Replicas <= status.UpdatedReplicas && status.AvailableReplicas >= status.UpdatedReplicas, nil
+}
+
+func statefulSetRolloutComplete(statefulSet *appsv1.StatefulSet) (bool, error) {
+	if statefulSet.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+		return false, fmt.Errorf("%w for update strategy %s", errRolloutNotSupported, appsv1.OnDeleteStatefulSetStrategyType)
+	}
+	if statefulSet.Generation > statefulSet.Status.ObservedGeneration {
+		return false, nil
+	}
+
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	status := statefulSet.Status
+	if status.ReadyReplicas < replicas {
+		return false, nil
+	}
+	// Only the pods above the partition are updated
+	if rollingUpdate := statefulSet.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil && *rollingUpdate.Partition > 0 {
+		return status.UpdatedReplicas >= replicas-*rollingUpdate.Partition, nil
+	}
+	return status.UpdateRevision == status.CurrentRevision, nil
+}
+
+func daemonSetRolloutComplete(daemonSet *appsv1.DaemonSet) (bool, error) {
+	if daemonSet.Spec.UpdateStrategy.Type == appsv1.OnDeleteDaemonSetStrategyType {
+		return false, fmt.Errorf("%w for update strategy %s", errRolloutNotSupported, appsv1.OnDeleteDaemonSetStrategyType)
+	}
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
+		return false, nil
+	}
+
+	status := daemonSet.Status
+	return status.UpdatedNumberScheduled >= status.DesiredNumberScheduled && status.NumberAvailable >= status.DesiredNumberScheduled, nil
+}
+
+func argoRolloutComplete(rollout *argorolloutv1alpha1.Rollout) (bool, error) {
+	// The phase is only reliable once the rollout controller observed the current generation
+	if rollout.Status.ObservedGeneration != strconv.FormatInt(rollout.Generation, 10) {
+		return false, nil
+	}
+	switch rollout.Status.Phase {
+	case argorolloutv1alpha1.RolloutPhaseDegraded:
+		return false, fmt.Errorf("rollout is degraded: %s", rollout.Status.Message)
+	case argorolloutv1alpha1.RolloutPhaseHealthy:
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	patchtypes "k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+const defaultReloadSignal = "HUP"
+
+// ephemeralContainerPrefix is the prefix of the names of the ephemeral containers added by Reloader
+const ephemeralContainerPrefix = "reloader-"
+
+// signalTimeout is the maximum time the in-place reload of a pod may take
+var signalTimeout = time.Minute
+
+var signalNamePattern = regexp.MustCompile(`^[A-Z0-9+-]+$`)
+
+// updateSignaledAnnotation records the hash of the change in the signaled annotation of the workload, the pods are
+// reloaded in place once the workload is updated. Containers consuming the configmap or secret through environment
+// variables only read it on start, they are restarted with the env-vars strategy instead
+func updateSignaledAnnotation(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
+	container := getContainerUsingResource(upgradeFuncs, item, config, autoReload)
+	if container == nil {
+		return InvokeStrategyResult{constants.NoContainerFound, nil}
+	}
+
+	containers := slices.Concat(upgradeFuncs.ContainersFunc(item), upgradeFuncs.InitContainersFunc(item))
+	if getContainerWithEnvReference(containers, config.ResourceName, config.Type) != nil {
+		return updateContainerEnvVars(upgradeFuncs, item, config, autoReload)
+	}
+
+	annotations := upgradeFuncs.AnnotationsFunc(item)
+	hashes := getSignaledHashes(annotations)
+	key := getSignaledKey(config)
+	if hashes[key] == config.SHAValue {
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	hashes[key] = config.SHAValue
+
+	value, err := json.Marshal(hashes)
+	if err != nil {
+		logrus.Errorf("Failed to create signaled annotation for %s! error = %v", config.ResourceName, err)
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	annotations[options.SignaledAnnotation] = string(value)
+
+	patch, err := json.Marshal(map[string]any{"metadata": map[string]any{"annotations": map[string]string{options.SignaledAnnotation: string(value)}}})
+	if err != nil {
+		logrus.Errorf("Failed to create signaled annotation patch for %s! error = %v", config.ResourceName, err)
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	return InvokeStrategyResult{constants.Updated, &Patch{Type: patchtypes.MergePatchType, Bytes: patch}}
+}
+
+func getSignaledKey(config util.Config) string {
+	return config.Type + "/" + config.ResourceName
+}
+
+// getSignaledHashes returns the hashes of the configmaps and secrets the workload was last reloaded in place for
+func getSignaledHashes(annotations map[string]string) map[string]string {
+	hashes := make(map[string]string)
+	if value := annotations[options.SignaledAnnotation]; value != "" {
+		if err := json.Unmarshal([]byte(value), &hashes); err != nil {
+			logrus.Warnf("Ignoring invalid value of annotation '%s': %v", options.SignaledAnnotation, err)
+			return make(map[string]string)
+		}
+	}
+	return hashes
+}
+
+// getSignaledConfigs returns the configs the updated workload has to be reloaded in place for
+func getSignaledConfigs(upgradeFuncs callbacks.RollingUpgradeFuncs, resource runtime.Object, configs []util.Config) []util.Config {
+	var signaled []util.Config
+	hashes := getSignaledHashes(upgradeFuncs.AnnotationsFunc(resource))
+	for _, config := range configs {
+		if getWorkloadReloadStrategy(upgradeFuncs, resource, config) == constants.SignalReloadStrategy && hashes[getSignaledKey(config)] == config.SHAValue {
+			signaled = append(signaled, config)
+		}
+	}
+	return signaled
+}
+
+// reloadInPlace reloads the pods of the workload in place once the changes were propagated to their mounted files,
+// the workload is restarted if the reload of a pod fails
+func reloadInPlace(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string) {
+	namespace := configs[0].Namespace
+	hook := getWorkloadAnnotation(upgradeFuncs, resource, options.ReloadHookAnnotation)
+	if hook == "" {
+		// Kubelet refreshes the mounted files periodically, signaling before would not pick up the changes. Pods
+		// reloaded through their hook verify their files instead
+		time.Sleep(options.SignalDelay)
+	}
+
+	err := reloadPods(clients, configs, upgradeFuncs, collectors, recorder, resource, resourceName, hook)
+	if err == nil {
+		message := fmt.Sprintf("Changes detected in %s in namespace '%s', Reloaded '%s' of type '%s' in namespace '%s' in place",
+			describeReloadSources(configs), namespace, resourceName, upgradeFuncs.ResourceType, namespace)
+		logrus.Info(message)
+		if recorder != nil {
+			recorder.Event(resource, v1.EventTypeNormal, "ReloadedInPlace", message)
+		}
+		return
+	}
+
+	message := fmt.Sprintf("In-place reload of '%s' of type '%s' in namespace '%s' failed, restarting it: %v",
+		resourceName, upgradeFuncs.ResourceType, namespace, err)
+	if apierrors.IsForbidden(err) {
+		logrus.Error(message)
+	} else {
+		logrus.Warn(message)
+	}
+	if recorder != nil {
+		recorder.Event(resource, v1.EventTypeWarning, "ReloadInPlaceFailed", message)
+	}
+
+	restartAfterFailedReload(clients, configs, upgradeFuncs, collectors, recorder, resourceName)
+}
+
+// restartAfterFailedReload restarts the workload with the env-vars strategy. The restart is scheduled with the
+// debounced reloads, so it waits for a rollout slot, is retried if it fails and a failure alert is sent if it is dropped
+func restartAfterFailedReload(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resourceName string) {
+	restart := &pendingReload{
+		clients:      clients,
+		upgradeFuncs: upgradeFuncs,
+		collectors:   collectors,
+		recorder:     recorder,
+		namespace:    configs[0].Namespace,
+		name:         resourceName,
+	}
+	for _, config := range configs {
+		config.ReloadStrategy = constants.EnvVarsReloadStrategy
+		restart.changes = append(restart.changes, pendingChange{config: config, strategy: updateContainerEnvVars})
+	}
+	reloadDebouncer.schedule(restart, 0)
+}
+
+// reloadPods reloads the pods of the workload in place, the ready pods through their reload hook after verifying
+// their mounted files and otherwise the running pods by signaling their containers
+func reloadPods(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string, hook string) error {
+	namespace := configs[0].Namespace
+	selector, err := getPodSelector(resource)
+	if err != nil {
+		return err
+	}
+
+	pods, err := clients.KubernetesClient.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return describeMissingRBAC(err)
+	}
+
+	method := "signal"
+	var reload func(pod *v1.Pod) error
+	if hook != "" {
+		method = "hook"
+		files, err := getMountedFiles(clients, configs, upgradeFuncs.VolumesFunc(resource))
+		if err != nil {
+			return err
+		}
+		reload = func(pod *v1.Pod) error {
+			if err := verifyMountedFiles(clients, pod, files); err != nil {
+				return err
+			}
+			return callReloadHook(pod, hook)
+		}
+	} else {
+		signal, err := getReloadSignal(getWorkloadAnnotation(upgradeFuncs, resource, options.ReloadSignalAnnotation))
+		if err != nil {
+			return err
+		}
+		containers := getSignaledContainers(upgradeFuncs, resource, configs)
+		reload = func(pod *v1.Pod) error {
+			return signalContainers(clients, pod, containers, signal)
+		}
+	}
+
+	var errs []error
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil || hook != "" && !isPodReady(pod) {
+			continue
+		}
+
+		err := reload(pod)
+		collectors.InPlaceReloads.With(prometheus.Labels{"workload_type": upgradeFuncs.ResourceType, "method": method, "success": strconv.FormatBool(err == nil)}).Inc()
+		if err != nil {
+			message := fmt.Sprintf("In-place reload of pod '%s' of '%s' of type '%s' in namespace '%s' failed: %v", pod.Name, resourceName, upgradeFuncs.ResourceType, namespace, err)
+			logrus.Warn(message)
+			if recorder != nil {
+				recorder.Event(pod, v1.EventTypeWarning, "ReloadInPlaceFailed", message)
+			}
+			errs = append(errs, fmt.Errorf("pod '%s': %w", pod.Name, err))
+			continue
+		}
+		logrus.Debugf("Reloaded pod '%s' of '%s' of type '%s' in namespace '%s' in place", pod.Name, resourceName, upgradeFuncs.ResourceType, namespace)
+	}
+	return errors.Join(errs...)
+}
+
+// describeMissingRBAC explains forbidden errors of in-place reloads. The permissions on pods are not granted by the
+// Helm chart unless the global strategy is signal or reloader.enableInPlaceReloads is set, so workloads opting in
+// through their annotations fail with a forbidden error
+func describeMissingRBAC(err error) error {
+	if !apierrors.IsForbidden(err) {
+		return err
+	}
+	return fmt.Errorf("missing RBAC permissions, in-place reloads need get and list on pods and update on pods/ephemeralcontainers, "+
+		"set reloader.enableInPlaceReloads in the Helm chart: %w", err)
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getPodSelector returns the selector of the pods of the workload from its spec.selector, either a label selector or
+// a map of labels like for DeploymentConfigs
+func getPodSelector(resource runtime.Object) (labels.Selector, error) {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	selector, found, err := unstructured.NestedMap(object, "spec", "selector")
+	if err != nil || !found || len(selector) == 0 {
+		return nil, errors.New("workload has no pod selector")
+	}
+
+	_, hasMatchLabels := selector["matchLabels"]
+	_, hasMatchExpressions := selector["matchExpressions"]
+	if !hasMatchLabels && !hasMatchExpressions {
+		set, found, err := unstructured.NestedStringMap(object, "spec", "selector")
+		if err != nil || !found {
+			return nil, fmt.Errorf("invalid pod selector: %v", err)
+		}
+		return labels.SelectorFromSet(set), nil
+	}
+
+	var labelSelector metav1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(selector, &labelSelector); err != nil {
+		return nil, err
+	}
+	return metav1.LabelSelectorAsSelector(&labelSelector)
+}
+
+// getReloadSignal returns the name of the signal without the SIG prefix as expected by kill
+func getReloadSignal(value string) (string, error) {
+	if value == "" {
+		return defaultReloadSignal, nil
+	}
+	signal := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(value)), "SIG")
+	if !signalNamePattern.MatchString(signal) {
+		return "", fmt.Errorf("invalid value '%s' for annotation '%s'", value, options.ReloadSignalAnnotation)
+	}
+	return signal, nil
+}
+
+// getSignaledContainers returns the containers listed in the container annotation, otherwise the containers using
+// the changed configmaps and secrets
+func getSignaledContainers(upgradeFuncs callbacks.RollingUpgradeFuncs, resource runtime.Object, configs []util.Config) []string {
+	var containers []string
+	if value := getWorkloadAnnotation(upgradeFuncs, resource, options.ReloadContainerAnnotation); value != "" {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(containers, name) {
+				containers = append(containers, name)
+			}
+		}
+		return containers
+	}
+
+	for _, config := range configs {
+		container := getContainerUsingResource(upgradeFuncs, resource, config, false)
+		if container != nil && !slices.Contains(containers, container.Name) {
+			containers = append(containers, container.Name)
+		}
+	}
+	return containers
+}
+
+// signalContainers sends the signal to the main process of the containers from ephemeral containers sharing their
+// process namespace
+func signalContainers(clients kube.Clients, pod *v1.Pod, containers []string, signal string) error {
+	ephemeralContainers := make([]v1.EphemeralContainer, 0, len(containers))
+	for _, container := range containers {
+		ephemeralContainers = append(ephemeralContainers, v1.EphemeralContainer{
+			EphemeralContainerCommon: v1.EphemeralContainerCommon{
+				Name:    ephemeralContainerPrefix + "signal-" + utilrand.String(5),
+				Image:   options.SignalImage,
+				Command: []string{"kill", "-s", signal, "1"},
+			},
+			TargetContainerName: container,
+		})
+	}
+	return runEphemeralContainers(clients, pod, ephemeralContainers, signalTimeout)
+}
+
+// runEphemeralContainers adds the ephemeral containers to the pod and waits until they terminated successfully.
+// Ephemeral containers can not be removed from a pod, the containers are refused once the pod would have more than
+// options.MaxEphemeralContainers of Reloader, the workload is restarted instead
+func runEphemeralContainers(clients kube.Clients, pod *v1.Pod, containers []v1.EphemeralContainer, timeout time.Duration) error {
+	existing := 0
+	for _, container := range pod.Spec.EphemeralContainers {
+		if strings.HasPrefix(container.Name, ephemeralContainerPrefix) {
+			existing++
+		}
+	}
+	if existing+len(containers) > options.MaxEphemeralContainers {
+		return fmt.Errorf("pod has %d ephemeral containers of Reloader, at most %d are added", existing, options.MaxEphemeralContainers)
+	}
+
+	names := make([]string, 0, len(containers))
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, containers...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	pods := clients.KubernetesClient.CoreV1().Pods(pod.Namespace)
+	if _, err := pods.UpdateEphemeralContainers(ctx, pod.Name, pod, metav1.UpdateOptions{}); err != nil {
+		return describeMissingRBAC(err)
+	}
+
+	var lastErr error
+	err := wait.PollUntilContextCancel(ctx, rolloutPollInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			lastErr = describeMissingRBAC(err)
+			return false, nil
+		}
+
+		terminated := 0
+		for _, status := range current.Status.EphemeralContainerStatuses {
+			if !slices.Contains(names, status.Name) || status.State.Terminated == nil {
+				continue
+			}
+			if state := status.State.Terminated; state.ExitCode != 0 {
+				return false, fmt.Errorf("ephemeral container '%s' exited with code %d: %s", status.Name, state.ExitCode, strings.TrimSpace(state.Message))
+			}
+			terminated++
+		}
+		return terminated == len(names), nil
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		if lastErr != nil {
+			return fmt.Errorf("timed out after %s waiting for the ephemeral containers, last error: %w", timeout, lastErr)
+		}
+		return fmt.Errorf("timed out after %s waiting for the ephemeral containers", timeout)
+	}
+	return err
+}
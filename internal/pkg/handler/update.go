@@ -2,9 +2,11 @@ package handler
 
 import (
 	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/history"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
 	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/record"
 )
@@ -24,6 +26,7 @@ func (r ResourceUpdatedHandler) Handle() error {
 	} else {
 		config, oldSHAData := r.GetConfig()
 		if config.SHAValue != oldSHAData {
+			r.recordHistory(oldSHAData)
 			// Send a webhook if update
 			if options.WebhookUrl != "" {
 				return sendUpgradeWebhook(config, options.WebhookUrl)
@@ -35,6 +38,19 @@ func (r ResourceUpdatedHandler) Handle() error {
 	return nil
 }
 
+// recordHistory stores the previous content of a configmap with the history annotation, the change is reloaded
+// even if it could not be stored
+func (r ResourceUpdatedHandler) recordHistory(oldSHAData string) {
+	configmap, ok := r.Resource.(*v1.ConfigMap)
+	if !ok || !history.Enabled(configmap) || options.DryRun {
+		return
+	}
+	err := history.Record(kube.GetClients().KubernetesClient, r.OldResource.(*v1.ConfigMap), oldSHAData, options.HistoryLimit)
+	if err != nil {
+		logrus.Errorf("Failed to record history of configmap '%s' in namespace '%s': %v", configmap.Name, configmap.Namespace, err)
+	}
+}
+
 // GetConfig gets configurations containing SHA, annotations, namespace and resource name
 func (r ResourceUpdatedHandler) GetConfig() (util.Config, string) {
 	var oldSHAData string
@@ -42,9 +58,11 @@ func (r ResourceUpdatedHandler) GetConfig() (util.Config, string) {
 	if _, ok := r.Resource.(*v1.ConfigMap); ok {
 		oldSHAData = util.GetSHAfromConfigmap(r.OldResource.(*v1.ConfigMap))
 		config = util.GetConfigmapConfig(r.Resource.(*v1.ConfigMap))
+		config.ChangedKeys = util.GetChangedKeys(util.GetKeySHAsFromConfigmap(r.OldResource.(*v1.ConfigMap)), util.GetKeySHAsFromConfigmap(r.Resource.(*v1.ConfigMap)))
 	} else if _, ok := r.Resource.(*v1.Secret); ok {
-		oldSHAData = util.GetSHAfromSecret(r.OldResource.(*v1.Secret).Data)
+		oldSHAData = util.GetSHAfromSecret(r.OldResource.(*v1.Secret))
 		config = util.GetSecretConfig(r.Resource.(*v1.Secret))
+		config.ChangedKeys = util.GetChangedKeys(util.GetKeySHAsFromSecret(r.OldResource.(*v1.Secret)), util.GetKeySHAsFromSecret(r.Resource.(*v1.Secret)))
 	} else {
 		logrus.Warnf("Invalid resource: Resource should be 'Secret' or 'Configmap' but found, %v", r.Resource)
 	}
@@ -1,24 +1,25 @@
 package handler
 
 import (
-	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/parnurzeal/gorequest"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	alert "github.com/stakater/Reloader/internal/pkg/alerts"
 	"github.com/stakater/Reloader/internal/pkg/callbacks"
 	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/index"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/policy"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
 	v1 "k8s.io/api/core/v1"
@@ -49,6 +50,24 @@ func GetDeploymentRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	}
 }
 
+// GetDeploymentConfigRollingUpgradeFuncs returns all callback funcs for a deploymentConfig
+func GetDeploymentConfigRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
+	return callbacks.RollingUpgradeFuncs{
+		ItemFunc:           callbacks.GetDeploymentConfigItem,
+		ItemsFunc:          callbacks.GetDeploymentConfigItems,
+		AnnotationsFunc:    callbacks.GetDeploymentConfigAnnotations,
+		PodAnnotationsFunc: callbacks.GetDeploymentConfigPodAnnotations,
+		ContainersFunc:     callbacks.GetDeploymentConfigContainers,
+		InitContainersFunc: callbacks.GetDeploymentConfigInitContainers,
+		UpdateFunc:         callbacks.UpdateDeploymentConfig,
+		PatchFunc:          callbacks.PatchDeploymentConfig,
+		PatchTemplatesFunc: callbacks.GetPatchTemplates,
+		VolumesFunc:        callbacks.GetDeploymentConfigVolumes,
+		ResourceType:       "DeploymentConfig",
+		SupportsPatch:      true,
+	}
+}
+
 // GetDeploymentRollingUpgradeFuncs returns all callback funcs for a cronjob
 func GetCronJobCreateJobFuncs() callbacks.RollingUpgradeFuncs {
 	return callbacks.RollingUpgradeFuncs{
@@ -139,93 +158,128 @@ func GetArgoRolloutRollingUpgradeFuncs() callbacks.RollingUpgradeFuncs {
 	}
 }
 
-func sendUpgradeWebhook(config util.Config, webhookUrl string) error {
-	logrus.Infof("Changes detected in '%s' of type '%s' in namespace '%s', Sending webhook to '%s'",
-		config.ResourceName, config.Type, config.Namespace, webhookUrl)
+// GetCustomWorkloadRollingUpgradeFuncs returns all callback funcs for a custom workload
+func GetCustomWorkloadRollingUpgradeFuncs(workload callbacks.CustomWorkload) callbacks.RollingUpgradeFuncs {
+	return callbacks.RollingUpgradeFuncs{
+		ItemFunc:           workload.GetItem,
+		ItemsFunc:          workload.GetItems,
+		AnnotationsFunc:    callbacks.GetCustomWorkloadAnnotations,
+		PodAnnotationsFunc: callbacks.GetCustomWorkloadPodAnnotations,
+		ContainersFunc:     callbacks.GetCustomWorkloadContainers,
+		InitContainersFunc: callbacks.GetCustomWorkloadInitContainers,
+		UpdateFunc:         workload.Update,
+		PatchFunc:          workload.Patch,
+		PatchTemplatesFunc: func() callbacks.PatchTemplates { return callbacks.PatchTemplates{} },
+		VolumesFunc:        callbacks.GetCustomWorkloadVolumes,
+		ResourceType:       workload.ResourceType(),
+		SupportsPatch:      false,
+	}
+}
 
-	body, errs := sendWebhook(webhookUrl)
-	if errs != nil {
-		// return the first error
-		return errs[0]
-	} else {
-		logrus.Info(body)
+// getRollingUpgradeFuncs returns the callback funcs of all workload kinds to reload, in the order they are reloaded
+func getRollingUpgradeFuncs() ([]callbacks.RollingUpgradeFuncs, error) {
+	upgradeFuncs := []callbacks.RollingUpgradeFuncs{
+		GetDeploymentRollingUpgradeFuncs(),
+		GetCronJobCreateJobFuncs(),
+		GetJobCreateJobFuncs(),
+		GetDaemonSetRollingUpgradeFuncs(),
+		GetStatefulSetRollingUpgradeFuncs(),
 	}
 
-	return nil
-}
+	if kube.IsOpenshift {
+		upgradeFuncs = append(upgradeFuncs, GetDeploymentConfigRollingUpgradeFuncs())
+	}
 
-func sendWebhook(url string) (string, []error) {
-	request := gorequest.New()
-	resp, _, err := request.Post(url).Send(`{"webhook":"update successful"}`).End()
+	if options.IsArgoRollouts == "true" {
+		upgradeFuncs = append(upgradeFuncs, GetArgoRolloutRollingUpgradeFuncs())
+	}
+
+	// Flags are validated on startup so parsing is not expected to fail here
+	workloads, err := callbacks.ParseCustomWorkloads(options.CustomWorkloads)
 	if err != nil {
-		// the reloader seems to retry automatically so no retry logic added
-		return "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-	var buffer bytes.Buffer
-	_, bufferErr := io.Copy(&buffer, resp.Body)
-	if bufferErr != nil {
-		logrus.Error(bufferErr)
+	for _, workload := range workloads {
+		upgradeFuncs = append(upgradeFuncs, GetCustomWorkloadRollingUpgradeFuncs(workload))
 	}
-	return buffer.String(), nil
+
+	return upgradeFuncs, nil
 }
 
 func doRollingUpgrade(config util.Config, collectors metrics.Collectors, recorder record.EventRecorder, invoke invokeStrategy) error {
 	clients := kube.GetClients()
 
-	err := rollingUpgrade(clients, config, GetDeploymentRollingUpgradeFuncs(), collectors, recorder, invoke)
+	upgradeFuncs, err := getRollingUpgradeFuncs()
 	if err != nil {
 		return err
 	}
-	err = rollingUpgrade(clients, config, GetCronJobCreateJobFuncs(), collectors, recorder, invoke)
-	if err != nil {
-		return err
+
+	// All targets of the change are reloaded in one plan so dependencies between workloads of different kinds are respected
+	targets := getTargets(clients, config, upgradeFuncs)
+	err = performPlan(clients, config, collectors, recorder, invoke, targets)
+	if err != nil && !isRequeue(err) {
+		logrus.Errorf("Rolling upgrade for '%s' failed with error = %v", config.ResourceName, err)
 	}
-	err = rollingUpgrade(clients, config, GetJobCreateJobFuncs(), collectors, recorder, invoke)
-	if err != nil {
-		return err
+	return err
+}
+
+// getTargets returns the workloads of all types to evaluate for the change
+func getTargets(clients kube.Clients, config util.Config, upgradeFuncs []callbacks.RollingUpgradeFuncs) []reloadTarget {
+	var targets []reloadTarget
+	for _, funcs := range upgradeFuncs {
+		for _, item := range getItems(clients, config, funcs) {
+			targets = append(targets, reloadTarget{upgradeFuncs: funcs, item: item})
+		}
 	}
-	err = rollingUpgrade(clients, config, GetDaemonSetRollingUpgradeFuncs(), collectors, recorder, invoke)
-	if err != nil {
-		return err
+	return targets
+}
+
+// PerformAction invokes the deployment if there is any change in configmap or secret data
+func PerformAction(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy) error {
+	items := getItems(clients, config, upgradeFuncs)
+	targets := make([]reloadTarget, 0, len(items))
+	for _, item := range items {
+		targets = append(targets, reloadTarget{upgradeFuncs: upgradeFuncs, item: item})
 	}
-	err = rollingUpgrade(clients, config, GetStatefulSetRollingUpgradeFuncs(), collectors, recorder, invoke)
-	if err != nil {
-		return err
+	return performPlan(clients, config, collectors, recorder, strategy, targets)
+}
+
+// reloadItem reloads the workload for the change unless the reload is deferred or debounced, reloaded is true if the
+// workload was updated right away
+func reloadItem(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy, namespace *v1.Namespace, item runtime.Object) (reloaded bool, err error) {
+	if reason := getDeferralReason(namespace, upgradeFuncs, item, time.Now()); reason != "" {
+		return false, deferReload(clients, config, upgradeFuncs, recorder, strategy, item, reason)
 	}
 
-	if options.IsArgoRollouts == "true" {
-		err = rollingUpgrade(clients, config, GetArgoRolloutRollingUpgradeFuncs(), collectors, recorder, invoke)
-		if err != nil {
-			return err
-		}
+	if window := getDebounceWindow(upgradeFuncs, config, item); window > 0 {
+		return false, debounceReload(clients, config, upgradeFuncs, collectors, recorder, strategy, item, window)
 	}
 
-	return nil
+	err = retryOnConflict(retry.DefaultRetry, func(fetchResource bool) error {
+		var err error
+		reloaded, err = upgradeResource(clients, config, upgradeFuncs, collectors, recorder, strategy, item, fetchResource)
+		return err
+	})
+	return reloaded, err
 }
 
-func rollingUpgrade(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy) error {
-	err := PerformAction(clients, config, upgradeFuncs, collectors, recorder, strategy)
-	if err != nil {
-		logrus.Errorf("Rolling upgrade for '%s' failed with error = %v", config.ResourceName, err)
+// getItems returns the workloads to evaluate for the change, from the workload index if it is available
+func getItems(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs) []runtime.Object {
+	// Workloads selected by a policy are not indexed by the reference, the lookup is skipped if the namespace has policies
+	if len(policy.Policies(config.Namespace)) > 0 {
+		return upgradeFuncs.ItemsFunc(clients, config.Namespace)
 	}
-	return err
-}
 
-// PerformAction invokes the deployment if there is any change in configmap or secret data
-func PerformAction(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy) error {
-	items := upgradeFuncs.ItemsFunc(clients, config.Namespace)
+	items, indexed := index.Lookup(upgradeFuncs.ResourceType, config.Namespace, config.Type, config.ResourceName)
+	if !indexed {
+		return upgradeFuncs.ItemsFunc(clients, config.Namespace)
+	}
 
+	// Ensure we always have pod annotations to add to, as done by the ItemsFunc
 	for _, item := range items {
-		err := retryOnConflict(retry.DefaultRetry, func(fetchResource bool) error {
-			return upgradeResource(clients, config, upgradeFuncs, collectors, recorder, strategy, item, fetchResource)
-		})
-		if err != nil {
-			return err
-		}
+		upgradeFuncs.PodAnnotationsFunc(item)
 	}
-
-	return nil
+	return items
 }
 
 func retryOnConflict(backoff wait.Backoff, fn func(_ bool) error) error {
@@ -250,23 +304,36 @@ func retryOnConflict(backoff wait.Backoff, fn func(_ bool) error) error {
 	return err
 }
 
-func upgradeResource(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy, resource runtime.Object, fetchResource bool) error {
+func upgradeResource(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, strategy invokeStrategy, resource runtime.Object, fetchResource bool) (bool, error) {
 	accessor, err := meta.Accessor(resource)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	resourceName := accessor.GetName()
 	if fetchResource {
 		resource, err = upgradeFuncs.ItemFunc(clients, resourceName, config.Namespace)
 		if err != nil {
-			return err
+			return false, err
 		}
 	}
 
+	strategyResult := invokeStrategyForResource(upgradeFuncs, config, strategy, resource)
+	if strategyResult.Result == constants.Updated {
+		err = applyReload(clients, []util.Config{config}, upgradeFuncs, collectors, recorder, resource, resourceName, strategyResult.Patch)
+		return err == nil, err
+	}
+
+	return false, nil
+}
+
+// invokeStrategyForResource checks the annotations of the resource and invokes the strategy if it has to be reloaded
+func invokeStrategyForResource(upgradeFuncs callbacks.RollingUpgradeFuncs, config util.Config, strategy invokeStrategy, resource runtime.Object) InvokeStrategyResult {
+	strategyResult := InvokeStrategyResult{constants.NotUpdated, nil}
+
 	ignoreResourceAnnotatonValue := config.ResourceAnnotations[options.IgnoreResourceAnnotation]
 	if ignoreResourceAnnotatonValue == "true" {
-		return nil
+		return strategyResult
 	}
 
 	// find correct annotation and update the resource
@@ -299,11 +366,10 @@ func upgradeResource(clients kube.Clients, config util.Config, upgradeFuncs call
 		}
 	}
 
-	if isResourceExcluded {
-		return nil
+	if isResourceExcluded || !changesConsumedKeys(upgradeFuncs, resource, config) {
+		return strategyResult
 	}
 
-	strategyResult := InvokeStrategyResult{constants.NotUpdated, nil}
 	reloaderEnabled, _ := strconv.ParseBool(reloaderEnabledValue)
 	typedAutoAnnotationEnabled, _ := strconv.ParseBool(typedAutoAnnotationEnabledValue)
 	if reloaderEnabled || typedAutoAnnotationEnabled || reloaderEnabledValue == "" && typedAutoAnnotationEnabledValue == "" && options.AutoReloadAll {
@@ -330,48 +396,170 @@ func upgradeResource(clients kube.Clients, config util.Config, upgradeFuncs call
 			strategyResult = strategy(upgradeFuncs, resource, config, true)
 		}
 	}
-	if strategyResult.Result == constants.Updated {
-		var err error
-		if upgradeFuncs.SupportsPatch && strategyResult.Patch != nil {
-			err = upgradeFuncs.PatchFunc(clients, config.Namespace, resource, strategyResult.Patch.Type, strategyResult.Patch.Bytes)
-		} else {
-			err = upgradeFuncs.UpdateFunc(clients, config.Namespace, resource)
+
+	// Workloads explicitly opting out with the auto annotation are not reloaded by policies
+	if strategyResult.Result != constants.Updated && reloaderEnabledValue != "false" {
+		strategyResult = invokePolicyStrategy(upgradeFuncs, config, strategy, resource)
+	}
+
+	return strategyResult
+}
+
+// invokePolicyStrategy invokes the strategy if a ReloadPolicy selects the resource and the changed configmap or secret
+func invokePolicyStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, config util.Config, strategy invokeStrategy, resource runtime.Object) InvokeStrategyResult {
+	accessor, err := meta.Accessor(resource)
+	if err != nil {
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+
+	reloadPolicy, byName := policy.Match(upgradeFuncs.ResourceType, accessor.GetLabels(), config)
+	if reloadPolicy == nil {
+		return InvokeStrategyResult{constants.NotUpdated, nil}
+	}
+	if reloadPolicy.Spec.ReloadStrategy != "" {
+		config.ReloadStrategy = reloadPolicy.Spec.ReloadStrategy
+	}
+
+	// Sources listed by name are handled like the named reload annotations, other sources have to be referenced
+	// by the workload like with the auto annotation
+	return strategy(upgradeFuncs, resource, config, !byName)
+}
+
+// applyReload patches or updates the resource changed by the strategy for the given configs and records the result,
+// the patch is only used for a single config as the changes for multiple configs are applied with an update
+func applyReload(clients kube.Clients, configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string, patch *Patch) error {
+	reportInvalidReloadStrategy(upgradeFuncs, recorder, resource, resourceName, configs[0].Namespace)
+
+	if options.DryRun {
+		reportDryRun(configs, upgradeFuncs, collectors, recorder, resource, resourceName)
+		return nil
+	}
+
+	// Reloads need a free rollout slot and their turn in the stagger, the slot is held until the rollout finished.
+	// Otherwise the change is queued again so the worker can process other changes meanwhile
+	release, err := reloadLimiter.tryAcquire(fmt.Sprintf("%s/%s/%s", upgradeFuncs.ResourceType, configs[0].Namespace, resourceName))
+	if err != nil {
+		logrus.Infof("Not reloading '%s' of type '%s' in namespace '%s' yet: %v", resourceName, upgradeFuncs.ResourceType, configs[0].Namespace, err)
+		return err
+	}
+
+	// Workloads reloaded for some keys record their hash, so catching up does not reload them for other keys
+	consumedHashes, recordConsumed := getConsumedHashesUpdate(upgradeFuncs, resource, configs)
+	if upgradeFuncs.SupportsPatch && patch != nil && len(configs) == 1 {
+		err = upgradeFuncs.PatchFunc(clients, configs[0].Namespace, resource, patch.Type, patch.Bytes)
+		if err == nil && recordConsumed {
+			recordConsumedHashes(clients, upgradeFuncs, resource, configs[0].Namespace, resourceName, consumedHashes)
 		}
+	} else {
+		if recordConsumed {
+			setConsumedHashes(resource, consumedHashes)
+		}
+		err = upgradeFuncs.UpdateFunc(clients, configs[0].Namespace, resource)
+	}
 
-		if err != nil {
-			message := fmt.Sprintf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, config.Namespace, err)
-			logrus.Errorf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, config.Namespace, err)
+	namespace := configs[0].Namespace
+	sources := describeReloadSources(configs)
+	if err != nil {
+		message := fmt.Sprintf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, namespace, err)
+		logrus.Errorf("Update for '%s' of type '%s' in namespace '%s' failed with error %v", resourceName, upgradeFuncs.ResourceType, namespace, err)
+		release()
+
+		collectors.Reloaded.With(prometheus.Labels{"success": "false"}).Inc()
+		collectors.ReloadedByNamespace.With(prometheus.Labels{"success": "false", "namespace": namespace}).Inc()
+		if recorder != nil {
+			recorder.Event(resource, v1.EventTypeWarning, "ReloadFail", message)
+		}
+		alert.SendFailureAlert(fmt.Sprintf(
+			"Reloader detected changes in %s in namespace *%s* but failed to reload *%s* of type *%s* in namespace *%s*: %v",
+			describeAlertSources(configs), namespace, resourceName, upgradeFuncs.ResourceType, namespace, err))
+		return err
+	}
 
-			collectors.Reloaded.With(prometheus.Labels{"success": "false"}).Inc()
-			collectors.ReloadedByNamespace.With(prometheus.Labels{"success": "false", "namespace": config.Namespace}).Inc()
-			if recorder != nil {
-				recorder.Event(resource, v1.EventTypeWarning, "ReloadFail", message)
-			}
-			return err
-		} else {
-			message := fmt.Sprintf("Changes detected in '%s' of type '%s' in namespace '%s'", config.ResourceName, config.Type, config.Namespace)
-			message += fmt.Sprintf(", Updated '%s' of type '%s' in namespace '%s'", resourceName, upgradeFuncs.ResourceType, config.Namespace)
-
-			logrus.Infof("Changes detected in '%s' of type '%s' in namespace '%s'; updated '%s' of type '%s' in namespace '%s'", config.ResourceName, config.Type, config.Namespace, resourceName, upgradeFuncs.ResourceType, config.Namespace)
-
-			collectors.Reloaded.With(prometheus.Labels{"success": "true"}).Inc()
-			collectors.ReloadedByNamespace.With(prometheus.Labels{"success": "true", "namespace": config.Namespace}).Inc()
-			alert_on_reload, ok := os.LookupEnv("ALERT_ON_RELOAD")
-			if recorder != nil {
-				recorder.Event(resource, v1.EventTypeNormal, "Reloaded", message)
-			}
-			if ok && alert_on_reload == "true" {
-				msg := fmt.Sprintf(
-					"Reloader detected changes in *%s* of type *%s* in namespace *%s*. Hence reloaded *%s* of type *%s* in namespace *%s*",
-					config.ResourceName, config.Type, config.Namespace, resourceName, upgradeFuncs.ResourceType, config.Namespace)
-				alert.SendWebhookAlert(msg)
-			}
+	message := fmt.Sprintf("Changes detected in %s in namespace '%s'", sources, namespace)
+	message += fmt.Sprintf(", Updated '%s' of type '%s' in namespace '%s'", resourceName, upgradeFuncs.ResourceType, namespace)
+
+	logrus.Infof("Changes detected in %s in namespace '%s'; updated '%s' of type '%s' in namespace '%s'", sources, namespace, resourceName, upgradeFuncs.ResourceType, namespace)
+
+	collectors.Reloaded.With(prometheus.Labels{"success": "true"}).Inc()
+	collectors.ReloadedByNamespace.With(prometheus.Labels{"success": "true", "namespace": namespace}).Inc()
+	alert_on_reload, ok := os.LookupEnv("ALERT_ON_RELOAD")
+	if recorder != nil {
+		recorder.Event(resource, v1.EventTypeNormal, "Reloaded", message)
+	}
+	routing := getAlertRouting(upgradeFuncs, configs, resource)
+	if ok && alert_on_reload == "true" || routing != nil && routing.OnReload {
+		msg := fmt.Sprintf(
+			"Reloader detected changes in %s in namespace *%s*. Hence reloaded *%s* of type *%s* in namespace *%s*",
+			describeAlertSources(configs), namespace, resourceName, upgradeFuncs.ResourceType, namespace)
+		reloadAlert := alert.Alert{Message: msg, Severity: alert.SeverityInfo}
+		if routing != nil {
+			reloadAlert.Sinks = routing.Sinks
 		}
+		alert.Send(reloadAlert)
+	}
+	if signaled := getSignaledConfigs(upgradeFuncs, resource, configs); len(signaled) > 0 {
+		go reloadInPlace(clients, signaled, upgradeFuncs, collectors, recorder, resource.DeepCopyObject(), resourceName)
+	}
+	if options.WaitForRollout || limitsRollouts() {
+		reloadRolloutWatcher.watch(clients, configs, upgradeFuncs, collectors, recorder, resource, resourceName, release)
+	} else {
+		release()
 	}
 
 	return nil
 }
 
+// getAlertRouting returns the alert routing of the first ReloadPolicy selecting the resource for one of the configs
+func getAlertRouting(upgradeFuncs callbacks.RollingUpgradeFuncs, configs []util.Config, resource runtime.Object) *policy.AlertRouting {
+	accessor, err := meta.Accessor(resource)
+	if err != nil {
+		return nil
+	}
+	for _, config := range configs {
+		if reloadPolicy, _ := policy.Match(upgradeFuncs.ResourceType, accessor.GetLabels(), config); reloadPolicy != nil {
+			return reloadPolicy.Spec.Alert
+		}
+	}
+	return nil
+}
+
+// reportDryRun logs, records and counts the reload that would have been performed for the given configs
+func reportDryRun(configs []util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder, resource runtime.Object, resourceName string) {
+	namespace := configs[0].Namespace
+	message := fmt.Sprintf("Dry run: changes detected in %s in namespace '%s', would update '%s' of type '%s' in namespace '%s'",
+		describeReloadSources(configs), namespace, resourceName, upgradeFuncs.ResourceType, namespace)
+	logrus.Info(message)
+
+	for _, config := range configs {
+		collectors.DryRunReloads.With(prometheus.Labels{
+			"namespace":     namespace,
+			"workload_type": upgradeFuncs.ResourceType,
+			"workload":      resourceName,
+			"source_type":   config.Type,
+			"source":        config.ResourceName,
+		}).Inc()
+	}
+	if recorder != nil {
+		recorder.Event(resource, v1.EventTypeNormal, "ReloadDryRun", message)
+	}
+}
+
+func describeReloadSources(configs []util.Config) string {
+	sources := make([]string, len(configs))
+	for i, config := range configs {
+		sources[i] = fmt.Sprintf("'%s' of type '%s'", config.ResourceName, config.Type)
+	}
+	return strings.Join(sources, ", ")
+}
+
+func describeAlertSources(configs []util.Config) string {
+	sources := make([]string, len(configs))
+	for i, config := range configs {
+		sources[i] = fmt.Sprintf("*%s* of type *%s*", config.ResourceName, config.Type)
+	}
+	return strings.Join(sources, ", ")
+}
+
 func checkIfResourceIsExcluded(resourceName, excludedResources string) bool {
 	if excludedResources == "" {
 		return false
@@ -510,12 +698,60 @@ type InvokeStrategyResult struct {
 type invokeStrategy func(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult
 
 func invokeReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
-	if options.ReloadStrategy == constants.AnnotationsReloadStrategy {
+	switch getWorkloadReloadStrategy(upgradeFuncs, item, config) {
+	case constants.AnnotationsReloadStrategy:
 		return updatePodAnnotations(upgradeFuncs, item, config, autoReload)
+	case constants.SignalReloadStrategy:
+		return updateSignaledAnnotation(upgradeFuncs, item, config, autoReload)
+	case constants.RestartReloadStrategy:
+		return updateRestartedAtAnnotation(upgradeFuncs, item, config, autoReload)
 	}
 	return updateContainerEnvVars(upgradeFuncs, item, config, autoReload)
 }
 
+// reloadStrategies are the strategies a workload can select through the reload strategy annotation
+var reloadStrategies = []string{constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, constants.SignalReloadStrategy, constants.RestartReloadStrategy}
+
+// getWorkloadReloadStrategy returns the reload strategy of the change for the workload. A ReloadPolicy takes
+// precedence over the reload strategy annotation of the workload, workloads with a reload hook are reloaded in place
+// and all others use the global strategy
+func getWorkloadReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config) string {
+	if config.ReloadStrategy != "" {
+		return config.ReloadStrategy
+	}
+	if strategy := getWorkloadAnnotation(upgradeFuncs, item, options.ReloadStrategyAnnotation); slices.Contains(reloadStrategies, strategy) {
+		return strategy
+	}
+	if getWorkloadAnnotation(upgradeFuncs, item, options.ReloadHookAnnotation) != "" {
+		return constants.SignalReloadStrategy
+	}
+	return options.ReloadStrategy
+}
+
+// getWorkloadAnnotation returns the value of the annotation of the workload or its pod template
+func getWorkloadAnnotation(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, annotation string) string {
+	if value := upgradeFuncs.AnnotationsFunc(item)[annotation]; value != "" {
+		return value
+	}
+	return upgradeFuncs.PodAnnotationsFunc(item)[annotation]
+}
+
+// reportInvalidReloadStrategy records a warning on the workload if its reload strategy annotation is invalid, the
+// workload is reloaded with the strategy it would use without the annotation
+func reportInvalidReloadStrategy(upgradeFuncs callbacks.RollingUpgradeFuncs, recorder record.EventRecorder, resource runtime.Object, resourceName string, namespace string) {
+	strategy := getWorkloadAnnotation(upgradeFuncs, resource, options.ReloadStrategyAnnotation)
+	if strategy == "" || slices.Contains(reloadStrategies, strategy) {
+		return
+	}
+
+	message := fmt.Sprintf("Invalid value '%s' for annotation '%s' of '%s' of type '%s' in namespace '%s', expected one of: %s",
+		strategy, options.ReloadStrategyAnnotation, resourceName, upgradeFuncs.ResourceType, namespace, strings.Join(reloadStrategies, ", "))
+	logrus.Warn(message)
+	if recorder != nil {
+		recorder.Event(resource, v1.EventTypeWarning, "InvalidReloadStrategy", message)
+	}
+}
+
 func updatePodAnnotations(upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, config util.Config, autoReload bool) InvokeStrategyResult {
 	container := getContainerUsingResource(upgradeFuncs, item, config, autoReload)
 	if container == nil {
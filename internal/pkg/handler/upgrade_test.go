@@ -2,32 +2,60 @@ package handler
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	fakeopenshiftclientset "github.com/openshift/client-go/apps/clientset/versioned/fake"
 	"github.com/prometheus/client_golang/prometheus"
 	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 	"github.com/stakater/Reloader/internal/pkg/callbacks"
 	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/history"
 	"github.com/stakater/Reloader/internal/pkg/metrics"
 	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/policy"
 	"github.com/stakater/Reloader/internal/pkg/testutil"
 	"github.com/stakater/Reloader/internal/pkg/util"
 	"github.com/stakater/Reloader/pkg/kube"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	patchtypes "k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	testclient "k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
-	clients = kube.Clients{KubernetesClient: testclient.NewSimpleClientset()}
+	clients = kube.Clients{
+		KubernetesClient:    testclient.NewSimpleClientset(),
+		OpenshiftAppsClient: fakeopenshiftclientset.NewSimpleClientset(),
+	}
 
 	arsNamespace                               = "test-handler-" + testutil.RandSeq(5)
 	arsConfigmapName                           = "testconfigmap-handler-" + testutil.RandSeq(5)
@@ -1034,6 +1062,12 @@ func setupErs() {
 		logrus.Errorf("Error in Deployment with configmap and with configmap exclude annotation: %v", err)
 	}
 
+	// Creating DeploymentConfig with configmap
+	_, err = testutil.CreateDeploymentConfig(clients.OpenshiftAppsClient, ersConfigmapName, ersNamespace, true)
+	if err != nil {
+		logrus.Errorf("Error in DeploymentConfig with configmap creation: %v", err)
+	}
+
 	// Creating DaemonSet with configmap
 	_, err = testutil.CreateDaemonSet(clients.KubernetesClient, ersConfigmapName, ersNamespace, true)
 	if err != nil {
@@ -1246,6 +1280,12 @@ func teardownErs() {
 		logrus.Errorf("Error while deleting deployment with configmap exclude annotation %v", deploymentError)
 	}
 
+	// Deleting DeploymentConfig with configmap
+	deploymentConfigError := testutil.DeleteDeploymentConfig(clients.OpenshiftAppsClient, ersNamespace, ersConfigmapName)
+	if deploymentConfigError != nil {
+		logrus.Errorf("Error while deleting deploymentConfig with configmap %v", deploymentConfigError)
+	}
+
 	// Deleting DaemonSet with configmap
 	daemonSetError := testutil.DeleteDaemonSet(clients.KubernetesClient, ersNamespace, ersConfigmapName)
 	if daemonSetError != nil {
@@ -3596,6 +3636,34 @@ func TestRollingUpgradeForDeploymentWithConfigMapAutoAnnotationUsingErs(t *testi
 	testRollingUpgradeInvokeDeleteStrategyErs(t, clients, config, deploymentFuncs, collectors, envVarPostfix)
 }
 
+func TestRollingUpgradeForDeploymentConfigWithConfigmapUsingErs(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	envVarPostfix := constants.ConfigmapEnvVarPostfix
+
+	shaData := testutil.ConvertResourceToSHA(testutil.ConfigmapResourceType, ersNamespace, ersConfigmapName, "www.openshift.com")
+	config := getConfigWithAnnotations(envVarPostfix, ersConfigmapName, shaData, options.ConfigmapUpdateOnChangeAnnotation, options.ConfigmapReloaderAutoAnnotation)
+	deploymentConfigFuncs := GetDeploymentConfigRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	err := PerformAction(clients, config, deploymentConfigFuncs, collectors, nil, invokeReloadStrategy)
+	time.Sleep(5 * time.Second)
+	if err != nil {
+		t.Errorf("Rolling upgrade failed for DeploymentConfig with configmap")
+	}
+
+	logrus.Infof("Verifying deploymentConfig update")
+	updated := testutil.VerifyResourceEnvVarUpdate(clients, config, envVarPostfix, deploymentConfigFuncs)
+	if !updated {
+		t.Errorf("DeploymentConfig was not updated")
+	}
+
+	if promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) != 1 {
+		t.Errorf("Counter was not increased")
+	}
+
+	testRollingUpgradeInvokeDeleteStrategyErs(t, clients, config, deploymentConfigFuncs, collectors, envVarPostfix)
+}
+
 func TestRollingUpgradeForDaemonSetWithConfigmapUsingErs(t *testing.T) {
 	options.ReloadStrategy = constants.EnvVarsReloadStrategy
 	envVarPostfix := constants.ConfigmapEnvVarPostfix
@@ -4053,3 +4121,1755 @@ func TestFailedRollingUpgradeUsingErs(t *testing.T) {
 		t.Errorf("Counter by namespace was not increased")
 	}
 }
+
+func TestGetDebounceWindow(t *testing.T) {
+	defer func() { options.DebounceWindow = 0 }()
+	options.DebounceWindow = 10 * time.Second
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    time.Duration
+	}{
+		{
+			name:     "Global window",
+			expected: 10 * time.Second,
+		},
+		{
+			name:        "Annotation overrides global window",
+			annotations: map[string]string{options.DebounceWindowAnnotation: "2s"},
+			expected:    2 * time.Second,
+		},
+		{
+			name:        "Annotation disables debouncing",
+			annotations: map[string]string{options.DebounceWindowAnnotation: "0s"},
+			expected:    0,
+		},
+		{
+			name:        "Invalid annotation uses global window",
+			annotations: map[string]string{options.DebounceWindowAnnotation: "soon"},
+			expected:    10 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := testutil.GetDeployment(ersNamespace, "debounce")
+			deployment.Annotations = tt.annotations
+			assert.Equal(t, tt.expected, getDebounceWindow(deploymentFuncs, util.Config{Namespace: ersNamespace}, deployment))
+		})
+	}
+}
+
+func TestDebouncedRollingUpgradeUsingErs(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	deploymentName := "debounced-deployment-" + testutil.RandSeq(5)
+	configmapName := "debounced-configmap-" + testutil.RandSeq(5)
+	secretName := "debounced-secret-" + testutil.RandSeq(5)
+
+	deployment := testutil.GetDeployment(ersNamespace, deploymentName)
+	deployment.Annotations = map[string]string{
+		options.ReloaderAutoAnnotation:   "true",
+		options.DebounceWindowAnnotation: "1s",
+	}
+	deployment.Spec.Template.Spec.Containers[0].EnvFrom = []v1.EnvFromSource{
+		{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}}},
+		{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: secretName}}},
+	}
+	_, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+	}()
+
+	configmapConfig := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, configmapName, "configmap-sha-1", options.ConfigmapUpdateOnChangeAnnotation, options.ConfigmapReloaderAutoAnnotation)
+	secretConfig := getConfigWithAnnotations(constants.SecretEnvVarPostfix, secretName, "secret-sha", options.SecretUpdateOnChangeAnnotation, options.SecretReloaderAutoAnnotation)
+	updatedConfigmapConfig := configmapConfig
+	updatedConfigmapConfig.SHAValue = "configmap-sha-2"
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+
+	for _, config := range []util.Config{configmapConfig, secretConfig, updatedConfigmapConfig} {
+		err = PerformAction(clients, config, deploymentFuncs, collectors, nil, invokeReloadStrategy)
+		assert.NoError(t, err)
+	}
+
+	current, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, getEnvVarName(configmapName, constants.ConfigmapEnvVarPostfix)), "Deployment was updated before the debounce window expired")
+
+	assert.Eventually(t, func() bool {
+		return promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)) == 1
+	}, 5*time.Second, 100*time.Millisecond, "Deployment was not reloaded once")
+
+	current, err = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	envs := current.Spec.Template.Spec.Containers[0].Env
+	assert.Equal(t, "configmap-sha-2", getEnvVarValue(envs, getEnvVarName(configmapName, constants.ConfigmapEnvVarPostfix)))
+	assert.Equal(t, "secret-sha", getEnvVarValue(envs, getEnvVarName(secretName, constants.SecretEnvVarPostfix)))
+}
+
+func TestDebouncedReloadRetries(t *testing.T) {
+	defer func(maxRetries int, baseDelay time.Duration) {
+		options.MaxRetries, options.RetryBaseDelay = maxRetries, baseDelay
+	}(options.MaxRetries, options.RetryBaseDelay)
+	options.MaxRetries = 2
+	options.RetryBaseDelay = 10 * time.Millisecond
+
+	debouncer := newDebouncer()
+	key := "Deployment/" + ersNamespace + "/missing"
+	failed := &pendingReload{
+		clients:      clients,
+		upgradeFuncs: GetDeploymentRollingUpgradeFuncs(),
+		collectors:   getCollectors(),
+		namespace:    ersNamespace,
+		name:         "missing",
+		changes:      []pendingChange{{config: util.Config{Type: constants.ConfigmapEnvVarPostfix, ResourceName: "old"}, strategy: invokeReloadStrategy}},
+	}
+
+	// A change added before the retry is applied with the failed changes
+	debouncer.pending[key] = &pendingReload{changes: []pendingChange{{config: util.Config{Type: constants.ConfigmapEnvVarPostfix, ResourceName: "new"}}}}
+	debouncer.retry(key, failed, errors.NewNotFound(schema.GroupResource{Resource: "deployments"}, "missing"))
+	assert.Len(t, debouncer.pending[key].changes, 2)
+	assert.Equal(t, 0, failed.retries)
+
+	// The failed reload is retried until it is dropped
+	debouncer.pending[key] = failed
+	debouncer.flush(key)
+	assert.Eventually(t, func() bool {
+		debouncer.mu.Lock()
+		defer debouncer.mu.Unlock()
+		_, found := debouncer.pending[key]
+		return failed.retries == 2 && !found
+	}, 5*time.Second, 10*time.Millisecond, "Failed reload was not retried until it was dropped")
+}
+
+func TestDebouncedReloadSchedule(t *testing.T) {
+	debouncer := newDebouncer()
+	waiting := &pendingReload{
+		upgradeFuncs: GetDeploymentRollingUpgradeFuncs(),
+		namespace:    ersNamespace,
+		name:         "waiting",
+		changes:      []pendingChange{{config: util.Config{Type: constants.ConfigmapEnvVarPostfix, ResourceName: "old", ChangedKeys: []string{"a"}}}},
+	}
+	key := waiting.key()
+
+	// Waiting for a rollout slot is not counted as a retry
+	debouncer.schedule(waiting, time.Hour)
+	assert.Same(t, waiting, debouncer.pending[key])
+	assert.Equal(t, 0, waiting.retries)
+
+	// A later change of the same configmap keeps its content, the changed keys of both are applied
+	debouncer.pending[key] = &pendingReload{changes: []pendingChange{{config: util.Config{Type: constants.ConfigmapEnvVarPostfix, ResourceName: "old", ChangedKeys: []string{"b"}, SHAValue: "new"}}}}
+	debouncer.schedule(waiting, time.Hour)
+	changes := debouncer.pending[key].changes
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "new", changes[0].config.SHAValue)
+	assert.Equal(t, []string{"a", "b"}, changes[0].config.ChangedKeys)
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	defer func(baseDelay time.Duration, maxDelay time.Duration) {
+		options.RetryBaseDelay, options.RetryMaxDelay = baseDelay, maxDelay
+	}(options.RetryBaseDelay, options.RetryMaxDelay)
+	options.RetryBaseDelay = time.Second
+	options.RetryMaxDelay = 5 * time.Second
+
+	assert.Equal(t, time.Second, getRetryDelay(1))
+	assert.Equal(t, 2*time.Second, getRetryDelay(2))
+	assert.Equal(t, 4*time.Second, getRetryDelay(3))
+	assert.Equal(t, 5*time.Second, getRetryDelay(4))
+}
+
+func getEnvVarValue(envs []v1.EnvVar, name string) string {
+	for _, env := range envs {
+		if env.Name == name {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+func TestDryRunRollingUpgradeUsingErs(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	options.DryRun = true
+	defer func() { options.DryRun = false }()
+	deploymentName := "dry-run-deployment-" + testutil.RandSeq(5)
+	configmapName := "dry-run-configmap-" + testutil.RandSeq(5)
+
+	deployment := testutil.GetDeployment(ersNamespace, deploymentName)
+	deployment.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: configmapName}
+	_, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+	}()
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, configmapName, "dry-run-sha", options.ConfigmapUpdateOnChangeAnnotation, options.ConfigmapReloaderAutoAnnotation)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	deploymentFuncs.UpdateFunc = func(kube.Clients, string, runtime.Object) error {
+		t.Errorf("Deployment must not be updated in dry run mode")
+		return nil
+	}
+	deploymentFuncs.PatchFunc = func(kube.Clients, string, runtime.Object, patchtypes.PatchType, []byte) error {
+		t.Errorf("Deployment must not be patched in dry run mode")
+		return nil
+	}
+	collectors := getCollectors()
+	recorder := record.NewFakeRecorder(10)
+
+	err = PerformAction(clients, config, deploymentFuncs, collectors, recorder, invokeReloadStrategy)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(1), promtestutil.ToFloat64(collectors.DryRunReloads.With(prometheus.Labels{
+		"namespace":     ersNamespace,
+		"workload_type": "Deployment",
+		"workload":      deploymentName,
+		"source_type":   constants.ConfigmapEnvVarPostfix,
+		"source":        configmapName,
+	})))
+	assert.Equal(t, float64(0), promtestutil.ToFloat64(collectors.Reloaded.With(labelSucceeded)))
+
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "ReloadDryRun")
+		assert.Contains(t, event, deploymentName)
+	default:
+		t.Errorf("Dry run event was not recorded")
+	}
+}
+
+func TestNewWebhookPayload(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	deploymentName := "webhook-deployment-" + testutil.RandSeq(5)
+	configmapName := "webhook-configmap-" + testutil.RandSeq(5)
+
+	deployment := testutil.GetDeployment(ersNamespace, deploymentName)
+	deployment.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: configmapName}
+	_, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+	}()
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, configmapName, "webhook-sha", options.ConfigmapUpdateOnChangeAnnotation, options.ConfigmapReloaderAutoAnnotation)
+	payload, err := newWebhookPayload(clients, config)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "update successful", payload.Webhook)
+	assert.Equal(t, constants.ConfigmapEnvVarPostfix, payload.Kind)
+	assert.Equal(t, configmapName, payload.ResourceName)
+	assert.Equal(t, ersNamespace, payload.Namespace)
+	assert.Equal(t, "webhook-sha", payload.Hash)
+	assert.Equal(t, []WebhookTarget{{Kind: "Deployment", Name: deploymentName, Namespace: ersNamespace}}, payload.Targets)
+
+	// Resolving the targets must not modify the workloads
+	current, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, getEnvVarName(configmapName, constants.ConfigmapEnvVarPostfix)))
+}
+
+func TestRenderWebhookBody(t *testing.T) {
+	payload := WebhookPayload{
+		Webhook:      "update successful",
+		Kind:         constants.SecretEnvVarPostfix,
+		ResourceName: "my-secret",
+		Namespace:    "my-namespace",
+		Hash:         "sha",
+		Targets:      []WebhookTarget{{Kind: "Deployment", Name: "my-app", Namespace: "my-namespace"}},
+	}
+
+	body, err := renderWebhookBody(payload, "")
+	assert.NoError(t, err)
+	var decoded WebhookPayload
+	assert.NoError(t, json.Unmarshal(body, &decoded))
+	assert.Equal(t, payload, decoded)
+
+	templatePath := filepath.Join(t.TempDir(), "payload.tmpl")
+	err = os.WriteFile(templatePath, []byte(`{"text": "{{ .ResourceName }} changed in {{ .Namespace }}", "targets": {{ toJson .Targets }}}`), 0o600)
+	assert.NoError(t, err)
+
+	body, err = renderWebhookBody(payload, templatePath)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"text": "my-secret changed in my-namespace", "targets": [{"kind": "Deployment", "name": "my-app", "namespace": "my-namespace"}]}`, string(body))
+
+	_, err = renderWebhookBody(payload, filepath.Join(t.TempDir(), "missing.tmpl"))
+	assert.Error(t, err)
+}
+
+func TestGetWebhookHeaders(t *testing.T) {
+	body := []byte(`{"webhook":"update successful"}`)
+
+	headers, err := getWebhookHeaders([]string{"Authorization=Bearer token=with=equals", " X-Team = platform "}, body, "")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer token=with=equals", "X-Team": "platform"}, headers)
+
+	_, err = getWebhookHeaders([]string{"invalid"}, body, "")
+	assert.Error(t, err)
+
+	headers, err = getWebhookHeaders(nil, body, "secret")
+	assert.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), headers[constants.WebhookSignatureHeader])
+}
+
+func TestSendWebhook(t *testing.T) {
+	body := []byte(`{"webhook":"update successful","targets":[]}`)
+	var receivedBody []byte
+	var receivedHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedBody, _ = io.ReadAll(r.Body)
+		receivedHeaders = r.Header
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	response, errs := sendWebhook(server.URL, body, map[string]string{"X-Team": "platform"})
+	assert.Nil(t, errs)
+	assert.Equal(t, "ok", response)
+	// The body must be sent unchanged for the signature to match
+	assert.Equal(t, body, receivedBody)
+	assert.Equal(t, "platform", receivedHeaders.Get("X-Team"))
+	assert.Equal(t, "application/json", receivedHeaders.Get("Content-Type"))
+}
+
+func TestRolloutComplete(t *testing.T) {
+	replicas := int32(2)
+	partition := int32(1)
+	progressing := appsv1.DeploymentCondition{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded", Message: "deadline exceeded"}
+
+	tests := []struct {
+		name         string
+		item         runtime.Object
+		complete     bool
+		expectsError bool
+		notSupported bool
+	}{
+		{
+			name: "Deployment not observed",
+			item: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}},
+		},
+		{
+			name: "Deployment with old replicas",
+			item: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 2, AvailableReplicas: 2}},
+		},
+		{
+			name: "Deployment complete",
+			item: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2}},
+			complete: true,
+		},
+		{
+			name: "Deployment exceeded progress deadline",
+			item: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Generation: 2}, Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+				Status: appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 3, UpdatedReplicas: 1, Conditions: []appsv1.DeploymentCondition{progressing}}},
+			expectsError: true,
+		},
+		{
+			name: "StatefulSet updating",
+			item: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+				Status: appsv1.StatefulSetStatus{ReadyReplicas: 2, CurrentRevision: "1", UpdateRevision: "2"}},
+		},
+		{
+			name: "StatefulSet complete",
+			item: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+				Status: appsv1.StatefulSetStatus{ReadyReplicas: 2, CurrentRevision: "2", UpdateRevision: "2"}},
+			complete: true,
+		},
+		{
+			name: "StatefulSet partition complete",
+			item: &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: &replicas, UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType, RollingUpdate: &appsv1.RollingUpdateStatefulSetStrategy{Partition: &partition}}},
+				Status: appsv1.StatefulSetStatus{ReadyReplicas: 2, UpdatedReplicas: 1, CurrentRevision: "1", UpdateRevision: "2"}},
+			complete: true,
+		},
+		{
+			name:         "StatefulSet on delete",
+			item:         &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType}}},
+			expectsError: true,
+			notSupported: true,
+		},
+		{
+			name:     "DaemonSet complete",
+			item:     &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}},
+			complete: true,
+		},
+		{
+			name: "DaemonSet updating",
+			item: &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 2, NumberAvailable: 3}},
+		},
+		{
+			name: "Rollout not observed",
+			item: &argorolloutv1alpha1.Rollout{ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Status: argorolloutv1alpha1.RolloutStatus{ObservedGeneration: "1", Phase: argorolloutv1alpha1.RolloutPhaseHealthy}},
+		},
+		{
+			name: "Rollout healthy",
+			item: &argorolloutv1alpha1.Rollout{ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Status: argorolloutv1alpha1.RolloutStatus{ObservedGeneration: "2", Phase: argorolloutv1alpha1.RolloutPhaseHealthy}},
+			complete: true,
+		},
+		{
+			name: "Rollout degraded",
+			item: &argorolloutv1alpha1.Rollout{ObjectMeta: metav1.ObjectMeta{Generation: 2},
+				Status: argorolloutv1alpha1.RolloutStatus{ObservedGeneration: "2", Phase: argorolloutv1alpha1.RolloutPhaseDegraded}},
+			expectsError: true,
+		},
+		{
+			name:         "CronJob",
+			item:         &batchv1.CronJob{},
+			expectsError: true,
+			notSupported: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complete, err := rolloutComplete(tt.item)
+			assert.Equal(t, tt.complete, complete)
+			if tt.expectsError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			if tt.notSupported {
+				assert.ErrorIs(t, err, errRolloutNotSupported)
+			} else {
+				assert.NotErrorIs(t, err, errRolloutNotSupported)
+			}
+		})
+	}
+}
+
+func TestWatchRollout(t *testing.T) {
+	defer func(interval time.Duration, timeout time.Duration, wait bool) {
+		rolloutPollInterval = interval
+		options.RolloutTimeout = timeout
+		options.WaitForRollout = wait
+	}(rolloutPollInterval, options.RolloutTimeout, options.WaitForRollout)
+	rolloutPollInterval = 10 * time.Millisecond
+	options.RolloutTimeout = time.Second
+	options.WaitForRollout = true
+
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollout-deployment", Namespace: "rollout", Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1},
+	}
+	testClients := kube.Clients{KubernetesClient: testclient.NewSimpleClientset(deployment)}
+	configs := []util.Config{{Namespace: "rollout", ResourceName: "rollout-configmap", Type: constants.ConfigmapEnvVarPostfix}}
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+	recorder := record.NewFakeRecorder(10)
+
+	reloadRolloutWatcher.watch(testClients, configs, deploymentFuncs, collectors, recorder, deployment, deployment.Name, func() {})
+
+	deployment = deployment.DeepCopy()
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	_, err := testClients.KubernetesClient.AppsV1().Deployments("rollout").UpdateStatus(context.TODO(), deployment, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "ReloadSucceeded")
+		assert.Contains(t, event, "rollout-configmap")
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Rollout outcome was not recorded")
+	}
+	assert.Equal(t, 1, promtestutil.CollectAndCount(collectors.RolloutDuration))
+
+	// A rollout which does not complete in time is reported as failed
+	deployment.Generation = 3
+	_, err = testClients.KubernetesClient.AppsV1().Deployments("rollout").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	options.RolloutTimeout = 100 * time.Millisecond
+	reloadRolloutWatcher.watch(testClients, configs, deploymentFuncs, collectors, recorder, deployment, deployment.Name, func() {})
+
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "ReloadFailed")
+		assert.Contains(t, event, "timed out")
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Rollout outcome was not recorded")
+	}
+}
+
+func TestRolloutLimiter(t *testing.T) {
+	defer func(limit int, stagger time.Duration, jitter time.Duration) {
+		options.MaxConcurrentRollouts = limit
+		options.ReloadStagger = stagger
+		options.ReloadJitter = jitter
+	}(options.MaxConcurrentRollouts, options.ReloadStagger, options.ReloadJitter)
+	options.MaxConcurrentRollouts = 1
+	options.ReloadStagger = 0
+	options.ReloadJitter = 0
+
+	limiter := newRolloutLimiter()
+	release, err := limiter.tryAcquire("deployment/limit/first")
+	assert.NoError(t, err)
+
+	// The second reload is queued again instead of waiting for the slot
+	_, err = limiter.tryAcquire("deployment/limit/second")
+	var requeue *RequeueError
+	if assert.ErrorAs(t, err, &requeue) {
+		assert.Equal(t, slotRetryInterval, requeue.After)
+	}
+
+	// Releasing twice must not free a second slot
+	release()
+	release()
+	secondRelease, err := limiter.tryAcquire("deployment/limit/second")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, limiter.inFlight)
+	secondRelease()
+
+	// Consecutive reloads are spread out by the stagger
+	options.MaxConcurrentRollouts = 0
+	options.ReloadStagger = 100 * time.Millisecond
+	release, err = limiter.tryAcquire("deployment/limit/first")
+	assert.NoError(t, err)
+	release()
+	_, err = limiter.tryAcquire("deployment/limit/second")
+	if assert.ErrorAs(t, err, &requeue) {
+		assert.Greater(t, requeue.After, time.Duration(0))
+		assert.LessOrEqual(t, requeue.After, 100*time.Millisecond)
+	}
+}
+
+func TestRolloutLimiterPausesAfterFailedRollouts(t *testing.T) {
+	defer func(limit int, pauseAfter int, stagger time.Duration) {
+		options.MaxConcurrentRollouts = limit
+		options.PauseAfterFailedRollouts = pauseAfter
+		options.ReloadStagger = stagger
+	}(options.MaxConcurrentRollouts, options.PauseAfterFailedRollouts, options.ReloadStagger)
+	options.MaxConcurrentRollouts = 5
+	options.PauseAfterFailedRollouts = 2
+	options.ReloadStagger = 0
+
+	limiter := newRolloutLimiter()
+	limiter.fail("deployment/pause/first")
+	release, err := limiter.tryAcquire("deployment/pause/other")
+	assert.NoError(t, err, "Reloads continue below the threshold")
+	release()
+
+	limiter.fail("deployment/pause/second")
+	_, err = limiter.tryAcquire("deployment/pause/other")
+	assert.True(t, isRequeue(err), "Reloads are paused once the threshold is reached")
+	assert.ErrorContains(t, err, "'deployment/pause/first', 'deployment/pause/second'")
+
+	// A failed workload can still be reloaded so a fix rolls out
+	release, err = limiter.tryAcquire("deployment/pause/first")
+	assert.NoError(t, err)
+	release()
+
+	limiter.recovered("deployment/pause/second")
+	release, err = limiter.tryAcquire("deployment/pause/other")
+	assert.NoError(t, err, "Reloads resume once a failed workload recovered")
+	release()
+}
+
+func TestFailedRolloutPausesUntilRecovered(t *testing.T) {
+	defer func(limit int, pauseAfter int, interval time.Duration, timeout time.Duration, limiter *rolloutLimiter) {
+		options.MaxConcurrentRollouts = limit
+		options.PauseAfterFailedRollouts = pauseAfter
+		rolloutPollInterval = interval
+		options.RolloutTimeout = timeout
+		reloadLimiter = limiter
+	}(options.MaxConcurrentRollouts, options.PauseAfterFailedRollouts, rolloutPollInterval, options.RolloutTimeout, reloadLimiter)
+	options.MaxConcurrentRollouts = 1
+	options.PauseAfterFailedRollouts = 1
+	rolloutPollInterval = 10 * time.Millisecond
+	options.RolloutTimeout = 100 * time.Millisecond
+	reloadLimiter = newRolloutLimiter()
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "failing", Namespace: "pause", Generation: 1},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1},
+	}
+	client := testclient.NewSimpleClientset(deployment)
+	testClients := kube.Clients{KubernetesClient: client}
+	configs := []util.Config{{Namespace: "pause", ResourceName: "config", Type: constants.ConfigmapEnvVarPostfix}}
+
+	release, err := reloadLimiter.tryAcquire("deployment/pause/failing")
+	assert.NoError(t, err)
+	reloadRolloutWatcher.watch(testClients, configs, GetDeploymentRollingUpgradeFuncs(), getCollectors(), nil, deployment, deployment.Name, release)
+
+	// The rollout times out, further reloads are paused
+	assert.Eventually(t, func() bool {
+		_, err := reloadLimiter.tryAcquire("deployment/pause/other")
+		return isRequeue(err)
+	}, time.Second, 10*time.Millisecond)
+
+	// The workload becomes healthy, e.g. after it was fixed manually, and the reloads resume
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+	_, err = client.AppsV1().Deployments("pause").UpdateStatus(context.TODO(), deployment, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		release, err := reloadLimiter.tryAcquire("deployment/pause/other")
+		if err != nil {
+			return false
+		}
+		release()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRevertConfigmapsOnRolloutFailure(t *testing.T) {
+	previous := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "revert-configmap", Namespace: "revert"},
+		Data:       map[string]string{"version": "good"},
+	}
+	current := previous.DeepCopy()
+	current.Annotations = map[string]string{options.ConfigmapRevertOnFailureAnnotation: "true"}
+	current.Data = map[string]string{"version": "bad"}
+
+	testClients := kube.Clients{KubernetesClient: testclient.NewSimpleClientset(current)}
+	assert.NoError(t, history.Record(testClients.KubernetesClient, previous, util.GetSHAfromConfigmap(previous), options.HistoryLimit))
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "revert-deployment", Namespace: "revert"}}
+	configs := []util.Config{util.GetConfigmapConfig(current)}
+	recorder := record.NewFakeRecorder(10)
+
+	reportRolloutOutcome(testClients, configs, GetDeploymentRollingUpgradeFuncs(), getCollectors(), recorder, deployment, deployment.Name, time.Second, fmt.Errorf("deployment exceeded its progress deadline"))
+
+	reverted, err := testClients.KubernetesClient.CoreV1().ConfigMaps("revert").Get(context.TODO(), "revert-configmap", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"version": "good"}, reverted.Data)
+
+	assert.Contains(t, <-recorder.Events, "ReloadFailed")
+	assert.Contains(t, <-recorder.Events, "ConfigReverted")
+}
+
+func TestPolicyRollingUpgradeUsingErs(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	deploymentName := "policy-deployment-" + testutil.RandSeq(5)
+	optedOutName := "policy-opted-out-" + testutil.RandSeq(5)
+	configmapName := "policy-configmap-" + testutil.RandSeq(5)
+
+	reloadPolicy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "reloader.stakater.com/v1alpha1",
+		"kind":       "ReloadPolicy",
+		"metadata":   map[string]interface{}{"name": "payments", "namespace": ersNamespace},
+		"spec": map[string]interface{}{
+			"workloadSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "payments"}},
+			"sources":          []interface{}{map[string]interface{}{"kind": "ConfigMap", "names": []interface{}{configmapName}}},
+		},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{policy.ReloadPolicyResource: "ReloadPolicyList"}, reloadPolicy)
+	store := policy.NewStore(dynamicClient, ersNamespace)
+	stop := make(chan struct{})
+	defer close(stop)
+	assert.True(t, store.Run(stop))
+	policy.SetStore(store)
+	defer policy.SetStore(nil)
+
+	for name, annotations := range map[string]map[string]string{
+		deploymentName: nil,
+		optedOutName:   {options.ReloaderAutoAnnotation: "false"},
+	} {
+		deployment := testutil.GetDeployment(ersNamespace, name)
+		deployment.Labels = map[string]string{"team": "payments"}
+		deployment.Annotations = annotations
+		_, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		defer func(name string) {
+			_ = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		}(name)
+	}
+
+	config := getConfigWithAnnotations(constants.ConfigmapEnvVarPostfix, configmapName, "policy-sha", options.ConfigmapUpdateOnChangeAnnotation, options.ConfigmapReloaderAutoAnnotation)
+	err := PerformAction(clients, config, GetDeploymentRollingUpgradeFuncs(), getCollectors(), nil, invokeReloadStrategy)
+	assert.NoError(t, err)
+
+	envName := getEnvVarName(configmapName, constants.ConfigmapEnvVarPostfix)
+	current, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "policy-sha", getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, envName), "Deployment selected by the policy was not reloaded")
+
+	optedOut, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), optedOutName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, getEnvVarValue(optedOut.Spec.Template.Spec.Containers[0].Env, envName), "Deployment opted out with the auto annotation was reloaded")
+}
+
+func TestChangesConsumedKeys(t *testing.T) {
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	configmapName := "shared-config"
+	volumeSource := func(items ...v1.KeyToPath) v1.VolumeSource {
+		return v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}, Items: items}}
+	}
+
+	tests := []struct {
+		name        string
+		changedKeys []string
+		annotations map[string]string
+		volume      *v1.VolumeSource
+		mount       *v1.VolumeMount
+		env         []v1.EnvVar
+		envFrom     []v1.EnvFromSource
+		expected    bool
+	}{
+		{
+			name:     "Unknown keys",
+			envFrom:  []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}}}},
+			expected: true,
+		},
+		{
+			name:        "Whole configmap as env",
+			changedKeys: []string{"other"},
+			envFrom:     []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}}}},
+			expected:    true,
+		},
+		{
+			name:        "Consumed key reference changed",
+			changedKeys: []string{"app"},
+			env:         []v1.EnvVar{{Name: "APP", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}, Key: "app"}}}},
+			expected:    true,
+		},
+		{
+			name:        "Other key than the key reference changed",
+			changedKeys: []string{"other"},
+			env:         []v1.EnvVar{{Name: "APP", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}, Key: "app"}}}},
+		},
+		{
+			name:        "Whole configmap as volume",
+			changedKeys: []string{"other"},
+			volume:      ptr(volumeSource()),
+			mount:       &v1.VolumeMount{MountPath: "/etc/config"},
+			expected:    true,
+		},
+		{
+			name:        "Consumed volume item changed",
+			changedKeys: []string{"app"},
+			volume:      ptr(volumeSource(v1.KeyToPath{Key: "app", Path: "app.properties"})),
+			mount:       &v1.VolumeMount{MountPath: "/etc/config"},
+			expected:    true,
+		},
+		{
+			name:        "Other key than the volume items changed",
+			changedKeys: []string{"other"},
+			volume:      ptr(volumeSource(v1.KeyToPath{Key: "app", Path: "app.properties"})),
+			mount:       &v1.VolumeMount{MountPath: "/etc/config"},
+		},
+		{
+			name:        "Key mounted with sub path changed",
+			changedKeys: []string{"app.properties"},
+			volume:      ptr(volumeSource()),
+			mount:       &v1.VolumeMount{MountPath: "/etc/config/app.properties", SubPath: "app.properties"},
+			expected:    true,
+		},
+		{
+			name:        "Other key than the sub path changed",
+			changedKeys: []string{"other"},
+			volume:      ptr(volumeSource(v1.KeyToPath{Key: "app", Path: "app.properties"}, v1.KeyToPath{Key: "other", Path: "other.properties"})),
+			mount:       &v1.VolumeMount{MountPath: "/etc/config/app.properties", SubPath: "app.properties"},
+		},
+		{
+			name:        "Annotated key changed",
+			changedKeys: []string{"log-level"},
+			annotations: map[string]string{options.ConfigmapReloadKeysAnnotation: "other-config/other, shared-config/log-level"},
+			envFrom:     []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}}}},
+			expected:    true,
+		},
+		{
+			name:        "Other key than the annotated keys changed",
+			changedKeys: []string{"other"},
+			annotations: map[string]string{options.ConfigmapReloadKeysAnnotation: "shared-config/log-level"},
+			envFrom:     []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}}}},
+		},
+		{
+			name:        "Hashed metadata changed",
+			changedKeys: []string{"annotation:revision"},
+			env:         []v1.EnvVar{{Name: "APP", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: configmapName}, Key: "app"}}}},
+			expected:    true,
+		},
+		{
+			name:        "Not referenced",
+			changedKeys: []string{"other"},
+			expected:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := testutil.GetDeployment(ersNamespace, "keys")
+			deployment.Annotations = tt.annotations
+			podSpec := &deployment.Spec.Template.Spec
+			podSpec.Volumes = nil
+			podSpec.Containers[0].VolumeMounts = nil
+			podSpec.Containers[0].Env = tt.env
+			podSpec.Containers[0].EnvFrom = tt.envFrom
+			if tt.volume != nil {
+				podSpec.Volumes = []v1.Volume{{Name: "config", VolumeSource: *tt.volume}}
+				tt.mount.Name = "config"
+				podSpec.Containers[0].VolumeMounts = []v1.VolumeMount{*tt.mount}
+			}
+
+			config := util.Config{
+				ResourceName:   configmapName,
+				Type:           constants.ConfigmapEnvVarPostfix,
+				KeysAnnotation: options.ConfigmapReloadKeysAnnotation,
+				ChangedKeys:    tt.changedKeys,
+			}
+			assert.Equal(t, tt.expected, changesConsumedKeys(deploymentFuncs, deployment, config))
+		})
+	}
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestGetDeferralReason(t *testing.T) {
+	defer func() { options.ReloadWindow = "" }()
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	now, _ := time.Parse(time.RFC3339, "2025-05-02T12:00:00Z")
+	nightly := map[string]string{options.ReloadWindowAnnotation: "0 22 * * *"}
+	noon := map[string]string{
+		options.ReloadWindowAnnotation:         "0 11 * * *",
+		options.ReloadWindowDurationAnnotation: "2h",
+	}
+
+	tests := []struct {
+		name                 string
+		globalWindow         string
+		namespaceAnnotations map[string]string
+		workloadAnnotations  map[string]string
+		expected             string
+	}{
+		{
+			name: "No window",
+		},
+		{
+			name:                 "Frozen namespace",
+			namespaceAnnotations: map[string]string{options.FreezeAnnotation: "true"},
+			workloadAnnotations:  noon,
+			expected:             "namespace 'deferral' is frozen",
+		},
+		{
+			name:         "Global window closed",
+			globalWindow: "0 22 * * *",
+			expected:     "the reload window opens at 2025-05-02T22:00:00Z",
+		},
+		{
+			name:                 "Namespace window open",
+			globalWindow:         "0 22 * * *",
+			namespaceAnnotations: noon,
+		},
+		{
+			name:                 "Workload window overrides namespace window",
+			namespaceAnnotations: noon,
+			workloadAnnotations:  nightly,
+			expected:             "the reload window opens at 2025-05-02T22:00:00Z",
+		},
+		{
+			name: "Workload window in timezone",
+			workloadAnnotations: map[string]string{
+				options.ReloadWindowAnnotation:         "0 14 * * *",
+				options.ReloadWindowTimezoneAnnotation: "Europe/Berlin",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options.ReloadWindow = tt.globalWindow
+			namespace := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "deferral", Annotations: tt.namespaceAnnotations}}
+			deployment := testutil.GetDeployment("deferral", "deferral")
+			deployment.Annotations = tt.workloadAnnotations
+			assert.Equal(t, tt.expected, getDeferralReason(namespace, deploymentFuncs, deployment, now))
+		})
+	}
+}
+
+func TestDeferredRollingUpgradeUsingErs(t *testing.T) {
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	options.DeferredReloadsConfigMap = "reloader-deferred-reloads"
+	defer func() { options.DeferredReloadsConfigMap = "" }()
+	t.Setenv(constants.PodNamespaceEnv, ersNamespace)
+	deploymentName := "deferred-deployment-" + testutil.RandSeq(5)
+	configmapName := "deferred-configmap-" + testutil.RandSeq(5)
+
+	_, err := testutil.CreateConfigMap(clients.KubernetesClient, ersNamespace, configmapName, "www.google.com")
+	assert.NoError(t, err)
+	defer func() {
+		_ = testutil.DeleteConfigMap(clients.KubernetesClient, ersNamespace, configmapName)
+	}()
+
+	deployment := testutil.GetDeployment(ersNamespace, deploymentName)
+	deployment.Annotations = map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: configmapName,
+		// Never opens
+		options.ReloadWindowAnnotation: "0 0 30 2 *",
+	}
+	_, err = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	defer func() {
+		_ = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+	}()
+
+	configmap, err := clients.KubernetesClient.CoreV1().ConfigMaps(ersNamespace).Get(context.TODO(), configmapName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	config := util.GetConfigmapConfig(configmap)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	collectors := getCollectors()
+	recorder := record.NewFakeRecorder(10)
+
+	err = PerformAction(clients, config, deploymentFuncs, collectors, recorder, invokeReloadStrategy)
+	assert.NoError(t, err)
+
+	envName := getEnvVarName(configmapName, constants.ConfigmapEnvVarPostfix)
+	current, err := clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, envName), "Deployment was reloaded outside of its reload window")
+	select {
+	case event := <-recorder.Events:
+		assert.Contains(t, event, "ReloadDeferred")
+		assert.Contains(t, event, "the reload window never opens")
+	default:
+		t.Errorf("Deferred reload event was not recorded")
+	}
+
+	// The deferred reloads are persisted in the background
+	getPersisted := func() string {
+		persisted, err := clients.KubernetesClient.CoreV1().ConfigMaps(ersNamespace).Get(context.TODO(), options.DeferredReloadsConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return ""
+		}
+		return persisted.Data[deferredReloadsKey]
+	}
+	assert.Eventually(t, func() bool {
+		return strings.Contains(getPersisted(), deploymentName)
+	}, 5*time.Second, 10*time.Millisecond, "Deferred reload was not persisted")
+
+	// The persisted reloads are restored after a restart
+	reloadDeferrer.mu.Lock()
+	reloadDeferrer.pending = map[string]*deferredReload{}
+	reloadDeferrer.load(clients)
+	reloadDeferrer.mu.Unlock()
+
+	reloadDeferrer.process(clients, collectors, recorder, time.Now())
+	current, err = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, envName), "Deployment was reloaded while its reload window is closed")
+
+	delete(current.Annotations, options.ReloadWindowAnnotation)
+	_, err = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Update(context.TODO(), current, metav1.UpdateOptions{})
+	assert.NoError(t, err)
+
+	reloadDeferrer.process(clients, collectors, recorder, time.Now())
+	current, err = clients.KubernetesClient.AppsV1().Deployments(ersNamespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, config.SHAValue, getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, envName), "Deferred reload was not applied")
+
+	assert.Eventually(t, func() bool {
+		return getPersisted() == "[]"
+	}, 5*time.Second, 10*time.Millisecond, "Applied reload was not removed from the persisted reloads")
+}
+
+func TestDeferredReloadPersistsSourceReferences(t *testing.T) {
+	secret := testutil.GetSecret("deferred", "db-credentials", "password")
+	secret.Annotations = map[string]string{"kubectl.kubernetes.io/last-applied-configuration": `{"data":{"test.url":"cGFzc3dvcmQ="}}`}
+	source := util.GetSecretConfig(secret)
+	source.ReloadStrategy = constants.RestartReloadStrategy
+	reload := &deferredReload{Kind: "Deployment", Namespace: "deferred", Name: "app", Sources: []util.Config{source}}
+
+	content, err := json.Marshal([]*deferredReload{reload.persisted()})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "last-applied-configuration")
+	assert.NotContains(t, string(content), source.SHAValue)
+	for _, sha := range source.KeySHAs {
+		assert.NotContains(t, string(content), sha)
+	}
+	assert.Equal(t, source, reload.Sources[0], "the pending reload must keep its sources")
+
+	var loaded []*deferredReload
+	assert.NoError(t, json.Unmarshal(content, &loaded))
+	restored := restoreSource(loaded[0].Sources[0])
+	assert.Equal(t, "db-credentials", restored.ResourceName)
+	assert.Equal(t, constants.SecretEnvVarPostfix, restored.Type)
+	assert.Equal(t, options.SecretUpdateOnChangeAnnotation, restored.Annotation)
+	assert.Equal(t, constants.RestartReloadStrategy, restored.ReloadStrategy)
+	assert.Nil(t, restored.KeySHAs)
+}
+
+func TestGetNamespaceForbidden(t *testing.T) {
+	client := testclient.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "forbidden"}})
+	client.PrependReactor("get", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.NewForbidden(v1.Resource("namespaces"), "forbidden", fmt.Errorf("namespaced role"))
+	})
+	assert.Nil(t, getNamespace(kube.Clients{KubernetesClient: client}, "forbidden"))
+	_, reported := forbiddenNamespaces.Load("forbidden")
+	assert.True(t, reported, "missing permission was not reported")
+}
+
+func TestGetNamespaceFromLister(t *testing.T) {
+	defer SetNamespaceLister(nil)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	assert.NoError(t, indexer.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "cached", Annotations: map[string]string{options.FreezeAnnotation: "true"}}}))
+	SetNamespaceLister(corev1listers.NewNamespaceLister(indexer))
+
+	namespace := getNamespace(clients, "cached")
+	assert.NotNil(t, namespace)
+	assert.Equal(t, "true", namespace.Annotations[options.FreezeAnnotation])
+
+	// Namespaces missing in the cache are read from the API server
+	namespace = getNamespace(clients, ersNamespace)
+	assert.NotNil(t, namespace)
+	assert.Equal(t, ersNamespace, namespace.Name)
+}
+
+func TestOrderTargets(t *testing.T) {
+	target := func(upgradeFuncs callbacks.RollingUpgradeFuncs, name string, reloadAfter string) reloadTarget {
+		annotations := map[string]string{options.ReloadAfterAnnotation: reloadAfter}
+		if upgradeFuncs.ResourceType == "StatefulSet" {
+			statefulSet := testutil.GetStatefulSet("plan", name)
+			statefulSet.Annotations = annotations
+			return reloadTarget{upgradeFuncs: upgradeFuncs, item: statefulSet}
+		}
+		deployment := testutil.GetDeployment("plan", name)
+		deployment.Annotations = annotations
+		return reloadTarget{upgradeFuncs: upgradeFuncs, item: deployment}
+	}
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	statefulSetFuncs := GetStatefulSetRollingUpgradeFuncs()
+
+	tests := []struct {
+		name    string
+		targets []reloadTarget
+		want    []int
+	}{
+		{
+			name:    "No dependencies",
+			targets: []reloadTarget{target(deploymentFuncs, "frontend", ""), target(statefulSetFuncs, "backend", "")},
+			want:    []int{0, 1},
+		},
+		{
+			name:    "Dependency of another kind",
+			targets: []reloadTarget{target(deploymentFuncs, "frontend", "StatefulSet/backend"), target(statefulSetFuncs, "backend", "")},
+			want:    []int{1, 0},
+		},
+		{
+			name:    "Dependency without kind",
+			targets: []reloadTarget{target(deploymentFuncs, "frontend", "backend"), target(statefulSetFuncs, "backend", "")},
+			want:    []int{1, 0},
+		},
+		{
+			name:    "Dependency of a different kind",
+			targets: []reloadTarget{target(deploymentFuncs, "frontend", "deployment/backend"), target(statefulSetFuncs, "backend", "")},
+			want:    []int{0, 1},
+		},
+		{
+			name: "Chain of dependencies",
+			targets: []reloadTarget{
+				target(deploymentFuncs, "frontend", "api"),
+				target(deploymentFuncs, "api", "statefulset/backend"),
+				target(statefulSetFuncs, "backend", ""),
+			},
+			want: []int{2, 1, 0},
+		},
+		{
+			name:    "Dependency cycle",
+			targets: []reloadTarget{target(deploymentFuncs, "frontend", "backend"), target(statefulSetFuncs, "backend", "frontend")},
+			want:    []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, orderTargets(tt.targets, getDependencies(tt.targets)))
+		})
+	}
+}
+
+func TestPerformPlanWaitsForDependencies(t *testing.T) {
+	defer func(interval time.Duration, timeout time.Duration, strategy string) {
+		rolloutPollInterval = interval
+		options.RolloutTimeout = timeout
+		options.ReloadStrategy = strategy
+	}(rolloutPollInterval, options.RolloutTimeout, options.ReloadStrategy)
+	rolloutPollInterval = 10 * time.Millisecond
+	options.RolloutTimeout = 100 * time.Millisecond
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+
+	for _, healthy := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Backend healthy %t", healthy), func(t *testing.T) {
+			frontend := testutil.GetDeployment("plan", "frontend")
+			frontend.Annotations = map[string]string{
+				options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap",
+				options.ReloadAfterAnnotation:             "statefulset/backend",
+			}
+			backend := testutil.GetStatefulSet("plan", "backend")
+			backend.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap"}
+			if healthy {
+				backend.Status = appsv1.StatefulSetStatus{ReadyReplicas: 1}
+			}
+
+			client := testclient.NewSimpleClientset(frontend, backend)
+			testClients := kube.Clients{KubernetesClient: client}
+			config := util.Config{
+				Namespace:    "plan",
+				ResourceName: "shared-configmap",
+				Type:         constants.ConfigmapEnvVarPostfix,
+				SHAValue:     "sha",
+				Annotation:   options.ConfigmapUpdateOnChangeAnnotation,
+			}
+			recorder := record.NewFakeRecorder(10)
+			targets := []reloadTarget{
+				{upgradeFuncs: GetDeploymentRollingUpgradeFuncs(), item: frontend},
+				{upgradeFuncs: GetStatefulSetRollingUpgradeFuncs(), item: backend},
+			}
+
+			err := performPlan(testClients, config, getCollectors(), recorder, invokeReloadStrategy, targets)
+			if healthy {
+				assert.NoError(t, err)
+			} else {
+				// The skipped dependent fails the change so it is retried
+				var reloadErr *ReloadError
+				if assert.ErrorAs(t, err, &reloadErr) {
+					assert.Equal(t, []string{"Deployment/frontend"}, reloadErr.Targets)
+				}
+			}
+
+			var updated []string
+			for _, action := range client.Actions() {
+				if action.GetVerb() == "patch" || action.GetVerb() == "update" {
+					updated = append(updated, action.GetResource().Resource)
+				}
+			}
+			if healthy {
+				assert.Equal(t, []string{"statefulsets", "deployments"}, updated)
+				return
+			}
+			assert.Equal(t, []string{"statefulsets"}, updated)
+
+			var skipped bool
+			for len(recorder.Events) > 0 {
+				if event := <-recorder.Events; strings.Contains(event, "ReloadSkipped") {
+					skipped = true
+					assert.Contains(t, event, "rollout of 'backend' of type 'StatefulSet' failed")
+				}
+			}
+			assert.True(t, skipped, "Skipped reload event was not recorded")
+
+			// The retried change still holds back the dependent while the backend is unhealthy
+			backend, err = client.AppsV1().StatefulSets("plan").Get(context.TODO(), "backend", metav1.GetOptions{})
+			assert.NoError(t, err)
+			targets[1].item = backend
+			client.ClearActions()
+			err = performPlan(testClients, config, getCollectors(), recorder, invokeReloadStrategy, targets)
+			assert.Error(t, err)
+			for _, action := range client.Actions() {
+				assert.NotContains(t, []string{"patch", "update"}, action.GetVerb(), "Dependent was reloaded while its dependency is unhealthy")
+			}
+		})
+	}
+}
+
+func TestPerformPlanHoldsBackDependentsOfPendingReloads(t *testing.T) {
+	defer func(strategy string) { options.ReloadStrategy = strategy }(options.ReloadStrategy)
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+
+	tests := []struct {
+		name       string
+		annotation string
+		value      string
+		after      time.Duration
+	}{
+		{name: "Debounced", annotation: options.DebounceWindowAnnotation, value: "1h", after: slotRetryInterval},
+		{name: "Deferred", annotation: options.ReloadWindowAnnotation, value: "0 0 30 2 *", after: deferredReloadInterval},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frontend := testutil.GetDeployment("pending-plan", "frontend")
+			frontend.Annotations = map[string]string{
+				options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap",
+				options.ReloadAfterAnnotation:             "statefulset/backend",
+			}
+			backend := testutil.GetStatefulSet("pending-plan", "backend")
+			backend.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap", tt.annotation: tt.value}
+			backend.Status = appsv1.StatefulSetStatus{ReadyReplicas: 1}
+			client := testclient.NewSimpleClientset(frontend, backend)
+			config := util.Config{
+				Namespace:    "pending-plan",
+				ResourceName: "shared-configmap",
+				Type:         constants.ConfigmapEnvVarPostfix,
+				SHAValue:     "sha-" + tt.name,
+				Annotation:   options.ConfigmapUpdateOnChangeAnnotation,
+			}
+			targets := []reloadTarget{
+				{upgradeFuncs: GetDeploymentRollingUpgradeFuncs(), item: frontend},
+				{upgradeFuncs: GetStatefulSetRollingUpgradeFuncs(), item: backend},
+			}
+			key := targets[1].key("pending-plan")
+			defer func() {
+				reloadDebouncer.mu.Lock()
+				delete(reloadDebouncer.pending, key)
+				reloadDebouncer.mu.Unlock()
+				reloadDeferrer.mu.Lock()
+				delete(reloadDeferrer.pending, key)
+				reloadDeferrer.mu.Unlock()
+				reloadPlanProgress.take(getPlanKey(config))
+			}()
+
+			// The dependent waits for the pending reload of its dependency instead of reloading before it
+			for range 2 {
+				err := performPlan(kube.Clients{KubernetesClient: client}, config, getCollectors(), nil, invokeReloadStrategy, targets)
+				var requeue *RequeueError
+				if assert.ErrorAs(t, err, &requeue) {
+					assert.Equal(t, tt.after, requeue.After)
+					assert.Contains(t, requeue.Reason, "reload of 'backend' of type 'StatefulSet'")
+				}
+			}
+			for _, action := range client.Actions() {
+				assert.NotContains(t, []string{"patch", "update"}, action.GetVerb(), "workload was reloaded while its dependency is pending")
+			}
+			assert.True(t, hasPendingReload(targets[1], config))
+		})
+	}
+}
+
+func TestPerformPlanRequeueSkipsReloadedTargets(t *testing.T) {
+	defer func(stagger time.Duration, strategy string, limiter *rolloutLimiter) {
+		options.ReloadStagger = stagger
+		options.ReloadStrategy = strategy
+		reloadLimiter = limiter
+	}(options.ReloadStagger, options.ReloadStrategy, reloadLimiter)
+	options.ReloadStagger = time.Hour
+	options.ReloadStrategy = constants.AnnotationsReloadStrategy
+	reloadLimiter = newRolloutLimiter()
+
+	first := testutil.GetDeployment("stagger", "first")
+	first.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap"}
+	second := testutil.GetDeployment("stagger", "second")
+	second.Annotations = map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "shared-configmap"}
+	client := testclient.NewSimpleClientset(first, second)
+	testClients := kube.Clients{KubernetesClient: client}
+	config := util.Config{
+		Namespace:    "stagger",
+		ResourceName: "shared-configmap",
+		Type:         constants.ConfigmapEnvVarPostfix,
+		SHAValue:     "sha",
+		Annotation:   options.ConfigmapUpdateOnChangeAnnotation,
+	}
+	getTargets := func() []reloadTarget {
+		return []reloadTarget{
+			{upgradeFuncs: GetDeploymentRollingUpgradeFuncs(), item: first.DeepCopy()},
+			{upgradeFuncs: GetDeploymentRollingUpgradeFuncs(), item: second.DeepCopy()},
+		}
+	}
+	getUpdated := func() []string {
+		var updated []string
+		for _, action := range client.Actions() {
+			if patch, ok := action.(clienttesting.PatchAction); ok {
+				updated = append(updated, patch.GetName())
+			}
+		}
+		return updated
+	}
+
+	// The second target waits for the stagger, the requeued change does not restart the first one again
+	for range 3 {
+		err := performPlan(testClients, config, getCollectors(), nil, invokeReloadStrategy, getTargets())
+		assert.True(t, isRequeue(err), "expected a requeue, got %v", err)
+	}
+	assert.Equal(t, []string{"first"}, getUpdated())
+
+	reloadLimiter.nextStart = time.Time{}
+	assert.NoError(t, performPlan(testClients, config, getCollectors(), nil, invokeReloadStrategy, getTargets()))
+	assert.Equal(t, []string{"first", "second"}, getUpdated())
+
+	// A later change reloads both targets again
+	reloadLimiter.nextStart = time.Time{}
+	config.SHAValue = "other-sha"
+	err := performPlan(testClients, config, getCollectors(), nil, invokeReloadStrategy, getTargets())
+	assert.True(t, isRequeue(err), "expected a requeue, got %v", err)
+	assert.Equal(t, []string{"first", "second", "first"}, getUpdated())
+}
+
+func TestSignalReloadStrategy(t *testing.T) {
+	config := util.Config{
+		Namespace:      "signal",
+		ResourceName:   "signal-deployment",
+		Type:           constants.ConfigmapEnvVarPostfix,
+		SHAValue:       "sha",
+		ReloadStrategy: constants.SignalReloadStrategy,
+	}
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	deployment := testutil.GetDeployment("signal", "signal-deployment")
+	template := deployment.Spec.Template.DeepCopy()
+	result := invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Equal(t, patchtypes.MergePatchType, result.Patch.Type)
+	assert.Equal(t, *template, deployment.Spec.Template, "Pod template changed by the signal strategy")
+	assert.Len(t, getSignaledConfigs(deploymentFuncs, deployment, []util.Config{config}), 1)
+
+	// The same change is only signaled once
+	result = invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.NotUpdated, result.Result)
+
+	// Environment variables can not be reloaded in place, the pods are restarted
+	deployment = testutil.GetDeploymentWithEnvVars("signal", "signal-deployment")
+	result = invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Empty(t, getSignaledConfigs(deploymentFuncs, deployment, []util.Config{config}))
+	assert.Equal(t, "sha", getEnvVarValue(deployment.Spec.Template.Spec.Containers[0].Env, getEnvVarName(config.ResourceName, config.Type)))
+}
+
+func TestReloadInPlaceUsingHook(t *testing.T) {
+	tests := []struct {
+		name             string
+		verifyExitCode   int32
+		status           int
+		expectedRequests []string
+		reloaded         bool
+	}{
+		{name: "Reloaded", status: http.StatusOK, expectedRequests: []string{"POST /-/reload"}, reloaded: true},
+		{name: "Hook failed", status: http.StatusInternalServerError, expectedRequests: []string{"POST /-/reload"}},
+		{name: "Files not updated", verifyExitCode: 1, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			serverURL, err := url.Parse(server.URL)
+			assert.NoError(t, err)
+
+			config := util.Config{
+				Namespace:      "signal",
+				ResourceName:   "signal-deployment",
+				Type:           constants.ConfigmapEnvVarPostfix,
+				SHAValue:       "sha",
+				ReloadStrategy: constants.SignalReloadStrategy,
+				Annotation:     options.ConfigmapUpdateOnChangeAnnotation,
+			}
+			deployment := testutil.GetDeployment("signal", "signal-deployment")
+			deployment.Annotations[options.ReloadHookAnnotation] = ":" + serverURL.Port() + "/-/reload"
+			pod := &v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "signal-pod", Namespace: "signal", Labels: deployment.Spec.Template.Labels},
+				Spec:       v1.PodSpec{Volumes: deployment.Spec.Template.Spec.Volumes},
+				Status: v1.PodStatus{
+					Phase:      v1.PodRunning,
+					PodIP:      "127.0.0.1",
+					Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+				},
+			}
+			configmap := &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "signal-deployment", Namespace: "signal"},
+				Data:       map[string]string{"config.yaml": "reload: true"},
+			}
+			client := testclient.NewSimpleClientset(deployment, pod, configmap)
+			terminateEphemeralContainers(client, "signal", "signal-pod", tt.verifyExitCode)
+			testClients := kube.Clients{KubernetesClient: client}
+			deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+			collectors := getCollectors()
+			recorder := record.NewFakeRecorder(10)
+
+			reloadInPlace(testClients, []util.Config{config}, deploymentFuncs, collectors, recorder, deployment, deployment.Name)
+			assert.Equal(t, tt.expectedRequests, requests)
+
+			object, err := client.Tracker().Get(v1.SchemeGroupVersion.WithResource("pods"), "signal", "signal-pod")
+			assert.NoError(t, err)
+			containers := object.(*v1.Pod).Spec.EphemeralContainers
+			if assert.Len(t, containers, 1) {
+				hash := sha256.Sum256([]byte("reload: true"))
+				expected := hex.EncodeToString(hash[:]) + "  /reloader/configmap/config.yaml\n" +
+					hex.EncodeToString(hash[:]) + "  /reloader/projectedconfigmap/config.yaml\n"
+				assert.Equal(t, expected, containers[0].Env[0].Value)
+				assert.Len(t, containers[0].VolumeMounts, 2)
+			}
+
+			labels := prometheus.Labels{"workload_type": "Deployment", "method": "hook", "success": strconv.FormatBool(tt.reloaded)}
+			assert.Equal(t, float64(1), promtestutil.ToFloat64(collectors.InPlaceReloads.With(labels)))
+
+			getEnvValue := func() string {
+				current, err := client.AppsV1().Deployments("signal").Get(context.TODO(), deployment.Name, metav1.GetOptions{})
+				assert.NoError(t, err)
+				return getEnvVarValue(current.Spec.Template.Spec.Containers[0].Env, getEnvVarName(config.ResourceName, config.Type))
+			}
+			if tt.reloaded {
+				assert.Contains(t, <-recorder.Events, "ReloadedInPlace")
+				assert.Empty(t, getEnvValue(), "Deployment was restarted after a successful in-place reload")
+				return
+			}
+			assert.Contains(t, <-recorder.Events, "pod 'signal-pod'")
+			assert.Contains(t, <-recorder.Events, "ReloadInPlaceFailed")
+			// The restart is scheduled in the background
+			assert.Eventually(t, func() bool { return getEnvValue() == "sha" }, time.Second, 10*time.Millisecond,
+				"Deployment was not restarted after the failed in-place reload")
+		})
+	}
+}
+
+func TestGetReloadHookURL(t *testing.T) {
+	endpoint, err := getReloadHookURL(":9090/-/reload", "10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://10.0.0.1:9090/-/reload", endpoint)
+
+	endpoint, err = getReloadHookURL("https://:8443/reload", "fd00::1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://[fd00::1]:8443/reload", endpoint)
+
+	_, err = getReloadHookURL("/-/reload", "10.0.0.1")
+	assert.Error(t, err)
+}
+
+// terminateEphemeralContainers lets the ephemeral containers of the pod terminate right away with the exit code
+func terminateEphemeralContainers(client *testclient.Clientset, namespace string, name string, exitCode int32) {
+	client.PrependReactor("get", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		object, err := client.Tracker().Get(v1.SchemeGroupVersion.WithResource("pods"), namespace, name)
+		if err != nil {
+			return true, nil, err
+		}
+		current := object.(*v1.Pod)
+		for _, container := range current.Spec.EphemeralContainers {
+			current.Status.EphemeralContainerStatuses = append(current.Status.EphemeralContainerStatuses, v1.ContainerStatus{
+				Name:  container.Name,
+				State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode}},
+			})
+		}
+		return true, current, nil
+	})
+}
+
+func TestSignalContainers(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "signal-pod", Namespace: "signal"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "nginx"}}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	client := testclient.NewSimpleClientset(pod)
+	terminateEphemeralContainers(client, "signal", "signal-pod", 0)
+
+	signal, err := getReloadSignal("sighup")
+	assert.NoError(t, err)
+	err = signalContainers(kube.Clients{KubernetesClient: client}, pod.DeepCopy(), []string{"nginx"}, signal)
+	assert.NoError(t, err)
+
+	object, err := client.Tracker().Get(v1.SchemeGroupVersion.WithResource("pods"), "signal", "signal-pod")
+	assert.NoError(t, err)
+	containers := object.(*v1.Pod).Spec.EphemeralContainers
+	if assert.Len(t, containers, 1) {
+		assert.Equal(t, "nginx", containers[0].TargetContainerName)
+		assert.Equal(t, []string{"kill", "-s", "HUP", "1"}, containers[0].Command)
+	}
+
+	_, err = getReloadSignal("HUP; rm -rf /")
+	assert.Error(t, err)
+}
+
+func TestRunEphemeralContainersLimit(t *testing.T) {
+	defer func(limit int) { options.MaxEphemeralContainers = limit }(options.MaxEphemeralContainers)
+	options.MaxEphemeralContainers = 2
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "limit-pod", Namespace: "signal"},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{Name: "nginx"}},
+			EphemeralContainers: []v1.EphemeralContainer{
+				{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}},
+				{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "reloader-signal-abcde"}},
+			},
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+	client := testclient.NewSimpleClientset(pod)
+	terminateEphemeralContainers(client, "signal", "limit-pod", 0)
+	testClients := kube.Clients{KubernetesClient: client}
+
+	// Only the ephemeral containers of Reloader count
+	assert.NoError(t, signalContainers(testClients, pod.DeepCopy(), []string{"nginx"}, "HUP"))
+
+	current, err := client.CoreV1().Pods("signal").Get(context.TODO(), "limit-pod", metav1.GetOptions{})
+	assert.NoError(t, err)
+	err = signalContainers(testClients, current, []string{"nginx"}, "HUP")
+	assert.ErrorContains(t, err, "pod has 2 ephemeral containers of Reloader")
+
+	current, err = client.CoreV1().Pods("signal").Get(context.TODO(), "limit-pod", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, current.Spec.EphemeralContainers, 3)
+}
+
+func TestRunEphemeralContainersForbidden(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "forbidden-pod", Namespace: "signal"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "nginx"}}},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	client := testclient.NewSimpleClientset(pod)
+	client.PrependReactor("update", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "ephemeralcontainers" {
+			return false, nil, nil
+		}
+		return true, nil, errors.NewForbidden(schema.GroupResource{Resource: "pods/ephemeralcontainers"}, "forbidden-pod", fmt.Errorf("denied"))
+	})
+
+	// The forbidden error is kept and explains the missing permissions
+	err := signalContainers(kube.Clients{KubernetesClient: client}, pod.DeepCopy(), []string{"nginx"}, "HUP")
+	assert.True(t, errors.IsForbidden(err))
+	assert.ErrorContains(t, err, "missing RBAC permissions")
+	assert.ErrorContains(t, err, "reloader.enableInPlaceReloads")
+}
+
+func TestRestartReloadStrategy(t *testing.T) {
+	config := util.Config{
+		Namespace:      "restart",
+		ResourceName:   "restart-deployment",
+		Type:           constants.ConfigmapEnvVarPostfix,
+		SHAValue:       "sha",
+		ReloadStrategy: constants.RestartReloadStrategy,
+	}
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	deployment := testutil.GetDeployment("restart", "restart-deployment")
+	client := testclient.NewSimpleClientset(deployment.DeepCopy())
+	result := invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	restartedAt := deployment.Spec.Template.Annotations[constants.RestartedAtAnnotation]
+	assert.NotEmpty(t, restartedAt)
+	assert.Empty(t, getEnvVarValue(deployment.Spec.Template.Spec.Containers[0].Env, getEnvVarName(config.ResourceName, config.Type)))
+
+	// The annotation is applied server-side with the type meta the typed objects lack
+	assert.Equal(t, patchtypes.ApplyPatchType, result.Patch.Type)
+	var applyConfiguration map[string]any
+	assert.NoError(t, json.Unmarshal(result.Patch.Bytes, &applyConfiguration))
+	assert.Equal(t, "apps/v1", applyConfiguration["apiVersion"])
+	assert.Equal(t, "Deployment", applyConfiguration["kind"])
+
+	err := deploymentFuncs.PatchFunc(kube.Clients{KubernetesClient: client}, "restart", deployment, result.Patch.Type, result.Patch.Bytes)
+	assert.NoError(t, err)
+	patchAction, ok := client.Actions()[len(client.Actions())-1].(clienttesting.PatchActionImpl)
+	if assert.True(t, ok) {
+		assert.Equal(t, "Reloader", patchAction.GetPatchOptions().FieldManager)
+		assert.True(t, *patchAction.GetPatchOptions().Force)
+	}
+	current, err := client.AppsV1().Deployments("restart").Get(context.TODO(), deployment.Name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, restartedAt, current.Spec.Template.Annotations[constants.RestartedAtAnnotation])
+	assert.Equal(t, "CONFIGMAP/restart-deployment=sha", current.Spec.Template.Annotations[options.RestartedForAnnotation])
+
+	// The workload is restarted once per change, so retries and resyncs do not restart it again
+	result = invokeReloadStrategy(deploymentFuncs, current, config, true)
+	assert.Equal(t, constants.NotUpdated, result.Result)
+	assert.Nil(t, result.Patch)
+
+	// Changes of other configmaps and later changes restart it again, the hashes of all configmaps are kept
+	other := config
+	other.ResourceName = "other-configmap"
+	result = invokeReloadStrategy(deploymentFuncs, current, other, false)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Equal(t, "CONFIGMAP/other-configmap=sha,CONFIGMAP/restart-deployment=sha", current.Spec.Template.Annotations[options.RestartedForAnnotation])
+	assert.Contains(t, string(result.Patch.Bytes), "CONFIGMAP/other-configmap=sha,CONFIGMAP/restart-deployment=sha")
+	config.SHAValue = "changed-sha"
+	result = invokeReloadStrategy(deploymentFuncs, current, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+
+	// Workloads which can not be patched are updated
+	cronJob := testutil.GetCronJob("restart", "restart-cronjob")
+	config.ResourceName = "restart-cronjob"
+	result = invokeReloadStrategy(GetCronJobCreateJobFuncs(), cronJob, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Nil(t, result.Patch)
+	assert.NotEmpty(t, cronJob.Spec.JobTemplate.Spec.Template.Annotations[constants.RestartedAtAnnotation])
+}
+
+func TestWorkloadReloadStrategy(t *testing.T) {
+	config := util.Config{
+		Namespace:    "strategy",
+		ResourceName: "strategy-deployment",
+		Type:         constants.ConfigmapEnvVarPostfix,
+		SHAValue:     "sha",
+	}
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	envVarName := getEnvVarName(config.ResourceName, config.Type)
+
+	// The annotation of the workload overrides the global strategy
+	deployment := testutil.GetDeployment("strategy", "strategy-deployment")
+	deployment.Annotations[options.ReloadStrategyAnnotation] = constants.AnnotationsReloadStrategy
+	result := invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Contains(t, deployment.Spec.Template.Annotations, getReloaderAnnotationKey())
+	assert.Empty(t, getEnvVarValue(deployment.Spec.Template.Spec.Containers[0].Env, envVarName))
+
+	result = invokeDeleteStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Contains(t, deployment.Spec.Template.Annotations[getReloaderAnnotationKey()], testutil.GetSHAfromEmptyData())
+
+	// The annotation of the pod template is honored as well
+	deployment = testutil.GetDeployment("strategy", "strategy-deployment")
+	deployment.Spec.Template.Annotations = map[string]string{options.ReloadStrategyAnnotation: constants.RestartReloadStrategy}
+	result = invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Contains(t, deployment.Spec.Template.Annotations, constants.RestartedAtAnnotation)
+
+	// A ReloadPolicy takes precedence over the annotation
+	deployment = testutil.GetDeployment("strategy", "strategy-deployment")
+	deployment.Annotations[options.ReloadStrategyAnnotation] = constants.AnnotationsReloadStrategy
+	policyConfig := config
+	policyConfig.ReloadStrategy = constants.EnvVarsReloadStrategy
+	result = invokeReloadStrategy(deploymentFuncs, deployment, policyConfig, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Equal(t, "sha", getEnvVarValue(deployment.Spec.Template.Spec.Containers[0].Env, envVarName))
+	assert.NotContains(t, deployment.Spec.Template.Annotations, getReloaderAnnotationKey())
+
+	// Invalid values are reported on the workload, which is reloaded with the global strategy
+	deployment = testutil.GetDeployment("strategy", "strategy-deployment")
+	deployment.Annotations[options.ReloadStrategyAnnotation] = "rolling"
+	client := testclient.NewSimpleClientset(deployment.DeepCopy())
+	recorder := record.NewFakeRecorder(10)
+	result = invokeReloadStrategy(deploymentFuncs, deployment, config, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.Equal(t, "sha", getEnvVarValue(deployment.Spec.Template.Spec.Containers[0].Env, envVarName))
+
+	err := applyReload(kube.Clients{KubernetesClient: client}, []util.Config{config}, deploymentFuncs, getCollectors(), recorder, deployment, deployment.Name, result.Patch)
+	assert.NoError(t, err)
+	event := <-recorder.Events
+	assert.Contains(t, event, "InvalidReloadStrategy")
+	assert.Contains(t, event, "'rolling'")
+	assert.Contains(t, <-recorder.Events, "Reloaded")
+}
+
+func TestIsStale(t *testing.T) {
+	defer func(strategy string) {
+		options.ReloadStrategy = strategy
+	}(options.ReloadStrategy)
+
+	previous := util.Config{
+		Namespace:    "catch-up",
+		ResourceName: "catch-up-deployment",
+		Type:         constants.ConfigmapEnvVarPostfix,
+		SHAValue:     "previous-sha",
+	}
+	current := previous
+	current.SHAValue = "current-sha"
+	other := previous
+	other.ResourceName = "other-configmap"
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+
+	// Workloads which were never reloaded for the configmap are left alone
+	deployment := testutil.GetDeployment("catch-up", "catch-up-deployment")
+	assert.False(t, isStale(deploymentFuncs, deployment, current))
+
+	for _, strategy := range []string{constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, constants.SignalReloadStrategy, constants.RestartReloadStrategy} {
+		t.Run(strategy, func(t *testing.T) {
+			options.ReloadStrategy = strategy
+			deployment := testutil.GetDeployment("catch-up", "catch-up-deployment")
+			result := invokeReloadStrategy(deploymentFuncs, deployment, previous, true)
+			assert.Equal(t, constants.Updated, result.Result)
+
+			assert.False(t, isStale(deploymentFuncs, deployment, previous))
+			assert.True(t, isStale(deploymentFuncs, deployment, current))
+			assert.False(t, isStale(deploymentFuncs, deployment, other), "hashes of other configmaps must not be compared")
+		})
+	}
+
+	// A workload is up to date if any strategy recorded the current hash, e.g. after the strategy was changed
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+	deployment = testutil.GetDeployment("catch-up", "catch-up-deployment")
+	invokeReloadStrategy(deploymentFuncs, deployment, previous, true)
+	options.ReloadStrategy = constants.AnnotationsReloadStrategy
+	invokeReloadStrategy(deploymentFuncs, deployment, current, true)
+	assert.False(t, isStale(deploymentFuncs, deployment, current))
+}
+
+func TestIsStaleConsumedKeys(t *testing.T) {
+	defer func(strategy string) {
+		options.ReloadStrategy = strategy
+	}(options.ReloadStrategy)
+	options.ReloadStrategy = constants.EnvVarsReloadStrategy
+
+	configmap := testutil.GetConfigmap("catch-up", "catch-up-deployment", "www.google.com")
+	configmap.Data["other"] = "value"
+	previous := util.GetConfigmapConfig(configmap)
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	deployment := testutil.GetDeployment("catch-up", "catch-up-deployment")
+	deployment.Annotations[options.ConfigmapReloadKeysAnnotation] = "catch-up-deployment/test.url"
+	client := testclient.NewSimpleClientset(deployment.DeepCopy())
+	clients := kube.Clients{KubernetesClient: client}
+
+	result := invokeReloadStrategy(deploymentFuncs, deployment, previous, true)
+	assert.Equal(t, constants.Updated, result.Result)
+	assert.NoError(t, applyReload(clients, []util.Config{previous}, deploymentFuncs, getCollectors(), nil, deployment, deployment.Name, result.Patch))
+	reloaded, err := client.AppsV1().Deployments("catch-up").Get(context.TODO(), "catch-up-deployment", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, reloaded.Annotations, options.ConsumedHashesAnnotation)
+
+	// Changes of other keys were skipped while running, they are not caught up on start
+	configmap.Data["other"] = "changed"
+	assert.False(t, isStale(deploymentFuncs, reloaded, util.GetConfigmapConfig(configmap)))
+
+	configmap.Data["test.url"] = "www.stakater.com"
+	assert.True(t, isStale(deploymentFuncs, reloaded, util.GetConfigmapConfig(configmap)))
+
+	// Without a recorded hash of the consumed keys, any change is caught up
+	recorded := reloaded.Annotations[options.ConsumedHashesAnnotation]
+	delete(reloaded.Annotations, options.ConsumedHashesAnnotation)
+	configmap.Data["test.url"] = "www.google.com"
+	assert.True(t, isStale(deploymentFuncs, reloaded, util.GetConfigmapConfig(configmap)))
+
+	// The hash is removed once the workload consumes the whole configmap
+	reloaded.Annotations[options.ConsumedHashesAnnotation] = recorded
+	delete(reloaded.Annotations, options.ConfigmapReloadKeysAnnotation)
+	value, changed := getConsumedHashesUpdate(deploymentFuncs, reloaded, []util.Config{previous})
+	assert.True(t, changed)
+	assert.Empty(t, value)
+}
+
+func TestHasPendingReload(t *testing.T) {
+	config := util.Config{Namespace: "pending", ResourceName: "pending-configmap", Type: constants.ConfigmapEnvVarPostfix}
+	other := config
+	other.ResourceName = "other-configmap"
+	deploymentFuncs := GetDeploymentRollingUpgradeFuncs()
+	target := reloadTarget{upgradeFuncs: deploymentFuncs, item: testutil.GetDeployment("pending", "pending-deployment")}
+	assert.False(t, hasPendingReload(target, config))
+
+	debounced := &pendingReload{upgradeFuncs: deploymentFuncs, namespace: "pending", name: "pending-deployment", changes: []pendingChange{{config: config}}}
+	reloadDebouncer.mu.Lock()
+	reloadDebouncer.pending[debounced.key()] = debounced
+	reloadDebouncer.mu.Unlock()
+	assert.True(t, hasPendingReload(target, config))
+	assert.False(t, hasPendingReload(target, other), "changes of other configmaps must not be considered")
+	reloadDebouncer.mu.Lock()
+	delete(reloadDebouncer.pending, debounced.key())
+	reloadDebouncer.mu.Unlock()
+
+	reloadDeferrer.mu.Lock()
+	reloadDeferrer.pending[debounced.key()] = &deferredReload{Kind: "Deployment", Namespace: "pending", Name: "pending-deployment", Sources: []util.Config{config}}
+	reloadDeferrer.mu.Unlock()
+	defer func() {
+		reloadDeferrer.mu.Lock()
+		delete(reloadDeferrer.pending, debounced.key())
+		reloadDeferrer.mu.Unlock()
+	}()
+	assert.True(t, hasPendingReload(target, config))
+	assert.False(t, hasPendingReload(target, other))
+}
+
+func TestReportDrift(t *testing.T) {
+	config := util.Config{
+		Namespace:    "drift",
+		ResourceName: "drift-configmap",
+		Type:         constants.ConfigmapEnvVarPostfix,
+		SHAValue:     "current-sha",
+	}
+	deployment := testutil.GetDeployment("drift", "drift-deployment")
+	recorder := record.NewFakeRecorder(10)
+	reconcile := ResourceReconcileHandler{Collectors: getCollectors(), Recorder: recorder, Drift: true}
+
+	reconcile.reportDrift(config, reloadTarget{upgradeFuncs: GetDeploymentRollingUpgradeFuncs(), item: deployment})
+	event := <-recorder.Events
+	assert.Contains(t, event, "ReloadDrift")
+	assert.Contains(t, event, "'drift-deployment' of type 'Deployment'")
+	assert.Equal(t, float64(1), promtestutil.ToFloat64(reconcile.Collectors.DriftDetections.With(prometheus.Labels{"workload_type": "Deployment", "namespace": "drift"})))
+}
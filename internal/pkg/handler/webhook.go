@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/parnurzeal/gorequest"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+// WebhookPayload is the body sent to the webhook url, a custom body can be rendered from it with a template
+type WebhookPayload struct {
+	// Webhook is kept for receivers relying on the previous fixed body
+	Webhook      string            `json:"webhook"`
+	Kind         string            `json:"kind"`
+	ResourceName string            `json:"resourceName"`
+	Namespace    string            `json:"namespace"`
+	Hash         string            `json:"hash"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Timestamp    time.Time         `json:"timestamp"`
+	Targets      []WebhookTarget   `json:"targets"`
+}
+
+// WebhookTarget is a workload which would be reloaded by the change
+type WebhookTarget struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// ValidateWebhookOptions checks that the webhook payload template and headers can be parsed
+func ValidateWebhookOptions() error {
+	if options.WebhookPayloadTemplate != "" {
+		if _, err := parseWebhookTemplate(options.WebhookPayloadTemplate); err != nil {
+			return err
+		}
+	}
+	_, err := getWebhookHeaders(options.WebhookHeaders, nil, "")
+	return err
+}
+
+func sendUpgradeWebhook(config util.Config, webhookUrl string) error {
+	logrus.Infof("Changes detected in '%s' of type '%s' in namespace '%s', Sending webhook to '%s'",
+		config.ResourceName, config.Type, config.Namespace, webhookUrl)
+
+	payload, err := newWebhookPayload(kube.GetClients(), config)
+	if err != nil {
+		return err
+	}
+
+	body, err := renderWebhookBody(payload, options.WebhookPayloadTemplate)
+	if err != nil {
+		return err
+	}
+
+	headers, err := getWebhookHeaders(options.WebhookHeaders, body, os.Getenv(constants.WebhookHMACSecretEnv))
+	if err != nil {
+		return err
+	}
+
+	response, errs := sendWebhook(webhookUrl, body, headers)
+	if errs != nil {
+		// return the first error
+		return errs[0]
+	} else {
+		logrus.Info(response)
+	}
+
+	return nil
+}
+
+// newWebhookPayload builds the payload for the change including the workloads it would reload
+func newWebhookPayload(clients kube.Clients, config util.Config) (WebhookPayload, error) {
+	payload := WebhookPayload{
+		Webhook:      "update successful",
+		Kind:         config.Type,
+		ResourceName: config.ResourceName,
+		Namespace:    config.Namespace,
+		Hash:         config.SHAValue,
+		Annotations:  config.ResourceAnnotations,
+		Timestamp:    time.Now().UTC(),
+		Targets:      []WebhookTarget{},
+	}
+
+	upgradeFuncs, err := getRollingUpgradeFuncs()
+	if err != nil {
+		return payload, err
+	}
+
+	for _, funcs := range upgradeFuncs {
+		for _, item := range getItems(clients, config, funcs) {
+			// Items are fetched for this lookup only, the strategy is invoked to resolve the target and the changes are discarded
+			if invokeStrategyForResource(funcs, config, invokeReloadStrategy, item).Result != constants.Updated {
+				continue
+			}
+			accessor, err := meta.Accessor(item)
+			if err != nil {
+				return payload, err
+			}
+			payload.Targets = append(payload.Targets, WebhookTarget{
+				Kind:      funcs.ResourceType,
+				Name:      accessor.GetName(),
+				Namespace: accessor.GetNamespace(),
+			})
+		}
+	}
+
+	return payload, nil
+}
+
+// renderWebhookBody renders the payload as JSON or with the template file if one is configured
+func renderWebhookBody(payload WebhookPayload, templatePath string) ([]byte, error) {
+	if templatePath == "" {
+		return json.Marshal(payload)
+	}
+
+	tmpl, err := parseWebhookTemplate(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render webhook payload template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// parseWebhookTemplate parses the webhook payload template, it is read on every change so updates to a mounted file apply without a restart
+func parseWebhookTemplate(templatePath string) (*template.Template, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook payload template: %w", err)
+	}
+
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"toJson": func(value interface{}) (string, error) {
+			content, err := json.Marshal(value)
+			return string(content), err
+		},
+	}).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse webhook payload template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// getWebhookHeaders parses the custom headers in the format Name=Value and adds the signature of the body if a secret is set
+func getWebhookHeaders(values []string, body []byte, secret string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, value := range values {
+		name, headerValue, found := strings.Cut(value, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid webhook header '%s', expected format Name=Value", value)
+		}
+		headers[name] = strings.TrimSpace(headerValue)
+	}
+
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		headers[constants.WebhookSignatureHeader] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	return headers, nil
+}
+
+func sendWebhook(url string, body []byte, headers map[string]string) (string, []error) {
+	request := gorequest.New().Post(url)
+	for name, value := range headers {
+		request.Set(name, value)
+	}
+	// Send the body as is, otherwise JSON is decoded and encoded again which would invalidate the signature
+	request.BounceToRawString = true
+	resp, _, err := request.Send(string(body)).End()
+	if err != nil {
+		// the reloader seems to retry automatically so no retry logic added
+		return "", err
+	}
+	defer resp.Body.Close()
+	var buffer bytes.Buffer
+	_, bufferErr := io.Copy(&buffer, resp.Body)
+	if bufferErr != nil {
+		logrus.Error(bufferErr)
+	}
+	return buffer.String(), nil
+}
@@ -0,0 +1,522 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/callbacks"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/metrics"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/schedule"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stakater/Reloader/pkg/kube"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+)
+
+// deferredReloadsKey is the key of the deferred reloads in the configmap persisting them
+const deferredReloadsKey = "reloads"
+
+var (
+	// deferredReloadInterval is the interval the deferred reloads are checked at
+	deferredReloadInterval = time.Minute
+	reloadDeferrer         = &deferrer{pending: map[string]*deferredReload{}}
+)
+
+// deferredReload is a reload of a workload waiting for its reload window to open or the freeze of its namespace to
+// be lifted
+type deferredReload struct {
+	Kind      string        `json:"kind"`
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	Sources   []util.Config `json:"sources"`
+}
+
+// deferrer keeps the deferred reloads and applies them once they are no longer deferred
+type deferrer struct {
+	mu      sync.Mutex
+	pending map[string]*deferredReload
+	started bool
+	// dirty is set when the reloads changed since they were last persisted, persisting is set while they are written
+	dirty      bool
+	persisting bool
+}
+
+// namespaceLister reads the namespaces from an informer backed cache, they are read from the API server if unset
+var namespaceLister atomic.Pointer[corev1listers.NamespaceLister]
+
+// SetNamespaceLister sets the lister the namespaces checked for deferred reloads are read from, nil reads them from
+// the API server
+func SetNamespaceLister(lister corev1listers.NamespaceLister) {
+	if lister == nil {
+		namespaceLister.Store(nil)
+		return
+	}
+	namespaceLister.Store(&lister)
+}
+
+// ValidateReloadWindowOptions checks that the global reload window can be parsed
+func ValidateReloadWindowOptions() error {
+	if options.ReloadWindow == "" {
+		return nil
+	}
+	_, err := schedule.NewWindow(options.ReloadWindow, options.ReloadWindowDuration, options.ReloadWindowTimezone)
+	return err
+}
+
+// forbiddenNamespaces are the namespaces Reloader is not allowed to get, the missing permission is only reported once
+var forbiddenNamespaces sync.Map
+
+// getNamespace returns the namespace to check for a freeze and its reload window, nil if it cannot be read. It is read
+// from the API server if it is not in the cache of the lister yet
+func getNamespace(clients kube.Clients, name string) *v1.Namespace {
+	if lister := namespaceLister.Load(); lister != nil {
+		if namespace, err := (*lister).Get(name); err == nil {
+			return namespace
+		}
+	}
+
+	namespace, err := clients.KubernetesClient.CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsForbidden(err) {
+		if _, reported := forbiddenNamespaces.LoadOrStore(name, true); !reported {
+			logrus.Warnf("Ignoring the freeze and reload window of namespace '%s', Reloader is not allowed to get it: %v", name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		logrus.Warnf("Ignoring the freeze and reload window of namespace '%s', failed to get it: %v", name, err)
+		return nil
+	}
+	return namespace
+}
+
+// getDeferralReason returns why the reload of the workload is deferred, empty if it can be reloaded now
+func getDeferralReason(namespace *v1.Namespace, upgradeFuncs callbacks.RollingUpgradeFuncs, item runtime.Object, now time.Time) string {
+	var namespaceAnnotations map[string]string
+	if namespace != nil {
+		namespaceAnnotations = namespace.Annotations
+		if namespaceAnnotations[options.FreezeAnnotation] == "true" {
+			return fmt.Sprintf("namespace '%s' is frozen", namespace.Name)
+		}
+	}
+
+	window := getReloadWindow(upgradeFuncs.AnnotationsFunc(item), namespaceAnnotations)
+	if window == nil || window.Open(now) {
+		return ""
+	}
+	next := window.NextOpen(now)
+	if next.IsZero() {
+		return "the reload window never opens"
+	}
+	return fmt.Sprintf("the reload window opens at %s", next.Format(time.RFC3339))
+}
+
+// getReloadWindow returns the reload window of the workload, its namespace or the global one, in this order
+func getReloadWindow(workloadAnnotations map[string]string, namespaceAnnotations map[string]string) *schedule.Window {
+	for _, annotations := range []map[string]string{workloadAnnotations, namespaceAnnotations} {
+		expression := annotations[options.ReloadWindowAnnotation]
+		if expression == "" {
+			continue
+		}
+
+		duration := options.ReloadWindowDuration
+		if value := annotations[options.ReloadWindowDurationAnnotation]; value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				logrus.Warnf("Invalid value '%s' for annotation '%s', using the default duration", value, options.ReloadWindowDurationAnnotation)
+			} else {
+				duration = parsed
+			}
+		}
+		timezone := options.ReloadWindowTimezone
+		if value := annotations[options.ReloadWindowTimezoneAnnotation]; value != "" {
+			timezone = value
+		}
+
+		window, err := schedule.NewWindow(expression, duration, timezone)
+		if err != nil {
+			logrus.Warnf("Ignoring invalid reload window: %v", err)
+			continue
+		}
+		return window
+	}
+
+	if options.ReloadWindow == "" {
+		return nil
+	}
+	window, err := schedule.NewWindow(options.ReloadWindow, options.ReloadWindowDuration, options.ReloadWindowTimezone)
+	if err != nil {
+		logrus.Warnf("Ignoring invalid reload window: %v", err)
+		return nil
+	}
+	return window
+}
+
+// deferReload queues the change for the workload if the strategy would reload it and records why it is deferred
+func deferReload(clients kube.Clients, config util.Config, upgradeFuncs callbacks.RollingUpgradeFuncs, recorder record.EventRecorder, strategy invokeStrategy, item runtime.Object, reason string) error {
+	// Evaluate on a copy, the changes are applied to a freshly fetched workload once the reload is no longer deferred
+	if invokeStrategyForResource(upgradeFuncs, config, strategy, item.DeepCopyObject()).Result != constants.Updated {
+		return nil
+	}
+
+	accessor, err := meta.Accessor(item)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Reload of '%s' of type '%s' in namespace '%s' for changes in '%s' of type '%s' deferred, %s",
+		accessor.GetName(), upgradeFuncs.ResourceType, config.Namespace, config.ResourceName, config.Type, reason)
+	logrus.Info(message)
+	if recorder != nil {
+		recorder.Event(item, v1.EventTypeNormal, "ReloadDeferred", message)
+	}
+
+	reloadDeferrer.add(clients, &deferredReload{
+		Kind:      upgradeFuncs.ResourceType,
+		Namespace: config.Namespace,
+		Name:      accessor.GetName(),
+		Sources:   []util.Config{config},
+	})
+	return nil
+}
+
+func (d *deferrer) add(clients kube.Clients, reload *deferredReload) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s/%s", reload.Kind, reload.Namespace, reload.Name)
+	pending, found := d.pending[key]
+	if !found {
+		d.pending[key] = reload
+	} else {
+		for _, source := range reload.Sources {
+			pending.addSource(source)
+		}
+	}
+	d.persist(clients)
+}
+
+// addSource adds the change of a configmap or secret, only its latest change has to be applied
+func (r *deferredReload) addSource(config util.Config) {
+	for i := range r.Sources {
+		if r.Sources[i].Type == config.Type && r.Sources[i].ResourceName == config.ResourceName {
+			r.Sources[i] = config
+			return
+		}
+	}
+	r.Sources = append(r.Sources, config)
+}
+
+// hasPending returns whether a change of the configmap or secret is deferred for the workload with the key
+func (d *deferrer) hasPending(key string, config util.Config) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending, found := d.pending[key]
+	return found && slices.ContainsFunc(pending.Sources, func(source util.Config) bool {
+		return source.Type == config.Type && source.ResourceName == config.ResourceName
+	})
+}
+
+// persisted returns the reload with the references of its sources only. Their content and metadata are read again
+// when the reload is applied, they must not be written to the configmap, e.g. the last applied configuration of a
+// secret
+func (r *deferredReload) persisted() *deferredReload {
+	sources := make([]util.Config, len(r.Sources))
+	for i, source := range r.Sources {
+		sources[i] = util.Config{
+			Namespace:      source.Namespace,
+			ResourceName:   source.ResourceName,
+			Type:           source.Type,
+			ReloadStrategy: source.ReloadStrategy,
+		}
+	}
+	return &deferredReload{Kind: r.Kind, Namespace: r.Namespace, Name: r.Name, Sources: sources}
+}
+
+// restoreSource completes a persisted source with the annotations of its type, so its deletion can still be applied
+func restoreSource(source util.Config) util.Config {
+	objectMeta := metav1.ObjectMeta{Name: source.ResourceName, Namespace: source.Namespace}
+	config := util.GetConfigmapConfig(&v1.ConfigMap{ObjectMeta: objectMeta})
+	if source.Type == constants.SecretEnvVarPostfix {
+		config = util.GetSecretConfig(&v1.Secret{ObjectMeta: objectMeta})
+	}
+	// The content is unknown until the source is read again
+	config.SHAValue = source.SHAValue
+	config.KeySHAs = nil
+	config.ReloadStrategy = source.ReloadStrategy
+	return config
+}
+
+// RunDeferredReloads loads the persisted deferred reloads and applies them once they are no longer deferred, only
+// the first call starts the processing
+func RunDeferredReloads(collectors metrics.Collectors, recorder record.EventRecorder, stopCh <-chan struct{}) {
+	reloadDeferrer.mu.Lock()
+	defer reloadDeferrer.mu.Unlock()
+	if reloadDeferrer.started {
+		return
+	}
+	reloadDeferrer.started = true
+
+	clients := kube.GetClients()
+	reloadDeferrer.load(clients)
+	go func() {
+		ticker := time.NewTicker(deferredReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				reloadDeferrer.process(clients, collectors, recorder, time.Now())
+			}
+		}
+	}()
+}
+
+// process applies the deferred reloads which are no longer deferred and keeps the others
+func (d *deferrer) process(clients kube.Clients, collectors metrics.Collectors, recorder record.EventRecorder, now time.Time) {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = map[string]*deferredReload{}
+	d.mu.Unlock()
+
+	upgradeFuncs, err := getRollingUpgradeFuncs()
+	if err != nil {
+		logrus.Errorf("Failed to get the workload types for deferred reloads: %v", err)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for key, reload := range pending {
+			d.pending[key] = reload
+		}
+		return
+	}
+	funcsByKind := map[string]callbacks.RollingUpgradeFuncs{}
+	for _, funcs := range upgradeFuncs {
+		funcsByKind[funcs.ResourceType] = funcs
+	}
+
+	keep := map[string]*deferredReload{}
+	namespaces := map[string]*v1.Namespace{}
+	for key, reload := range pending {
+		funcs, found := funcsByKind[reload.Kind]
+		if !found {
+			logrus.Warnf("Dropping deferred reload of '%s' of unknown type '%s' in namespace '%s'", reload.Name, reload.Kind, reload.Namespace)
+			continue
+		}
+
+		item, err := funcs.ItemFunc(clients, reload.Name, reload.Namespace)
+		if apierrors.IsNotFound(err) {
+			logrus.Infof("Dropping deferred reload of '%s' of type '%s' in namespace '%s', it no longer exists", reload.Name, reload.Kind, reload.Namespace)
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("Failed to get '%s' of type '%s' in namespace '%s' for its deferred reload: %v", reload.Name, reload.Kind, reload.Namespace, err)
+			keep[key] = reload
+			continue
+		}
+
+		if _, found := namespaces[reload.Namespace]; !found {
+			namespaces[reload.Namespace] = getNamespace(clients, reload.Namespace)
+		}
+		if getDeferralReason(namespaces[reload.Namespace], funcs, item, now) != "" {
+			keep[key] = reload
+			continue
+		}
+
+		logrus.Infof("Applying deferred reload of '%s' of type '%s' in namespace '%s'", reload.Name, reload.Kind, reload.Namespace)
+		err = reload.pendingReload(clients, funcs, collectors, recorder).reload()
+		if isRequeue(err) {
+			// The reload waits for a rollout slot, it is applied with the next check
+			logrus.Infof("Deferred reload of '%s' of type '%s' in namespace '%s' waits: %v", reload.Name, reload.Kind, reload.Namespace, err)
+			keep[key] = reload
+			continue
+		}
+		if err != nil {
+			logrus.Errorf("Deferred reload of '%s' of type '%s' in namespace '%s' failed with error = %v", reload.Name, reload.Kind, reload.Namespace, err)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Reloads deferred while processing are merged into the kept ones
+	for key, reload := range d.pending {
+		if kept, found := keep[key]; found {
+			for _, source := range reload.Sources {
+				kept.addSource(source)
+			}
+		} else {
+			keep[key] = reload
+		}
+	}
+	d.pending = keep
+	if len(pending) > 0 {
+		d.persist(clients)
+	}
+}
+
+// pendingReload converts the deferred reload into a reload applying the latest content of its sources, a source which
+// was deleted since is applied with the delete strategy if reloads on delete are enabled
+func (r *deferredReload) pendingReload(clients kube.Clients, upgradeFuncs callbacks.RollingUpgradeFuncs, collectors metrics.Collectors, recorder record.EventRecorder) *pendingReload {
+	reload := &pendingReload{
+		clients:      clients,
+		upgradeFuncs: upgradeFuncs,
+		collectors:   collectors,
+		recorder:     recorder,
+		namespace:    r.Namespace,
+		name:         r.Name,
+	}
+
+	for _, source := range r.Sources {
+		config, err := getCurrentConfig(clients, source)
+		switch {
+		case err == nil:
+			config.ReloadStrategy = source.ReloadStrategy
+			reload.changes = append(reload.changes, pendingChange{config: config, strategy: invokeReloadStrategy})
+		case apierrors.IsNotFound(err) && options.ReloadOnDelete == "true":
+			reload.changes = append(reload.changes, pendingChange{config: source, strategy: invokeDeleteStrategy})
+		case !apierrors.IsNotFound(err):
+			logrus.Errorf("Failed to get '%s' of type '%s' in namespace '%s' for a deferred reload: %v", source.ResourceName, source.Type, source.Namespace, err)
+		}
+	}
+	return reload
+}
+
+// getCurrentConfig returns the config of the current content of the configmap or secret
+func getCurrentConfig(clients kube.Clients, source util.Config) (util.Config, error) {
+	if source.Type == constants.SecretEnvVarPostfix {
+		secret, err := clients.KubernetesClient.CoreV1().Secrets(source.Namespace).Get(context.TODO(), source.ResourceName, metav1.GetOptions{})
+		if err != nil {
+			return source, err
+		}
+		return util.GetSecretConfig(secret), nil
+	}
+	configmap, err := clients.KubernetesClient.CoreV1().ConfigMaps(source.Namespace).Get(context.TODO(), source.ResourceName, metav1.GetOptions{})
+	if err != nil {
+		return source, err
+	}
+	return util.GetConfigmapConfig(configmap), nil
+}
+
+// persist stores the deferred reloads in the configmap so they survive a restart, the caller holds the lock. They
+// are written in the background, so callers are not held up by the API server
+func (d *deferrer) persist(clients kube.Clients) {
+	namespace := os.Getenv(constants.PodNamespaceEnv)
+	if options.DeferredReloadsConfigMap == "" || namespace == "" || options.DryRun {
+		return
+	}
+
+	d.dirty = true
+	if d.persisting {
+		return
+	}
+	d.persisting = true
+	go d.write(clients, namespace)
+}
+
+// write persists the latest deferred reloads until they did not change while they were written
+func (d *deferrer) write(clients kube.Clients, namespace string) {
+	for {
+		d.mu.Lock()
+		if !d.dirty {
+			d.persisting = false
+			d.mu.Unlock()
+			return
+		}
+		d.dirty = false
+		reloads := make([]*deferredReload, 0, len(d.pending))
+		for _, reload := range d.pending {
+			reloads = append(reloads, reload.persisted())
+		}
+		content, err := json.Marshal(reloads)
+		d.mu.Unlock()
+
+		if err != nil {
+			logrus.Errorf("Failed to persist deferred reloads: %v", err)
+			continue
+		}
+		d.writeConfigMap(clients, namespace, content)
+	}
+}
+
+// writeConfigMap stores the content in the configmap persisting the deferred reloads
+func (d *deferrer) writeConfigMap(clients kube.Clients, namespace string, content []byte) {
+	configmaps := clients.KubernetesClient.CoreV1().ConfigMaps(namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := configmaps.Get(context.TODO(), options.DeferredReloadsConfigMap, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			configmap = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: options.DeferredReloadsConfigMap, Namespace: namespace},
+				Data:       map[string]string{deferredReloadsKey: string(content)},
+			}
+			_, err = configmaps.Create(context.TODO(), configmap, metav1.CreateOptions{FieldManager: "Reloader"})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		configmap.Data = map[string]string{deferredReloadsKey: string(content)}
+		_, err = configmaps.Update(context.TODO(), configmap, metav1.UpdateOptions{FieldManager: "Reloader"})
+		return err
+	})
+	if err != nil {
+		logrus.Errorf("Failed to persist deferred reloads in configmap '%s' in namespace '%s': %v", options.DeferredReloadsConfigMap, namespace, err)
+	}
+}
+
+// load restores the persisted deferred reloads, the caller holds the lock
+func (d *deferrer) load(clients kube.Clients) {
+	namespace := os.Getenv(constants.PodNamespaceEnv)
+	if options.DeferredReloadsConfigMap == "" || namespace == "" {
+		return
+	}
+
+	configmap, err := clients.KubernetesClient.CoreV1().ConfigMaps(namespace).Get(context.TODO(), options.DeferredReloadsConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to load deferred reloads from configmap '%s' in namespace '%s': %v", options.DeferredReloadsConfigMap, namespace, err)
+		return
+	}
+
+	content := configmap.Data[deferredReloadsKey]
+	if content == "" {
+		return
+	}
+	var reloads []*deferredReload
+	err = json.Unmarshal([]byte(content), &reloads)
+	if err != nil {
+		logrus.Errorf("Failed to parse deferred reloads from configmap '%s' in namespace '%s': %v", options.DeferredReloadsConfigMap, namespace, err)
+		return
+	}
+	for _, reload := range reloads {
+		for i, source := range reload.Sources {
+			reload.Sources[i] = restoreSource(source)
+		}
+		key := fmt.Sprintf("%s/%s/%s", reload.Kind, reload.Namespace, reload.Name)
+		if pending, found := d.pending[key]; found {
+			for _, source := range reload.Sources {
+				pending.addSource(source)
+			}
+			continue
+		}
+		d.pending[key] = reload
+	}
+	logrus.Infof("Loaded %d deferred reloads", len(reloads))
+}
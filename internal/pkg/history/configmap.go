@@ -0,0 +1,197 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// NameSuffix is appended to the name of a ConfigMap to name the ConfigMap holding its history
+	NameSuffix = "-reloader-history"
+	// HistoryOfLabel is set on the history ConfigMap to the name of the ConfigMap it belongs to
+	HistoryOfLabel = "reloader.stakater.com/history-of"
+	// orderAnnotation lists the hashes of the stored revisions from the oldest to the latest
+	orderAnnotation = "reloader.stakater.com/history-order"
+	// maxSize is the maximum size of the revisions in the history ConfigMap, it leaves room for the metadata below
+	// the size limit of 1MiB of an object
+	maxSize = 900 * 1024
+)
+
+// Revision is a previous content of a ConfigMap
+type Revision struct {
+	Data       map[string]string `json:"data,omitempty"`
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// Enabled returns whether the history of the ConfigMap is recorded
+func Enabled(configmap *v1.ConfigMap) bool {
+	return configmap.Annotations[options.ConfigmapHistoryAnnotation] == "true"
+}
+
+// Name returns the name of the ConfigMap holding the history of the ConfigMap
+func Name(configmapName string) string {
+	return configmapName + NameSuffix
+}
+
+// Record stores the content of the previous ConfigMap keyed by its hash, the oldest revisions are removed once the
+// limit or the size of the history is exceeded. A content too large to be stored clears the history, so an older
+// revision is not mistaken for it. The history is owned by the ConfigMap and deleted together with it
+func Record(client kubernetes.Interface, previous *v1.ConfigMap, hash string, limit int) error {
+	content, err := json.Marshal(Revision{Data: previous.Data, BinaryData: previous.BinaryData, Timestamp: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	var recordErr error
+	configmaps := client.CoreV1().ConfigMaps(previous.Namespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		history, err := configmaps.Get(context.TODO(), Name(previous.Name), metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			history = newHistory(previous)
+			recordErr = addRevision(history, hash, string(content), limit)
+			_, err = configmaps.Create(context.TODO(), history, metav1.CreateOptions{FieldManager: "Reloader"})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		recordErr = addRevision(history, hash, string(content), limit)
+		_, err = configmaps.Update(context.TODO(), history, metav1.UpdateOptions{FieldManager: "Reloader"})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return recordErr
+}
+
+// Latest returns the most recently recorded revision of the ConfigMap and its hash
+func Latest(client kubernetes.Interface, namespace string, name string) (Revision, string, error) {
+	var revision Revision
+	history, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), Name(name), metav1.GetOptions{})
+	if err != nil {
+		return revision, "", err
+	}
+
+	order := getOrder(history)
+	if len(order) == 0 {
+		return revision, "", fmt.Errorf("no revision recorded for configmap '%s' in namespace '%s'", name, namespace)
+	}
+	hash := order[len(order)-1]
+	err = json.Unmarshal([]byte(history.Data[hash]), &revision)
+	return revision, hash, err
+}
+
+// Revert restores the latest recorded revision of the ConfigMap if its content still has the given hash and returns
+// the hash of the restored revision. A content restored by a revert is not reverted again
+func Revert(client kubernetes.Interface, namespace string, name string, hash string) (string, error) {
+	var restored string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configmap, err := client.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if util.GetSHAfromConfigmap(configmap) != hash {
+			return fmt.Errorf("configmap '%s' in namespace '%s' changed since the reload", name, namespace)
+		}
+		if configmap.Annotations[options.ConfigmapRevertedToAnnotation] == hash {
+			return fmt.Errorf("configmap '%s' in namespace '%s' was already reverted to this content", name, namespace)
+		}
+
+		revision, revisionHash, err := Latest(client, namespace, name)
+		if err != nil {
+			return err
+		}
+		if revisionHash == hash {
+			return fmt.Errorf("latest revision of configmap '%s' in namespace '%s' matches the current content", name, namespace)
+		}
+
+		configmap.Data = revision.Data
+		configmap.BinaryData = revision.BinaryData
+		if configmap.Annotations == nil {
+			configmap.Annotations = map[string]string{}
+		}
+		configmap.Annotations[options.ConfigmapRevertedToAnnotation] = revisionHash
+		_, err = client.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configmap, metav1.UpdateOptions{FieldManager: "Reloader"})
+		restored = revisionHash
+		return err
+	})
+	return restored, err
+}
+
+func newHistory(configmap *v1.ConfigMap) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Name(configmap.Name),
+			Namespace: configmap.Namespace,
+			Labels:    map[string]string{HistoryOfLabel: configmap.Name},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "ConfigMap",
+				Name:       configmap.Name,
+				UID:        configmap.UID,
+			}},
+		},
+		Data: map[string]string{},
+	}
+}
+
+// addRevision adds the revision as the latest one and removes the oldest revisions exceeding the limit or the size of
+// the history. All revisions are removed if the revision alone exceeds the size
+func addRevision(history *v1.ConfigMap, hash string, content string, limit int) error {
+	var order []string
+	for _, existing := range getOrder(history) {
+		if existing != hash {
+			order = append(order, existing)
+		}
+	}
+	order = append(order, hash)
+
+	if history.Data == nil {
+		history.Data = map[string]string{}
+	}
+	history.Data[hash] = content
+	for len(order) > limit || len(order) > 0 && getSize(history.Data) > maxSize {
+		delete(history.Data, order[0])
+		order = order[1:]
+	}
+
+	if history.Annotations == nil {
+		history.Annotations = map[string]string{}
+	}
+	history.Annotations[orderAnnotation] = strings.Join(order, ",")
+	if len(order) == 0 {
+		return fmt.Errorf("revision of %d bytes exceeds the size of the history of %d bytes", len(content), maxSize)
+	}
+	return nil
+}
+
+// getSize returns the size of the revisions
+func getSize(data map[string]string) int {
+	size := 0
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	return size
+}
+
+func getOrder(history *v1.ConfigMap) []string {
+	value := history.Annotations[orderAnnotation]
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
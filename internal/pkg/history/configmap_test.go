@@ -0,0 +1,101 @@
+package history
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecord(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	configmap := testConfigmap("1")
+	configmap.UID = "configmap-uid"
+
+	for i := 1; i <= 4; i++ {
+		configmap.Data = map[string]string{"version": fmt.Sprint(i)}
+		assert.NoError(t, Record(client, configmap, fmt.Sprintf("hash-%d", i), 3))
+	}
+	// Recording a revision again makes it the latest one
+	configmap.Data = map[string]string{"version": "2"}
+	assert.NoError(t, Record(client, configmap, "hash-2", 3))
+
+	history, err := client.CoreV1().ConfigMaps("history").Get(context.TODO(), "app-config-reloader-history", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "app-config", history.Labels[HistoryOfLabel])
+	assert.Equal(t, []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "app-config", UID: "configmap-uid"}}, history.OwnerReferences)
+	assert.Equal(t, []string{"hash-3", "hash-4", "hash-2"}, getOrder(history))
+	assert.Len(t, history.Data, 3)
+
+	revision, hash, err := Latest(client, "history", "app-config")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash-2", hash)
+	assert.Equal(t, map[string]string{"version": "2"}, revision.Data)
+}
+
+func TestRecordTrimsToSize(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	configmap := testConfigmap("1")
+
+	for i := 1; i <= 4; i++ {
+		configmap.Data = map[string]string{"content": strings.Repeat(fmt.Sprint(i), 300*1024)}
+		assert.NoError(t, Record(client, configmap, fmt.Sprintf("hash-%d", i), 5))
+	}
+	history, err := client.CoreV1().ConfigMaps("history").Get(context.TODO(), "app-config-reloader-history", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hash-3", "hash-4"}, getOrder(history))
+
+	// A revision exceeding the size alone clears the history, the previous revision must not be restored for it
+	configmap.Data = map[string]string{"content": strings.Repeat("5", maxSize)}
+	assert.Error(t, Record(client, configmap, "hash-5", 5))
+	_, _, err = Latest(client, "history", "app-config")
+	assert.Error(t, err)
+}
+
+func TestLatestWithoutHistory(t *testing.T) {
+	_, _, err := Latest(fake.NewSimpleClientset(), "history", "app-config")
+	assert.Error(t, err)
+}
+
+func TestRevert(t *testing.T) {
+	previous := testConfigmap("good")
+	current := testConfigmap("bad")
+	client := fake.NewSimpleClientset(current)
+	assert.NoError(t, Record(client, previous, util.GetSHAfromConfigmap(previous), 5))
+	badHash := util.GetSHAfromConfigmap(current)
+
+	_, err := Revert(client, "history", "app-config", "other-hash")
+	assert.Error(t, err, "a configmap changed since the reload must not be reverted")
+
+	restored, err := Revert(client, "history", "app-config", badHash)
+	assert.NoError(t, err)
+	assert.Equal(t, util.GetSHAfromConfigmap(previous), restored)
+
+	reverted, err := client.CoreV1().ConfigMaps("history").Get(context.TODO(), "app-config", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"version": "good"}, reverted.Data)
+	assert.Equal(t, restored, reverted.Annotations[options.ConfigmapRevertedToAnnotation])
+
+	// The restored content is not reverted again if its rollout fails as well
+	assert.NoError(t, Record(client, current, badHash, 5))
+	_, err = Revert(client, "history", "app-config", restored)
+	assert.Error(t, err)
+}
+
+func testConfigmap(version string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-config",
+			Namespace:   "history",
+			Annotations: map[string]string{options.ConfigmapHistoryAnnotation: "true"},
+		},
+		Data: map[string]string{"version": version},
+	}
+}
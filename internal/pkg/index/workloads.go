@@ -0,0 +1,321 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	argorolloutv1alpha1 "github.com/argoproj/argo-rollouts/pkg/apis/rollouts/v1alpha1"
+	openshiftv1 "github.com/openshift/api/apps/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/pkg/kube"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ReferenceIndex is the name of the index mapping configmaps and secrets to the workloads referencing them
+	ReferenceIndex = "references"
+	// wildcardReference is indexed for workloads whose references can not be resolved up front,
+	// e.g. regular expressions in the reload annotations or the search annotation
+	wildcardReference = "*"
+)
+
+var workloadIndex atomic.Pointer[WorkloadIndex]
+
+// PodTemplateFunc returns the pod template of a workload, nil if it has none
+type PodTemplateFunc func(runtime.Object) *v1.PodTemplateSpec
+
+// WorkloadIndex keeps informer backed caches of the workloads indexed by the configmaps and secrets they reference,
+// so a change only has to evaluate the workloads it affects instead of listing all of them
+type WorkloadIndex struct {
+	informers map[string]cache.SharedIndexInformer
+}
+
+// NewWorkloadIndex creates informers for the supported workload kinds in given namespace, keyed by their resource type
+func NewWorkloadIndex(clients kube.Clients, namespace string) *WorkloadIndex {
+	w := &WorkloadIndex{informers: make(map[string]cache.SharedIndexInformer)}
+	client := clients.KubernetesClient
+
+	w.AddWorkload("Deployment", &appsv1.Deployment{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().Deployments(namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().Deployments(namespace).Watch(context.TODO(), options)
+		},
+	}, func(item runtime.Object) *v1.PodTemplateSpec {
+		return &item.(*appsv1.Deployment).Spec.Template
+	})
+	w.AddWorkload("CronJob", &batchv1.CronJob{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().CronJobs(namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.BatchV1().CronJobs(namespace).Watch(context.TODO(), options)
+		},
+	}, func(item runtime.Object) *v1.PodTemplateSpec {
+		return &item.(*batchv1.CronJob).Spec.JobTemplate.Spec.Template
+	})
+	w.AddWorkload("Job", &batchv1.Job{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.BatchV1().Jobs(namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.BatchV1().Jobs(namespace).Watch(context.TODO(), options)
+		},
+	}, func(item runtime.Object) *v1.PodTemplateSpec {
+		return &item.(*batchv1.Job).Spec.Template
+	})
+	w.AddWorkload("DaemonSet", &appsv1.DaemonSet{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().DaemonSets(namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().DaemonSets(namespace).Watch(context.TODO(), options)
+		},
+	}, func(item runtime.Object) *v1.PodTemplateSpec {
+		return &item.(*appsv1.DaemonSet).Spec.Template
+	})
+	w.AddWorkload("StatefulSet", &appsv1.StatefulSet{}, &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.AppsV1().StatefulSets(namespace).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.AppsV1().StatefulSets(namespace).Watch(context.TODO(), options)
+		},
+	}, func(item runtime.Object) *v1.PodTemplateSpec {
+		return &item.(*appsv1.StatefulSet).Spec.Template
+	})
+
+	if kube.IsOpenshift {
+		appsClient := clients.OpenshiftAppsClient
+		w.AddWorkload("DeploymentConfig", &openshiftv1.DeploymentConfig{}, &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return appsClient.AppsV1().DeploymentConfigs(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return appsClient.AppsV1().DeploymentConfigs(namespace).Watch(context.TODO(), options)
+			},
+		}, func(item runtime.Object) *v1.PodTemplateSpec {
+			return item.(*openshiftv1.DeploymentConfig).Spec.Template
+		})
+	}
+
+	if options.IsArgoRollouts == "true" {
+		rolloutClient := clients.ArgoRolloutClient
+		w.AddWorkload("Rollout", &argorolloutv1alpha1.Rollout{}, &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return rolloutClient.ArgoprojV1alpha1().Rollouts(namespace).List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return rolloutClient.ArgoprojV1alpha1().Rollouts(namespace).Watch(context.TODO(), options)
+			},
+		}, func(item runtime.Object) *v1.PodTemplateSpec {
+			return &item.(*argorolloutv1alpha1.Rollout).Spec.Template
+		})
+	}
+
+	return w
+}
+
+// AddWorkload registers an informer for a workload kind, indexed by the references of its pod template
+func (w *WorkloadIndex) AddWorkload(resourceType string, object runtime.Object, listWatch cache.ListerWatcher, podTemplate PodTemplateFunc) {
+	informer := cache.NewSharedIndexInformer(listWatch, object, 0, cache.Indexers{
+		ReferenceIndex: referenceIndexFunc(podTemplate),
+	})
+	// Managed fields are never needed to reload a workload, drop them to keep the cache small
+	err := informer.SetTransform(func(obj interface{}) (interface{}, error) {
+		if accessor, err := meta.Accessor(obj); err == nil {
+			accessor.SetManagedFields(nil)
+		}
+		return obj, nil
+	})
+	if err != nil {
+		logrus.Warnf("Unable to set transform for %s informer: %v", resourceType, err)
+	}
+	w.informers[resourceType] = informer
+}
+
+// Run starts the informers and blocks until their caches are synced or stopCh is closed
+func (w *WorkloadIndex) Run(stopCh <-chan struct{}) bool {
+	synced := make([]cache.InformerSynced, 0, len(w.informers))
+	for resourceType, informer := range w.informers {
+		logrus.Infof("Starting workload informer for resource type: %s", resourceType)
+		go informer.Run(stopCh)
+		synced = append(synced, informer.HasSynced)
+	}
+	return cache.WaitForCacheSync(stopCh, synced...)
+}
+
+// Lookup returns copies of the workloads of given resource type which reference the configmap or secret,
+// the second return value is false if the resource type is not indexed or its cache is not synced yet
+func (w *WorkloadIndex) Lookup(resourceType string, namespace string, kind string, name string) ([]runtime.Object, bool) {
+	informer, ok := w.informers[resourceType]
+	if !ok || !informer.HasSynced() {
+		return nil, false
+	}
+
+	found := make(map[string]runtime.Object)
+	for _, key := range []string{referenceKey(namespace, kind, name), referenceKey(namespace, "", wildcardReference)} {
+		objects, err := informer.GetIndexer().ByIndex(ReferenceIndex, key)
+		if err != nil {
+			logrus.Errorf("Failed to lookup %s referencing '%s': %v", resourceType, key, err)
+			return nil, false
+		}
+		for _, obj := range objects {
+			objectKey, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil {
+				continue
+			}
+			found[objectKey] = obj.(runtime.Object)
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Objects in the cache are shared and must not be modified
+	items := make([]runtime.Object, len(keys))
+	for i, key := range keys {
+		items[i] = found[key].DeepCopyObject()
+	}
+	return items, true
+}
+
+// SetWorkloadIndex sets the index used by Lookup, nil disables the lookup
+func SetWorkloadIndex(w *WorkloadIndex) {
+	workloadIndex.Store(w)
+}
+
+// Lookup returns the workloads referencing the configmap or secret from the index set with SetWorkloadIndex,
+// callers are expected to list the workloads themselves if the second return value is false
+func Lookup(resourceType string, namespace string, kind string, name string) ([]runtime.Object, bool) {
+	w := workloadIndex.Load()
+	if w == nil {
+		return nil, false
+	}
+	return w.Lookup(resourceType, namespace, kind, name)
+}
+
+// GetReferences returns the index keys of the configmaps and secrets referenced by a workload through
+// its pod template volumes, env, envFrom or the reload annotations
+func GetReferences(namespace string, annotations map[string]string, template *v1.PodTemplateSpec) []string {
+	references := sets.New[string]()
+	addAnnotationReferences(references, namespace, annotations)
+
+	if template != nil {
+		addAnnotationReferences(references, namespace, template.Annotations)
+		addVolumeReferences(references, namespace, template.Spec.Volumes)
+		addContainerReferences(references, namespace, template.Spec.Containers)
+		addContainerReferences(references, namespace, template.Spec.InitContainers)
+	}
+
+	return sets.List(references)
+}
+
+func referenceIndexFunc(podTemplate PodTemplateFunc) cache.IndexFunc {
+	return func(obj interface{}) ([]string, error) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		object, ok := obj.(runtime.Object)
+		if !ok {
+			return nil, fmt.Errorf("unexpected object type %T", obj)
+		}
+		return GetReferences(accessor.GetNamespace(), accessor.GetAnnotations(), podTemplate(object)), nil
+	}
+}
+
+func addAnnotationReferences(references sets.Set[string], namespace string, annotations map[string]string) {
+	if annotations[options.AutoSearchAnnotation] == "true" {
+		references.Insert(referenceKey(namespace, "", wildcardReference))
+	}
+
+	for annotation, kind := range map[string]string{
+		options.ConfigmapUpdateOnChangeAnnotation: constants.ConfigmapEnvVarPostfix,
+		options.SecretUpdateOnChangeAnnotation:    constants.SecretEnvVarPostfix,
+	} {
+		value, found := annotations[annotation]
+		if !found || value == "" {
+			continue
+		}
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			// Names are matched as regular expressions, only literal names can be indexed
+			if regexp.QuoteMeta(name) != name {
+				references.Insert(referenceKey(namespace, "", wildcardReference))
+				continue
+			}
+			references.Insert(referenceKey(namespace, kind, name))
+		}
+	}
+}
+
+func addVolumeReferences(references sets.Set[string], namespace string, volumes []v1.Volume) {
+	for _, volume := range volumes {
+		if volume.ConfigMap != nil {
+			references.Insert(referenceKey(namespace, constants.ConfigmapEnvVarPostfix, volume.ConfigMap.Name))
+		}
+		if volume.Secret != nil {
+			references.Insert(referenceKey(namespace, constants.SecretEnvVarPostfix, volume.Secret.SecretName))
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					references.Insert(referenceKey(namespace, constants.ConfigmapEnvVarPostfix, source.ConfigMap.Name))
+				}
+				if source.Secret != nil {
+					references.Insert(referenceKey(namespace, constants.SecretEnvVarPostfix, source.Secret.Name))
+				}
+			}
+		}
+	}
+}
+
+func addContainerReferences(references sets.Set[string], namespace string, containers []v1.Container) {
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				references.Insert(referenceKey(namespace, constants.ConfigmapEnvVarPostfix, env.ValueFrom.ConfigMapKeyRef.Name))
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				references.Insert(referenceKey(namespace, constants.SecretEnvVarPostfix, env.ValueFrom.SecretKeyRef.Name))
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				references.Insert(referenceKey(namespace, constants.ConfigmapEnvVarPostfix, envFrom.ConfigMapRef.Name))
+			}
+			if envFrom.SecretRef != nil {
+				references.Insert(referenceKey(namespace, constants.SecretEnvVarPostfix, envFrom.SecretRef.Name))
+			}
+		}
+	}
+}
+
+func referenceKey(namespace string, kind string, name string) string {
+	if name == wildcardReference {
+		return namespace + "/" + wildcardReference
+	}
+	return namespace + "/" + kind + "/" + name
+}
@@ -0,0 +1,165 @@
+package index
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/options"
+	"github.com/stakater/Reloader/pkg/kube"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetReferences(t *testing.T) {
+	template := &v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{options.SecretUpdateOnChangeAnnotation: "annotated-secret"},
+		},
+		Spec: v1.PodSpec{
+			Volumes: []v1.Volume{
+				{Name: "cm", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: "volume-configmap"}}}},
+				{Name: "secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "volume-secret"}}},
+				{Name: "projected", VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: []v1.VolumeProjection{
+					{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: "projected-configmap"}}},
+					{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: "projected-secret"}}},
+				}}}},
+			},
+			InitContainers: []v1.Container{{
+				Name:    "init",
+				EnvFrom: []v1.EnvFromSource{{SecretRef: &v1.SecretEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "envfrom-secret"}}}},
+			}},
+			Containers: []v1.Container{{
+				Name: "app",
+				Env: []v1.EnvVar{
+					{Name: "PLAIN", Value: "value"},
+					{Name: "CM", ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "env-configmap"}, Key: "key"}}},
+					{Name: "SECRET", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{LocalObjectReference: v1.LocalObjectReference{Name: "env-secret"}, Key: "key"}}},
+				},
+				EnvFrom: []v1.EnvFromSource{{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: "envfrom-configmap"}}}},
+			}},
+		},
+	}
+	annotations := map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "annotated-configmap, other-configmap"}
+
+	references := GetReferences("ns", annotations, template)
+	assert.ElementsMatch(t, []string{
+		"ns/CONFIGMAP/annotated-configmap",
+		"ns/CONFIGMAP/other-configmap",
+		"ns/SECRET/annotated-secret",
+		"ns/CONFIGMAP/volume-configmap",
+		"ns/SECRET/volume-secret",
+		"ns/CONFIGMAP/projected-configmap",
+		"ns/SECRET/projected-secret",
+		"ns/SECRET/envfrom-secret",
+		"ns/CONFIGMAP/env-configmap",
+		"ns/SECRET/env-secret",
+		"ns/CONFIGMAP/envfrom-configmap",
+	}, references)
+}
+
+func TestGetReferencesWildcard(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{
+			name:        "Regular expression",
+			annotations: map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "my-.*"},
+		},
+		{
+			name:        "Search annotation",
+			annotations: map[string]string{options.AutoSearchAnnotation: "true"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			references := GetReferences("ns", tt.annotations, nil)
+			assert.Equal(t, []string{"ns/*"}, references)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	namespace := "test-index"
+	client := fake.NewSimpleClientset(
+		testDeployment(namespace, "uses-configmap", nil, "index-configmap"),
+		testDeployment(namespace, "uses-other", nil, "other-configmap"),
+		testDeployment(namespace, "uses-regex", map[string]string{options.ConfigmapUpdateOnChangeAnnotation: "index-.*"}, ""),
+		testDeployment("other-namespace", "uses-configmap", nil, "index-configmap"),
+	)
+
+	w := NewWorkloadIndex(kube.Clients{KubernetesClient: client}, metav1.NamespaceAll)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	_, indexed := w.Lookup("Deployment", namespace, constants.ConfigmapEnvVarPostfix, "index-configmap")
+	assert.False(t, indexed, "lookup must not be used before the cache is synced")
+
+	assert.True(t, w.Run(stop))
+
+	items, indexed := w.Lookup("Deployment", namespace, constants.ConfigmapEnvVarPostfix, "index-configmap")
+	assert.True(t, indexed)
+	assert.Equal(t, []string{"uses-configmap", "uses-regex"}, names(t, items))
+
+	items, indexed = w.Lookup("Deployment", namespace, constants.SecretEnvVarPostfix, "index-configmap")
+	assert.True(t, indexed)
+	assert.Equal(t, []string{"uses-regex"}, names(t, items))
+
+	_, indexed = w.Lookup("Unknown", namespace, constants.ConfigmapEnvVarPostfix, "index-configmap")
+	assert.False(t, indexed)
+
+	// Returned items are copies and can be modified without affecting the cache
+	items[0].(*appsv1.Deployment).Annotations["modified"] = "true"
+	items, _ = w.Lookup("Deployment", namespace, constants.SecretEnvVarPostfix, "index-configmap")
+	assert.NotContains(t, items[0].(*appsv1.Deployment).Annotations, "modified")
+
+	// Changes to workloads are reflected in the index
+	_, err := client.AppsV1().Deployments(namespace).Create(context.TODO(), testDeployment(namespace, "created", nil, "index-configmap"), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		items, _ := w.Lookup("Deployment", namespace, constants.ConfigmapEnvVarPostfix, "index-configmap")
+		return len(items) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestPackageLookup(t *testing.T) {
+	SetWorkloadIndex(nil)
+	_, indexed := Lookup("Deployment", "ns", constants.ConfigmapEnvVarPostfix, "configmap")
+	assert.False(t, indexed)
+}
+
+func testDeployment(namespace, name string, annotations map[string]string, configmap string) *appsv1.Deployment {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}}},
+		},
+	}
+	if configmap != "" {
+		deployment.Spec.Template.Spec.Containers[0].EnvFrom = []v1.EnvFromSource{
+			{ConfigMapRef: &v1.ConfigMapEnvSource{LocalObjectReference: v1.LocalObjectReference{Name: configmap}}},
+		}
+	}
+	return deployment
+}
+
+func names(t *testing.T, items []runtime.Object) []string {
+	result := make([]string, len(items))
+	for i, item := range items {
+		accessor, err := meta.Accessor(item)
+		assert.NoError(t, err)
+		result[i] = accessor.GetName()
+	}
+	return result
+}
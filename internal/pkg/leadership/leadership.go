@@ -8,6 +8,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stakater/Reloader/internal/pkg/controller"
+	"github.com/stakater/Reloader/internal/pkg/options"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
@@ -76,7 +77,7 @@ func RunLeaderElection(lock *resourcelock.LeaseLock, ctx context.Context, cancel
 func runControllers(controllers []*controller.Controller, stopChannels []chan struct{}) {
 	for i, c := range controllers {
 		c := c
-		go c.Run(1, stopChannels[i])
+		go c.Run(options.Workers, stopChannels[i])
 	}
 }
 
@@ -11,6 +11,11 @@ import (
 type Collectors struct {
 	Reloaded            *prometheus.CounterVec
 	ReloadedByNamespace *prometheus.CounterVec
+	DryRunReloads       *prometheus.CounterVec
+	RolloutDuration     *prometheus.HistogramVec
+	InPlaceReloads      *prometheus.CounterVec
+	DroppedChanges      *prometheus.CounterVec
+	DriftDetections     *prometheus.CounterVec
 }
 
 func NewCollectors() Collectors {
@@ -40,15 +45,90 @@ func NewCollectors() Collectors {
 			"namespace",
 		},
 	)
+
+	dry_run_reloads := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "dry_run_reload_total",
+			Help:      "Counter of reloads Reloader would have executed in dry run mode.",
+		},
+		[]string{
+			"namespace",
+			"workload_type",
+			"workload",
+			"source_type",
+			"source",
+		},
+	)
+
+	rollout_duration := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "reloader",
+			Name:      "reload_rollout_duration_seconds",
+			Help:      "Duration of the rollouts started by reloads until they completed or failed.",
+			Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 900, 1800},
+		},
+		[]string{
+			"workload_type",
+			"outcome",
+		},
+	)
+
+	in_place_reloads := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "in_place_reload_total",
+			Help:      "Counter of pods reloaded in place by Reloader.",
+		},
+		[]string{
+			"workload_type",
+			"method",
+			"success",
+		},
+	)
+
+	dropped_changes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "dropped_changes_total",
+			Help:      "Counter of changes of configmaps and secrets Reloader gave up processing after all retries.",
+		},
+		[]string{
+			"source_type",
+			"namespace",
+		},
+	)
+
+	drift_detections := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "reloader",
+			Name:      "drift_detections_total",
+			Help:      "Counter of workloads found by drift scans to run with an outdated content of a configmap or secret, a workload is counted by every scan finding it.",
+		},
+		[]string{
+			"workload_type",
+			"namespace",
+		},
+	)
 	return Collectors{
 		Reloaded:            reloaded,
 		ReloadedByNamespace: reloaded_by_namespace,
+		DryRunReloads:       dry_run_reloads,
+		RolloutDuration:     rollout_duration,
+		InPlaceReloads:      in_place_reloads,
+		DroppedChanges:      dropped_changes,
+		DriftDetections:     drift_detections,
 	}
 }
 
 func SetupPrometheusEndpoint() Collectors {
 	collectors := NewCollectors()
 	prometheus.MustRegister(collectors.Reloaded)
+	prometheus.MustRegister(collectors.DryRunReloads)
+	prometheus.MustRegister(collectors.RolloutDuration)
+	prometheus.MustRegister(collectors.InPlaceReloads)
+	prometheus.MustRegister(collectors.DroppedChanges)
+	prometheus.MustRegister(collectors.DriftDetections)
 
 	if os.Getenv("METRICS_COUNT_BY_NAMESPACE") == "enabled" {
 		prometheus.MustRegister(collectors.ReloadedByNamespace)
@@ -1,6 +1,10 @@
 package options
 
-import "github.com/stakater/Reloader/internal/pkg/constants"
+import (
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+)
 
 type ArgoRolloutStrategy int
 
@@ -32,6 +36,15 @@ var (
 	ConfigmapExcludeReloaderAnnotation = "configmaps.exclude.reloader.stakater.com/reload"
 	// SecretExcludeReloaderAnnotation is a comma separated list of secrets that excludes detecting changes on secrets
 	SecretExcludeReloaderAnnotation = "secrets.exclude.reloader.stakater.com/reload"
+	// ConfigmapReloadKeysAnnotation is a comma separated list of name/key pairs limiting the reloads to changes of
+	// these configmap keys
+	ConfigmapReloadKeysAnnotation = "configmap.reloader.stakater.com/reload-keys"
+	// SecretReloadKeysAnnotation is a comma separated list of name/key pairs limiting the reloads to changes of
+	// these secret keys
+	SecretReloadKeysAnnotation = "secret.reloader.stakater.com/reload-keys"
+	// HashMetadataAnnotation is a comma separated list of label:<key> and annotation:<key> entries of a configmap
+	// or secret which are included in its hash
+	HashMetadataAnnotation = "reloader.stakater.com/hash-metadata"
 	// AutoSearchAnnotation is an annotation to detect changes in
 	// configmaps or triggers with the SearchMatchAnnotation
 	AutoSearchAnnotation = "reloader.stakater.com/search"
@@ -40,6 +53,44 @@ var (
 	SearchMatchAnnotation = "reloader.stakater.com/match"
 	// RolloutStrategyAnnotation is an annotation to define rollout update strategy
 	RolloutStrategyAnnotation = "reloader.stakater.com/rollout-strategy"
+	// DebounceWindowAnnotation is an annotation to override the debounce window of a workload
+	DebounceWindowAnnotation = "reloader.stakater.com/debounce-window"
+	// ConfigmapHistoryAnnotation is an annotation to record the previous revisions of a configmap
+	ConfigmapHistoryAnnotation = "reloader.stakater.com/history"
+	// ConfigmapRevertOnFailureAnnotation is an annotation to revert a configmap to its previous revision
+	// when the rollout triggered by its change fails
+	ConfigmapRevertOnFailureAnnotation = "reloader.stakater.com/revert-on-failure"
+	// ConfigmapRevertedToAnnotation is set on a reverted configmap to the hash of the restored revision
+	ConfigmapRevertedToAnnotation = "reloader.stakater.com/reverted-to"
+	// ReloadWindowAnnotation is a cron expression on a workload or namespace at which its reload window opens
+	ReloadWindowAnnotation = "reloader.stakater.com/reload-window"
+	// ReloadWindowDurationAnnotation is the duration of the reload window of a workload or namespace
+	ReloadWindowDurationAnnotation = "reloader.stakater.com/reload-window-duration"
+	// ReloadWindowTimezoneAnnotation is the timezone of the reload window of a workload or namespace
+	ReloadWindowTimezoneAnnotation = "reloader.stakater.com/reload-window-timezone"
+	// FreezeAnnotation is an annotation on a namespace deferring all reloads in it
+	FreezeAnnotation = "reloader.stakater.com/freeze"
+	// ReloadAfterAnnotation is a list of workloads in the format [kind/]name which are reloaded and healthy before
+	// the annotated workload is reloaded for the same change
+	ReloadAfterAnnotation = "reloader.stakater.com/reload-after"
+	// ReloadStrategyAnnotation selects the reload strategy of a workload, overriding the global one
+	ReloadStrategyAnnotation = "reloader.stakater.com/reload-strategy"
+	// ReloadSignalAnnotation is the signal sent to the containers of a workload reloaded in place, SIGHUP by default
+	ReloadSignalAnnotation = "reloader.stakater.com/reload-signal"
+	// ReloadHookAnnotation is the HTTP endpoint in the format [scheme://]:port/path called on each ready pod of a
+	// workload to reload it in place instead of sending a signal, once the mounted files were verified
+	ReloadHookAnnotation = "reloader.stakater.com/reload-hook"
+	// ReloadContainerAnnotation is a list of the containers signaled when a workload is reloaded in place
+	ReloadContainerAnnotation = "reloader.stakater.com/reload-container"
+	// SignaledAnnotation is set on workloads reloaded in place to the hashes of the configmaps and secrets they were
+	// last reloaded for
+	SignaledAnnotation = "reloader.stakater.com/signaled"
+	// RestartedForAnnotation is set on the pod template of workloads restarted with the restart strategy to the hashes
+	// of the configmaps and secrets they were last restarted for
+	RestartedForAnnotation = "reloader.stakater.com/restarted-for"
+	// ConsumedHashesAnnotation is set on workloads reloaded for some keys of a configmap or secret to the hashes of
+	// the keys they consumed when they were last reloaded
+	ConsumedHashesAnnotation = "reloader.stakater.com/consumed-hashes"
 	// LogFormat is the log format to use (json, or empty string for default)
 	LogFormat = ""
 	// LogLevel is the log level to use (trace, debug, info, warning, error, fatal and panic)
@@ -51,12 +102,84 @@ var (
 	// ReloadOnCreate Adds support to watch create events
 	ReloadOnCreate = "false"
 	// ReloadOnDelete Adds support to watch delete events
-	ReloadOnDelete   = "false"
+	ReloadOnDelete = "false"
+	// SyncAfterRestart reloads the workloads which were reloaded for a previous content of a configmap or secret on
+	// start, to catch up on changes missed while Reloader was not running
 	SyncAfterRestart = false
+	// DriftScanInterval is the interval at which the workloads are checked for running with an outdated content of a
+	// configmap or secret, 0 disables the scans
+	DriftScanInterval time.Duration = 0
+	// DriftFix reloads the workloads found by the drift scans, they are only reported otherwise
+	DriftFix = false
 	// EnableHA adds support for running multiple replicas via leadership election
 	EnableHA = false
 	// Url to send a request to instead of triggering a reload
 	WebhookUrl = ""
+	// WebhookPayloadTemplate is the path to a Go template used to render the webhook payload
+	WebhookPayloadTemplate = ""
+	// WebhookHeaders is a list of custom headers in the format Name=Value sent with the webhook
+	WebhookHeaders = []string{}
+	// Workers is the number of changes of each resource type processed in parallel, changes of different namespaces
+	// are handed out in turns
+	Workers = 1
+	// MaxRetries is the number of times a change is retried before it is dropped
+	MaxRetries = 5
+	// RetryBaseDelay is the delay before the first retry of a change, it doubles with every retry
+	RetryBaseDelay = 5 * time.Millisecond
+	// RetryMaxDelay is the maximum delay between two retries of a change
+	RetryMaxDelay = 1000 * time.Second
+	// DeadLetterConfigMap is the name of the configmap in the namespace of Reloader persisting the dropped changes,
+	// they are only kept in memory if it is empty
+	DeadLetterConfigMap = ""
+	// DeadLetterAdminAddress is the address of the listener re-driving and discarding the dropped changes, they can
+	// only be listed on the metrics port if it is empty
+	DeadLetterAdminAddress = ""
+	// DryRun reports the reloads that would be performed without updating any workload
+	DryRun = false
+	// DebounceWindow is the time changes to a workload are aggregated before it is reloaded, 0 disables debouncing
+	DebounceWindow time.Duration = 0
+	// WaitForRollout follows the rollout of reloaded workloads and reports whether it succeeded
+	WaitForRollout = false
+	// RolloutTimeout is the maximum time the rollout of a reloaded workload is followed
+	RolloutTimeout = 15 * time.Minute
+	// MaxConcurrentRollouts is the maximum number of rollouts started by reloads in flight at once, 0 is unlimited
+	MaxConcurrentRollouts = 0
+	// PauseAfterFailedRollouts is the number of failed rollouts after which no further reloads are started until the
+	// failed workloads recover, 0 does not pause. It only applies with MaxConcurrentRollouts
+	PauseAfterFailedRollouts = 1
+	// MaxPausedDuration is the maximum time a change waits while reloads are paused before it is dropped, 0 is unlimited
+	MaxPausedDuration = time.Hour
+	// ReloadStagger is the minimum time between the start of two reloads
+	ReloadStagger time.Duration = 0
+	// ReloadJitter is the maximum random time added to the stagger
+	ReloadJitter time.Duration = 0
+	// EnableReloadPolicies watches ReloadPolicy resources and evaluates them together with the annotations
+	EnableReloadPolicies = false
+	// HistoryLimit is the number of previous revisions recorded for a configmap
+	HistoryLimit = 5
+	// ReloadWindow is a cron expression at which the global reload window opens, reloads are not deferred if it is empty
+	ReloadWindow = ""
+	// ReloadWindowDuration is the default duration of the reload windows
+	ReloadWindowDuration = time.Hour
+	// ReloadWindowTimezone is the default timezone of the reload windows
+	ReloadWindowTimezone = "UTC"
+	// DeferredReloadsConfigMap is the name of the configmap in the namespace of Reloader persisting the deferred
+	// reloads, they are only kept in memory if it is empty
+	DeferredReloadsConfigMap = ""
+	// SignalDelay is the time waited for changed configmaps and secrets to be propagated to the mounted files of the
+	// pods before they are reloaded in place
+	SignalDelay = 90 * time.Second
+	// SignalImage is the image of the ephemeral containers sending the signals and verifying the mounted files
+	SignalImage = "busybox:1.36"
+	// MaxEphemeralContainers is the maximum number of ephemeral containers added to a pod by in-place reloads, once
+	// it is reached the workload is restarted instead
+	MaxEphemeralContainers = 20
+	// ReloadHookTimeout is the maximum time waited for changes to be propagated to the mounted files of a pod before
+	// its reload hook is called
+	ReloadHookTimeout = 3 * time.Minute
+	// CustomWorkloads is a list of custom resources with a pod template to reload,
+	// in the format group/version/resource[:template.path]
+	CustomWorkloads = []string{}
 )
 
 func ToArgoRolloutStrategy(s string) ArgoRolloutStrategy {
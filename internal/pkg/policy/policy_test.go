@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestSelectsWorkload(t *testing.T) {
+	p := ReloadPolicy{Spec: ReloadPolicySpec{
+		WorkloadSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		WorkloadKinds:    []string{"Deployment"},
+	}}
+
+	assert.True(t, p.SelectsWorkload("Deployment", map[string]string{"team": "payments", "app": "api"}))
+	assert.False(t, p.SelectsWorkload("Deployment", map[string]string{"team": "search"}))
+	assert.False(t, p.SelectsWorkload("StatefulSet", map[string]string{"team": "payments"}))
+
+	all := ReloadPolicy{}
+	assert.True(t, all.SelectsWorkload("StatefulSet", nil))
+}
+
+func TestSelectsSource(t *testing.T) {
+	p := ReloadPolicy{Spec: ReloadPolicySpec{Sources: []SourceSelector{
+		{Kind: "ConfigMap", Names: []string{"shared-config"}},
+		{Kind: "Secret", NameRegex: "db-.*"},
+		{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"reload": "true"}}},
+	}}}
+
+	tests := []struct {
+		name       string
+		sourceType string
+		source     string
+		labels     map[string]string
+		selected   bool
+		byName     bool
+	}{
+		{name: "By name", sourceType: constants.ConfigmapEnvVarPostfix, source: "shared-config", selected: true, byName: true},
+		{name: "Name of other kind", sourceType: constants.SecretEnvVarPostfix, source: "shared-config"},
+		{name: "By regex", sourceType: constants.SecretEnvVarPostfix, source: "db-credentials", selected: true},
+		{name: "Regex matches the full name", sourceType: constants.SecretEnvVarPostfix, source: "old-db-credentials"},
+		{name: "By label", sourceType: constants.ConfigmapEnvVarPostfix, source: "other", labels: map[string]string{"reload": "true"}, selected: true},
+		{name: "Not selected", sourceType: constants.ConfigmapEnvVarPostfix, source: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, byName := p.SelectsSource(tt.sourceType, tt.source, tt.labels)
+			assert.Equal(t, tt.selected, selected)
+			assert.Equal(t, tt.byName, byName)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, (&ReloadPolicy{Spec: ReloadPolicySpec{ReloadStrategy: constants.AnnotationsReloadStrategy}}).Validate())
+	assert.NoError(t, (&ReloadPolicy{Spec: ReloadPolicySpec{ReloadStrategy: constants.SignalReloadStrategy}}).Validate())
+	assert.NoError(t, (&ReloadPolicy{Spec: ReloadPolicySpec{ReloadStrategy: constants.RestartReloadStrategy}}).Validate())
+	assert.Error(t, (&ReloadPolicy{Spec: ReloadPolicySpec{ReloadStrategy: "restart-everything"}}).Validate())
+	assert.Error(t, (&ReloadPolicy{Spec: ReloadPolicySpec{Sources: []SourceSelector{{Kind: "Pod"}}}}).Validate())
+	assert.Error(t, (&ReloadPolicy{Spec: ReloadPolicySpec{Sources: []SourceSelector{{NameRegex: "("}}}}).Validate())
+	assert.Error(t, (&ReloadPolicy{Spec: ReloadPolicySpec{WorkloadSelector: &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown"}},
+	}}}).Validate())
+}
+
+func TestStore(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ReloadPolicyResource: "ReloadPolicyList"},
+		testPolicy("b-policy", map[string]interface{}{"names": []interface{}{"app-config"}}, "annotations"),
+		testPolicy("a-policy", map[string]interface{}{"nameRegex": "app-.*"}, ""),
+		testPolicy("invalid-policy", map[string]interface{}{"kind": "Pod"}, ""),
+	)
+	store := NewStore(client, "")
+	stop := make(chan struct{})
+	defer close(stop)
+
+	SetStore(store)
+	defer SetStore(nil)
+	assert.True(t, store.Run(stop))
+
+	policies := Policies("policies")
+	assert.Len(t, policies, 2)
+	assert.Equal(t, "a-policy", policies[0].Name)
+	assert.Equal(t, time.Minute, policies[1].Spec.DebounceWindow.Duration)
+	assert.Empty(t, Policies("other"))
+
+	config := util.Config{Namespace: "policies", ResourceName: "app-config", Type: constants.ConfigmapEnvVarPostfix}
+	matched, byName := Match("Deployment", nil, config)
+	assert.Equal(t, "a-policy", matched.Name)
+	assert.False(t, byName)
+
+	config.ResourceName = "other-config"
+	matched, _ = Match("Deployment", nil, config)
+	assert.Nil(t, matched)
+}
+
+func testPolicy(name string, source map[string]interface{}, strategy string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "reloader.stakater.com/v1alpha1",
+		"kind":       "ReloadPolicy",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "policies"},
+		"spec": map[string]interface{}{
+			"sources":        []interface{}{source},
+			"reloadStrategy": strategy,
+			"debounceWindow": "1m",
+		},
+	}}
+}
@@ -0,0 +1,93 @@
+package policy
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stakater/Reloader/internal/pkg/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+var policyStore atomic.Pointer[Store]
+
+// Store keeps the ReloadPolicies in an informer backed cache
+type Store struct {
+	informer cache.SharedIndexInformer
+}
+
+// NewStore creates the informer for the ReloadPolicies in given namespace
+func NewStore(client dynamic.Interface, namespace string) *Store {
+	informer := dynamicinformer.NewFilteredDynamicInformer(client, ReloadPolicyResource, namespace, 0,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil)
+	return &Store{informer: informer.Informer()}
+}
+
+// Run starts the informer and blocks until its cache is synced or stopCh is closed
+func (s *Store) Run(stopCh <-chan struct{}) bool {
+	logrus.Info("Starting reload policy informer")
+	go s.informer.Run(stopCh)
+	return cache.WaitForCacheSync(stopCh, s.informer.HasSynced)
+}
+
+// Policies returns the valid policies of the namespace sorted by name, invalid policies are logged and skipped
+func (s *Store) Policies(namespace string) []ReloadPolicy {
+	if !s.informer.HasSynced() {
+		return nil
+	}
+
+	objects, err := s.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+	if err != nil {
+		logrus.Errorf("Failed to list reload policies in namespace '%s': %v", namespace, err)
+		return nil
+	}
+
+	policies := make([]ReloadPolicy, 0, len(objects))
+	for _, object := range objects {
+		var policy ReloadPolicy
+		err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.(*unstructured.Unstructured).Object, &policy)
+		if err == nil {
+			err = policy.Validate()
+		}
+		if err != nil {
+			logrus.Warnf("Ignoring invalid reload policy '%s' in namespace '%s': %v", object.(*unstructured.Unstructured).GetName(), namespace, err)
+			continue
+		}
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies
+}
+
+// SetStore sets the store used by Policies and Match, nil disables the policies
+func SetStore(s *Store) {
+	policyStore.Store(s)
+}
+
+// Policies returns the valid policies of the namespace from the store set with SetStore
+func Policies(namespace string) []ReloadPolicy {
+	s := policyStore.Load()
+	if s == nil {
+		return nil
+	}
+	return s.Policies(namespace)
+}
+
+// Match returns the first policy by name selecting both the workload and the changed configmap or secret,
+// byName is true if the source is listed by name in the policy
+func Match(resourceType string, workloadLabels map[string]string, config util.Config) (policy *ReloadPolicy, byName bool) {
+	for _, p := range Policies(config.Namespace) {
+		if !p.SelectsWorkload(resourceType, workloadLabels) {
+			continue
+		}
+		if selected, byName := p.SelectsSource(config.Type, config.ResourceName, config.ResourceLabels); selected {
+			return &p, byName
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/stakater/Reloader/internal/pkg/constants"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReloadPolicyResource is the resource of the ReloadPolicy custom resource definition
+var ReloadPolicyResource = schema.GroupVersionResource{Group: "reloader.stakater.com", Version: "v1alpha1", Resource: "reloadpolicies"}
+
+// ReloadPolicy selects workloads of its namespace and the configmaps and secrets they are reloaded for
+type ReloadPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ReloadPolicySpec `json:"spec"`
+}
+
+// ReloadPolicySpec is the specification of a ReloadPolicy
+type ReloadPolicySpec struct {
+	// WorkloadSelector selects the workloads by their labels, all workloads are selected if it is not set
+	WorkloadSelector *metav1.LabelSelector `json:"workloadSelector,omitempty"`
+	// WorkloadKinds limits the policy to the workload kinds, e.g. Deployment
+	WorkloadKinds []string `json:"workloadKinds,omitempty"`
+	// Sources select the configmaps and secrets the workloads are reloaded for
+	Sources []SourceSelector `json:"sources"`
+	// ReloadStrategy overrides the global reload strategy, either env-vars, annotations or signal
+	ReloadStrategy string `json:"reloadStrategy,omitempty"`
+	// DebounceWindow overrides the global debounce window
+	DebounceWindow *metav1.Duration `json:"debounceWindow,omitempty"`
+	// Alert routes the alerts for reloads of the selected workloads
+	Alert *AlertRouting `json:"alert,omitempty"`
+}
+
+// SourceSelector selects configmaps and secrets, all fields which are set have to match
+type SourceSelector struct {
+	// Kind is either ConfigMap or Secret, both are selected if it is not set
+	Kind string `json:"kind,omitempty"`
+	// Names of the selected sources, the workloads are reloaded for them even if they do not reference them
+	Names []string `json:"names,omitempty"`
+	// NameRegex is a regular expression matching the full name of the selected sources
+	NameRegex string `json:"nameRegex,omitempty"`
+	// Selector selects the sources by their labels
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// AlertRouting configures the alerts for the reloads of the selected workloads
+type AlertRouting struct {
+	// OnReload sends an alert for every reload, independent of ALERT_ON_RELOAD
+	OnReload bool `json:"onReload,omitempty"`
+	// Sinks limits the alerts to the configured sinks with these names
+	Sinks []string `json:"sinks,omitempty"`
+}
+
+// Validate checks that the selectors, regular expressions and the strategy of the policy are valid
+func (p *ReloadPolicy) Validate() error {
+	if _, err := metav1.LabelSelectorAsSelector(p.Spec.WorkloadSelector); err != nil {
+		return fmt.Errorf("invalid workloadSelector: %w", err)
+	}
+	for i, source := range p.Spec.Sources {
+		if source.Kind != "" && source.Kind != "ConfigMap" && source.Kind != "Secret" {
+			return fmt.Errorf("invalid kind '%s' of source %d, expected ConfigMap or Secret", source.Kind, i)
+		}
+		if _, err := regexp.Compile(source.NameRegex); err != nil {
+			return fmt.Errorf("invalid nameRegex of source %d: %w", i, err)
+		}
+		if _, err := metav1.LabelSelectorAsSelector(source.Selector); err != nil {
+			return fmt.Errorf("invalid selector of source %d: %w", i, err)
+		}
+	}
+	switch p.Spec.ReloadStrategy {
+	case "", constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, constants.SignalReloadStrategy, constants.RestartReloadStrategy:
+	default:
+		return fmt.Errorf("invalid reloadStrategy '%s', expected %s, %s, %s or %s", p.Spec.ReloadStrategy,
+			constants.EnvVarsReloadStrategy, constants.AnnotationsReloadStrategy, constants.SignalReloadStrategy, constants.RestartReloadStrategy)
+	}
+	return nil
+}
+
+// SelectsWorkload returns whether the workload of the resource type with the labels is selected
+func (p *ReloadPolicy) SelectsWorkload(resourceType string, workloadLabels map[string]string) bool {
+	if len(p.Spec.WorkloadKinds) > 0 && !contains(p.Spec.WorkloadKinds, resourceType) {
+		return false
+	}
+	// A nil selector selects everything
+	if p.Spec.WorkloadSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(p.Spec.WorkloadSelector)
+	return err == nil && selector.Matches(labels.Set(workloadLabels))
+}
+
+// SelectsSource returns whether the configmap or secret is selected, byName is true if it is listed by name
+// in the selecting source
+func (p *ReloadPolicy) SelectsSource(sourceType string, name string, sourceLabels map[string]string) (selected bool, byName bool) {
+	for _, source := range p.Spec.Sources {
+		if source.matches(sourceType, name, sourceLabels) {
+			return true, len(source.Names) > 0
+		}
+	}
+	return false, false
+}
+
+func (s SourceSelector) matches(sourceType string, name string, sourceLabels map[string]string) bool {
+	switch s.Kind {
+	case "ConfigMap":
+		if sourceType != constants.ConfigmapEnvVarPostfix {
+			return false
+		}
+	case "Secret":
+		if sourceType != constants.SecretEnvVarPostfix {
+			return false
+		}
+	}
+	if len(s.Names) > 0 && !contains(s.Names, name) {
+		return false
+	}
+	if s.NameRegex != "" {
+		re, err := regexp.Compile("^" + s.NameRegex + "$")
+		if err != nil || !re.MatchString(name) {
+			return false
+		}
+	}
+	if s.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(s.Selector)
+		if err != nil || !selector.Matches(labels.Set(sourceLabels)) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears limits the search for the next matching time of expressions which never match, e.g. 30 February
+const maxSearchYears = 5
+
+var (
+	monthNames = map[string]int{"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6, "JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12}
+	dayNames   = map[string]int{"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6}
+)
+
+// Cron is a parsed cron expression with the fields minute, hour, day of month, month and day of week
+type Cron struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// Like in cron the day matches either field if both are restricted
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCron parses a cron expression with five fields. Fields support '*', lists, ranges, steps and the names of
+// months and days of the week, 7 is Sunday like 0
+func ParseCron(expression string) (*Cron, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression '%s', expected 5 fields but found %d", expression, len(fields))
+	}
+
+	var c Cron
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in cron expression '%s': %w", expression, err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in cron expression '%s': %w", expression, err)
+	}
+	if c.dayOfMonth, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in cron expression '%s': %w", expression, err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month in cron expression '%s': %w", expression, err)
+	}
+	if c.dayOfWeek, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week in cron expression '%s': %w", expression, err)
+	}
+	if c.dayOfWeek&(1<<7) != 0 {
+		c.dayOfWeek |= 1
+	}
+	c.anyDayOfMonth = fields[2] == "*"
+	c.anyDayOfWeek = fields[4] == "*"
+	return &c, nil
+}
+
+// Next returns the first minute after the time matching the expression in the location of the time,
+// the zero time is returned if there is none within the next years
+func (c *Cron) Next(after time.Time) time.Time {
+	location := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// parseField parses a comma separated list of values, ranges and steps into a bit set
+func parseField(field string, minimum int, maximum int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepValue, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepValue)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s'", stepValue)
+			}
+		}
+
+		start, end := minimum, maximum
+		if valueRange != "*" {
+			startValue, endValue, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if start, err = parseValue(startValue, minimum, maximum, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseValue(endValue, minimum, maximum, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = maximum
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range '%s'", valueRange)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(value string, minimum int, maximum int, names map[string]int) (int, error) {
+	if number, found := names[strings.ToUpper(value)]; found {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < minimum || number > maximum {
+		return 0, fmt.Errorf("invalid value '%s', expected %d-%d", value, minimum, maximum)
+	}
+	return number, nil
+}
@@ -0,0 +1,76 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expression := range []string{"* * * * *", "0 22 * * 1-4", "*/15 9-17 * * MON-FRI", "0 0 1,15 * *", "30 2 * JAN-MAR 0,7"} {
+		_, err := ParseCron(expression)
+		assert.NoError(t, err, expression)
+	}
+	for _, expression := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * * FOO"} {
+		_, err := ParseCron(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		expression string
+		after      string
+		expected   string
+	}{
+		{expression: "* * * * *", after: "2025-05-02T10:00:30Z", expected: "2025-05-02T10:01:00Z"},
+		{expression: "0 22 * * 1-4", after: "2025-05-02T10:00:00Z", expected: "2025-05-05T22:00:00Z"},
+		{expression: "*/15 9-17 * * MON-FRI", after: "2025-05-02T17:50:00Z", expected: "2025-05-05T09:00:00Z"},
+		{expression: "0 0 1,15 * *", after: "2025-05-02T10:00:00Z", expected: "2025-05-15T00:00:00Z"},
+		// Both day fields are restricted, either of them matches
+		{expression: "0 0 13 * FRI", after: "2025-05-02T10:00:00Z", expected: "2025-05-09T00:00:00Z"},
+		{expression: "0 12 * * 7", after: "2025-05-02T10:00:00Z", expected: "2025-05-04T12:00:00Z"},
+		{expression: "0 0 30 2 *", after: "2025-05-02T10:00:00Z", expected: "0001-01-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			cron, err := ParseCron(tt.expression)
+			assert.NoError(t, err)
+			after, _ := time.Parse(time.RFC3339, tt.after)
+			assert.Equal(t, tt.expected, cron.Next(after).UTC().Format(time.RFC3339))
+		})
+	}
+}
+
+func TestWindow(t *testing.T) {
+	// Opens weekdays at 22:00 in Berlin for two hours, 20:00 UTC in summer
+	window, err := NewWindow("0 22 * * MON-FRI", 2*time.Hour, "Europe/Berlin")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		now      string
+		open     bool
+		nextOpen string
+	}{
+		{now: "2025-05-05T19:59:00Z", open: false, nextOpen: "2025-05-05T20:00:00Z"},
+		{now: "2025-05-05T20:00:00Z", open: true, nextOpen: "2025-05-05T20:00:00Z"},
+		{now: "2025-05-05T21:59:00Z", open: true, nextOpen: "2025-05-05T21:59:00Z"},
+		{now: "2025-05-05T22:00:00Z", open: false, nextOpen: "2025-05-06T20:00:00Z"},
+		{now: "2025-05-03T21:00:00Z", open: false, nextOpen: "2025-05-05T20:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.now, func(t *testing.T) {
+			now, _ := time.Parse(time.RFC3339, tt.now)
+			assert.Equal(t, tt.open, window.Open(now))
+			assert.Equal(t, tt.nextOpen, window.NextOpen(now).UTC().Format(time.RFC3339))
+		})
+	}
+
+	_, err = NewWindow("0 22 * * *", 0, "")
+	assert.Error(t, err)
+	_, err = NewWindow("0 22 * * *", time.Hour, "Mars/Olympus")
+	assert.Error(t, err)
+}
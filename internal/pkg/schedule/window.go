@@ -0,0 +1,44 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window is a recurring period which starts at the times matching a cron expression and lasts for a duration
+type Window struct {
+	start    *Cron
+	duration time.Duration
+	location *time.Location
+}
+
+// NewWindow creates a window starting at the times of the cron expression in the timezone, an empty timezone is UTC
+func NewWindow(expression string, duration time.Duration, timezone string) (*Window, error) {
+	start, err := ParseCron(expression)
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid window duration %s, expected a positive duration", duration)
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone '%s': %w", timezone, err)
+	}
+	return &Window{start: start, duration: duration, location: location}, nil
+}
+
+// Open returns whether the window is open at the time
+func (w *Window) Open(now time.Time) bool {
+	// The window is open if it started after now minus its duration but not after now
+	start := w.start.Next(now.In(w.location).Add(-w.duration))
+	return !start.IsZero() && !start.After(now)
+}
+
+// NextOpen returns the time the window opens next, now if it is open and the zero time if it never opens
+func (w *Window) NextOpen(now time.Time) time.Time {
+	if w.Open(now) {
+		return now
+	}
+	return w.start.Next(now.In(w.location))
+}
@@ -11,10 +11,18 @@ type Config struct {
 	Namespace           string
 	ResourceName        string
 	ResourceAnnotations map[string]string
+	ResourceLabels      map[string]string
 	Annotation          string
 	TypedAutoAnnotation string
+	KeysAnnotation      string
 	SHAValue            string
 	Type                string
+	// ChangedKeys are the keys changed by an update, nil if they are unknown e.g. for a created resource
+	ChangedKeys []string
+	// KeySHAs are the SHAs of the keys of the current content, nil if the content is unknown
+	KeySHAs map[string]string
+	// ReloadStrategy overrides the global reload strategy, it is set for changes selected by a ReloadPolicy
+	ReloadStrategy string
 }
 
 // GetConfigmapConfig provides utility config for configmap
@@ -23,10 +31,13 @@ func GetConfigmapConfig(configmap *v1.ConfigMap) Config {
 		Namespace:           configmap.Namespace,
 		ResourceName:        configmap.Name,
 		ResourceAnnotations: configmap.Annotations,
+		ResourceLabels:      configmap.Labels,
 		Annotation:          options.ConfigmapUpdateOnChangeAnnotation,
 		TypedAutoAnnotation: options.ConfigmapReloaderAutoAnnotation,
+		KeysAnnotation:      options.ConfigmapReloadKeysAnnotation,
 		SHAValue:            GetSHAfromConfigmap(configmap),
 		Type:                constants.ConfigmapEnvVarPostfix,
+		KeySHAs:             GetKeySHAsFromConfigmap(configmap),
 	}
 }
 
@@ -36,9 +47,12 @@ func GetSecretConfig(secret *v1.Secret) Config {
 		Namespace:           secret.Namespace,
 		ResourceName:        secret.Name,
 		ResourceAnnotations: secret.Annotations,
+		ResourceLabels:      secret.Labels,
 		Annotation:          options.SecretUpdateOnChangeAnnotation,
 		TypedAutoAnnotation: options.SecretReloaderAutoAnnotation,
-		SHAValue:            GetSHAfromSecret(secret.Data),
+		KeysAnnotation:      options.SecretReloadKeysAnnotation,
+		SHAValue:            GetSHAfromSecret(secret),
 		Type:                constants.SecretEnvVarPostfix,
+		KeySHAs:             GetKeySHAsFromSecret(secret),
 	}
 }
@@ -7,7 +7,9 @@ import (
 	"strings"
 
 	"github.com/stakater/Reloader/internal/pkg/crypto"
+	"github.com/stakater/Reloader/internal/pkg/options"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ConvertToEnvVarName converts the given text into a usable env var
@@ -39,19 +41,97 @@ func GetSHAfromConfigmap(configmap *v1.ConfigMap) string {
 	for k, v := range configmap.BinaryData {
 		values = append(values, k+"="+base64.StdEncoding.EncodeToString(v))
 	}
+	for k, v := range GetHashedMetadata(configmap.ObjectMeta) {
+		values = append(values, k+"="+v)
+	}
 	sort.Strings(values)
 	return crypto.GenerateSHA(strings.Join(values, ";"))
 }
 
-func GetSHAfromSecret(data map[string][]byte) string {
+func GetSHAfromSecret(secret *v1.Secret) string {
 	values := []string{}
-	for k, v := range data {
+	for k, v := range secret.Data {
 		values = append(values, k+"="+string(v[:]))
 	}
+	for k, v := range GetHashedMetadata(secret.ObjectMeta) {
+		values = append(values, k+"="+v)
+	}
 	sort.Strings(values)
 	return crypto.GenerateSHA(strings.Join(values, ";"))
 }
 
+// GetHashedMetadata returns the labels and annotations listed in the hash metadata annotation keyed by
+// label:<key> and annotation:<key>, missing labels and annotations are skipped
+func GetHashedMetadata(meta metav1.ObjectMeta) map[string]string {
+	metadata := map[string]string{}
+	for _, entry := range strings.Split(meta.Annotations[options.HashMetadataAnnotation], ",") {
+		kind, key, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		var value string
+		var found bool
+		switch kind {
+		case "label":
+			value, found = meta.Labels[key]
+		case "annotation":
+			value, found = meta.Annotations[key]
+		}
+		if found {
+			metadata[kind+":"+key] = value
+		}
+	}
+	return metadata
+}
+
+// IsMetadataKey returns whether the key returned by GetKeySHAsFromConfigmap or GetKeySHAsFromSecret is a label or
+// annotation, keys of the data never contain a colon
+func IsMetadataKey(key string) bool {
+	return strings.Contains(key, ":")
+}
+
+// GetKeySHAsFromConfigmap returns the SHA of every key of the configmap data and binary data and of the metadata
+// opted into the hash
+func GetKeySHAsFromConfigmap(configmap *v1.ConfigMap) map[string]string {
+	shas := make(map[string]string, len(configmap.Data)+len(configmap.BinaryData))
+	for k, v := range configmap.Data {
+		shas[k] = crypto.GenerateSHA(v)
+	}
+	for k, v := range configmap.BinaryData {
+		shas[k] = crypto.GenerateSHA(base64.StdEncoding.EncodeToString(v))
+	}
+	for k, v := range GetHashedMetadata(configmap.ObjectMeta) {
+		shas[k] = crypto.GenerateSHA(v)
+	}
+	return shas
+}
+
+// GetKeySHAsFromSecret returns the SHA of every key of the secret data and of the metadata opted into the hash
+func GetKeySHAsFromSecret(secret *v1.Secret) map[string]string {
+	shas := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		shas[k] = crypto.GenerateSHA(string(v))
+	}
+	for k, v := range GetHashedMetadata(secret.ObjectMeta) {
+		shas[k] = crypto.GenerateSHA(v)
+	}
+	return shas
+}
+
+// GetChangedKeys returns the sorted keys which were added, removed or changed between the old and new key SHAs
+func GetChangedKeys(oldSHAs map[string]string, newSHAs map[string]string) []string {
+	changed := []string{}
+	for k, v := range newSHAs {
+		if oldSHA, found := oldSHAs[k]; !found || oldSHA != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range oldSHAs {
+		if _, found := newSHAs[k]; !found {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
 type List []string
 
 type Map map[string]string
@@ -1,8 +1,11 @@
 package util
 
 import (
+	"reflect"
 	"testing"
 
+	"github.com/stakater/Reloader/internal/pkg/crypto"
+	"github.com/stakater/Reloader/internal/pkg/options"
 	v1 "k8s.io/api/core/v1"
 )
 
@@ -45,3 +48,66 @@ func TestGetHashFromConfigMap(t *testing.T) {
 		}
 	}
 }
+
+func TestGetChangedKeys(t *testing.T) {
+	old := GetKeySHAsFromConfigmap(&v1.ConfigMap{
+		Data:       map[string]string{"unchanged": "a", "changed": "b", "removed": "c"},
+		BinaryData: map[string][]byte{"binary": []byte("d")},
+	})
+	updated := GetKeySHAsFromConfigmap(&v1.ConfigMap{
+		Data:       map[string]string{"unchanged": "a", "changed": "x", "added": "y"},
+		BinaryData: map[string][]byte{"binary": []byte("d")},
+	})
+
+	changed := GetChangedKeys(old, updated)
+	expected := []string{"added", "changed", "removed"}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changed keys %v but got %v", expected, changed)
+	}
+
+	secret := GetKeySHAsFromSecret(&v1.Secret{Data: map[string][]byte{"password": []byte("secret")}})
+	if changed := GetChangedKeys(secret, secret); changed == nil || len(changed) != 0 {
+		t.Errorf("Expected no changed keys but got %v", changed)
+	}
+}
+
+func TestGetSHAWithHashedMetadata(t *testing.T) {
+	configmap := &v1.ConfigMap{Data: map[string]string{"test": "test"}}
+	dataSHA := GetSHAfromConfigmap(configmap)
+
+	configmap.Labels = map[string]string{"version": "1"}
+	configmap.Annotations = map[string]string{"flags.example.com/revision": "7"}
+	if GetSHAfromConfigmap(configmap) != dataSHA {
+		t.Errorf("Metadata which is not opted in changed the hash")
+	}
+
+	configmap.Annotations[options.HashMetadataAnnotation] = "label:version, annotation:flags.example.com/revision, label:missing"
+	expected := map[string]string{"label:version": "1", "annotation:flags.example.com/revision": "7"}
+	if metadata := GetHashedMetadata(configmap.ObjectMeta); !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("Expected hashed metadata %v but got %v", expected, metadata)
+	}
+	metadataSHA := GetSHAfromConfigmap(configmap)
+	if metadataSHA == dataSHA {
+		t.Errorf("Opted in metadata did not change the hash")
+	}
+
+	old := GetKeySHAsFromConfigmap(configmap)
+	configmap.Annotations["flags.example.com/revision"] = "8"
+	if GetSHAfromConfigmap(configmap) == metadataSHA {
+		t.Errorf("Change of opted in annotation did not change the hash")
+	}
+	if changed := GetChangedKeys(old, GetKeySHAsFromConfigmap(configmap)); !reflect.DeepEqual(changed, []string{"annotation:flags.example.com/revision"}) || !IsMetadataKey(changed[0]) {
+		t.Errorf("Expected the annotation as changed metadata key but got %v", changed)
+	}
+
+	secret := &v1.Secret{Data: map[string][]byte{"password": []byte("secret")}}
+	secretSHA := GetSHAfromSecret(secret)
+	if secretSHA != crypto.GenerateSHA("password=secret") {
+		t.Errorf("Secret hash without opted in metadata differs from the data hash")
+	}
+	secret.Labels = map[string]string{"version": "1"}
+	secret.Annotations = map[string]string{options.HashMetadataAnnotation: "label:version"}
+	if GetSHAfromSecret(secret) == secretSHA {
+		t.Errorf("Opted in secret label did not change the hash")
+	}
+}
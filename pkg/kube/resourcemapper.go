@@ -9,5 +9,4 @@ import (
 var ResourceMap = map[string]runtime.Object{
 	"configMaps": &v1.ConfigMap{},
 	"secrets":    &v1.Secret{},
-	"namespaces":    &v1.Namespace{},
 }